@@ -0,0 +1,130 @@
+package agent
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/xray"
+)
+
+// fakeNode puts fake xray and systemctl binaries on PATH that log their
+// arguments to the returned file and answer like a node with one online
+// user.
+func fakeNode(t *testing.T) string {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	scripts := map[string]string{
+		"xray": `echo "xray $*" >> ` + calls + `
+case "$2" in
+statsquery) echo '{"stat":[{"name":"uplink","value":"1000"},{"name":"downlink","value":24}]}' ;;
+statsonlineiplist) echo '{"ips":{"203.0.113.7":1700000000}}' ;;
+inbounduser) case "$3" in add|remove) ;; *) echo '{"users":[{"email":"user_42@myserver"}]}' ;; esac ;;
+esac
+`,
+		"systemctl": `echo "systemctl $*" >> ` + calls + "\n",
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return calls
+}
+
+// startAgent runs an agent over a local Xray client of a fake node and
+// returns the bot's client of that node.
+func startAgent(t *testing.T, secret string) (*httptest.Server, *xray.Client, string) {
+	calls := fakeNode(t)
+	node := xray.NewClient(&config.Config{
+		XrayAPIAddress: "127.0.0.1:10085",
+		XrayTag:        "vless-in",
+		ConfigPath:     filepath.Join(t.TempDir(), "config.json"),
+		XrayRestart:    "systemd",
+	})
+	server := httptest.NewServer(NewServer(node, secret))
+	t.Cleanup(server.Close)
+
+	bot := xray.NewClient(&config.Config{ServerDomain: "node.example.com", ServerPort: 443})
+	return server, bot.WithRemote(NewClient(server.URL, secret)), calls
+}
+
+func TestAgent(t *testing.T) {
+	_, client, calls := startAgent(t, "secret")
+
+	if err := client.TestAPI(); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := client.AddUser("11111111-1111-1111-1111-111111111111", "user_42@myserver"); err != nil || status != xray.Applied {
+		t.Fatalf("AddUser returned %q, %v", status, err)
+	}
+	if emails, err := client.UserEmails(); err != nil || len(emails) != 1 || emails[0] != "user_42@myserver" {
+		t.Fatalf("UserEmails returned %v, %v", emails, err)
+	}
+	if traffic, err := client.UserTraffic("user_42@myserver"); err != nil || traffic != 1024 {
+		t.Fatalf("UserTraffic returned %d, %v", traffic, err)
+	}
+	if ips, err := client.OnlineIPs("user_42@myserver"); err != nil || len(ips) != 1 || ips[0] != "203.0.113.7" {
+		t.Fatalf("OnlineIPs returned %v, %v", ips, err)
+	}
+	if status, err := client.RemoveUser("user_42@myserver"); err != nil || status != xray.Applied {
+		t.Fatalf("RemoveUser returned %q, %v", status, err)
+	}
+	if err := client.Restart(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := string(data)
+	for _, call := range []string{
+		`xray api inbounduser add --server=127.0.0.1:10085 -tag=vless-in -user={"email":"user_42@myserver","id":"11111111-1111-1111-1111-111111111111"`,
+		"xray api statsquery --server=127.0.0.1:10085 -pattern=user>>>user_42@myserver>>>traffic",
+		"xray api inbounduser remove --server=127.0.0.1:10085 -tag=vless-in -email=user_42@myserver",
+		"systemctl restart xray",
+	} {
+		if !strings.Contains(log, call) {
+			t.Errorf("node did not run %s, it ran:\n%s", call, log)
+		}
+	}
+}
+
+func TestAgentRejectsUnsignedRequests(t *testing.T) {
+	server, _, calls := startAgent(t, "secret")
+
+	if _, err := NewClient(server.URL, "wrong").AddUser("11111111-1111-1111-1111-111111111111", "user_42@myserver"); err == nil ||
+		!strings.Contains(err.Error(), errUnauthorized.Error()) {
+		t.Fatalf("request with a wrong secret returned %v", err)
+	}
+
+	// A captured request stops working once its timestamp is too old.
+	body := `{"email":"user_42@myserver"}`
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/v1/users/remove", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timestamp := strconv.FormatInt(time.Now().Add(-maxClockSkew-time.Minute).Unix(), 10)
+	req.Header.Set(headerTimestamp, timestamp)
+	req.Header.Set(headerSignature, hex.EncodeToString(sign([]byte("secret"), req.Method, req.URL.RequestURI(), timestamp, []byte(body))))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("stale request answered %d", resp.StatusCode)
+	}
+
+	if _, err := os.Stat(calls); !os.IsNotExist(err) {
+		t.Fatal("unsigned requests reached Xray")
+	}
+}
@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Requests are signed with a hex HMAC-SHA256 over the method, the request
+// URI, a Unix timestamp and the body, using the secret shared by the bot and
+// the agent. The timestamp bounds how long a captured request can be
+// replayed.
+const (
+	headerTimestamp = "X-Agent-Timestamp"
+	headerSignature = "X-Agent-Signature"
+	maxClockSkew    = 5 * time.Minute
+)
+
+var errUnauthorized = errors.New("invalid signature")
+
+func sign(secret []byte, method, uri, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + uri + "\n" + timestamp + "\n"))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+func signRequest(r *http.Request, secret []byte, body []byte) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	r.Header.Set(headerTimestamp, timestamp)
+	r.Header.Set(headerSignature, hex.EncodeToString(sign(secret, r.Method, r.URL.RequestURI(), timestamp, body)))
+}
+
+func verifyRequest(r *http.Request, secret []byte, body []byte) error {
+	timestamp := r.Header.Get(headerTimestamp)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errUnauthorized
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > maxClockSkew || skew < -maxClockSkew {
+		return errUnauthorized
+	}
+
+	signature, err := hex.DecodeString(r.Header.Get(headerSignature))
+	if err != nil || !hmac.Equal(signature, sign(secret, r.Method, r.URL.RequestURI(), timestamp, body)) {
+		return errUnauthorized
+	}
+	return nil
+}
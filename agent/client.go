@@ -0,0 +1,137 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/xray"
+)
+
+// Client talks to an agent and implements xray.Remote.
+type Client struct {
+	baseURL string
+	secret  []byte
+	http    *http.Client
+}
+
+func NewClient(baseURL, secret string) *Client {
+	return &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		secret:  []byte(secret),
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (c *Client) Health() error {
+	return c.do(http.MethodGet, "/v1/health", nil, nil)
+}
+
+func (c *Client) AddUser(userUUID, email string) (xray.Status, error) {
+	var resp userResponse
+	err := c.do(http.MethodPost, "/v1/users/add", userRequest{UUID: userUUID, Email: email}, &resp)
+	return resp.Status, err
+}
+
+func (c *Client) RemoveUser(email string) (xray.Status, error) {
+	var resp userResponse
+	err := c.do(http.MethodPost, "/v1/users/remove", userRequest{Email: email}, &resp)
+	return resp.Status, err
+}
+
+func (c *Client) UserEmails() ([]string, error) {
+	var resp usersResponse
+	err := c.do(http.MethodGet, "/v1/users", nil, &resp)
+	return resp.Emails, err
+}
+
+func (c *Client) OnlineIPs(email string) ([]string, error) {
+	var resp onlineResponse
+	err := c.do(http.MethodGet, "/v1/stats/online?email="+url.QueryEscape(email), nil, &resp)
+	return resp.IPs, err
+}
+
+func (c *Client) UserTraffic(email string) (int64, error) {
+	var resp trafficResponse
+	err := c.do(http.MethodGet, "/v1/stats/traffic?email="+url.QueryEscape(email), nil, &resp)
+	return resp.Bytes, err
+}
+
+func (c *Client) InboundTraffic() (int64, error) {
+	var resp trafficResponse
+	err := c.do(http.MethodGet, "/v1/stats/inbound", nil, &resp)
+	return resp.Bytes, err
+}
+
+func (c *Client) SyncRoutingProfiles(profiles []config.RoutingProfile, emails map[string][]string) error {
+	return c.do(http.MethodPost, "/v1/routing", routingRequest{Profiles: profiles, Emails: emails}, nil)
+}
+
+func (c *Client) ConfigSnapshot() ([]byte, error) {
+	var snapshot []byte
+	err := c.do(http.MethodGet, "/v1/config", nil, &snapshot)
+	return snapshot, err
+}
+
+func (c *Client) Certificates() ([]xray.Certificate, error) {
+	var certificates []xray.Certificate
+	err := c.do(http.MethodGet, "/v1/certificates", nil, &certificates)
+	return certificates, err
+}
+
+func (c *Client) Restart() error {
+	return c.do(http.MethodPost, "/v1/restart", nil, nil)
+}
+
+// do sends a signed request with in as the JSON body and decodes the JSON
+// response into out. A *[]byte out receives the raw response.
+func (c *Client) do(method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signRequest(req, c.secret, body)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("agent request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		var agentErr errorResponse
+		if json.Unmarshal(data, &agentErr) == nil && agentErr.Error != "" {
+			return fmt.Errorf("agent: %s", agentErr.Error)
+		}
+		return fmt.Errorf("agent: status %d", resp.StatusCode)
+	}
+
+	switch out := out.(type) {
+	case nil:
+		return nil
+	case *[]byte:
+		*out = data
+		return nil
+	default:
+		return json.Unmarshal(data, out)
+	}
+}
@@ -0,0 +1,217 @@
+// Package agent runs next to Xray on a VPN node and lets the bot manage it
+// over HTTP.
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/xray"
+)
+
+type userRequest struct {
+	UUID  string `json:"uuid,omitempty"`
+	Email string `json:"email"`
+}
+
+type userResponse struct {
+	Status xray.Status `json:"status"`
+}
+
+type routingRequest struct {
+	Profiles []config.RoutingProfile `json:"profiles"`
+	Emails   map[string][]string     `json:"emails"`
+}
+
+type usersResponse struct {
+	Emails []string `json:"emails"`
+}
+
+type onlineResponse struct {
+	IPs []string `json:"ips"`
+}
+
+type trafficResponse struct {
+	Bytes int64 `json:"bytes"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server exposes a local Xray client to signed requests from the bot.
+type Server struct {
+	client *xray.Client
+	secret []byte
+	mux    *http.ServeMux
+}
+
+func NewServer(client *xray.Client, secret string) *Server {
+	s := &Server{
+		client: client,
+		secret: []byte(secret),
+		mux:    http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("GET /v1/health", s.handleHealth)
+	s.mux.HandleFunc("GET /v1/users", s.handleUsers)
+	s.mux.HandleFunc("POST /v1/users/add", s.handleAddUser)
+	s.mux.HandleFunc("POST /v1/users/remove", s.handleRemoveUser)
+	s.mux.HandleFunc("GET /v1/stats/online", s.handleOnline)
+	s.mux.HandleFunc("GET /v1/stats/traffic", s.handleTraffic)
+	s.mux.HandleFunc("GET /v1/stats/inbound", s.handleInbound)
+	s.mux.HandleFunc("POST /v1/routing", s.handleRouting)
+	s.mux.HandleFunc("GET /v1/config", s.handleConfig)
+	s.mux.HandleFunc("GET /v1/certificates", s.handleCertificates)
+	s.mux.HandleFunc("POST /v1/restart", s.handleRestart)
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 8<<20))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := verifyRequest(r, s.secret, body); err != nil {
+		log.Printf("Rejected agent request %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if err := s.client.TestAPI(); err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, struct{}{})
+}
+
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	emails, err := s.client.UserEmails()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, usersResponse{Emails: emails})
+}
+
+func (s *Server) handleAddUser(w http.ResponseWriter, r *http.Request) {
+	var req userRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	status, err := s.client.AddUser(req.UUID, req.Email)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, userResponse{Status: status})
+}
+
+func (s *Server) handleRemoveUser(w http.ResponseWriter, r *http.Request) {
+	var req userRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	status, err := s.client.RemoveUser(req.Email)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, userResponse{Status: status})
+}
+
+func (s *Server) handleOnline(w http.ResponseWriter, r *http.Request) {
+	ips, err := s.client.OnlineIPs(r.URL.Query().Get("email"))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, onlineResponse{IPs: ips})
+}
+
+func (s *Server) handleTraffic(w http.ResponseWriter, r *http.Request) {
+	bytes, err := s.client.UserTraffic(r.URL.Query().Get("email"))
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, trafficResponse{Bytes: bytes})
+}
+
+func (s *Server) handleInbound(w http.ResponseWriter, r *http.Request) {
+	bytes, err := s.client.InboundTraffic()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, trafficResponse{Bytes: bytes})
+}
+
+func (s *Server) handleRouting(w http.ResponseWriter, r *http.Request) {
+	var req routingRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if err := s.client.SyncRoutingProfiles(req.Profiles, req.Emails); err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, struct{}{})
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	data, err := s.client.ConfigSnapshot()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (s *Server) handleCertificates(w http.ResponseWriter, r *http.Request) {
+	certificates, err := s.client.Certificates()
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, certificates)
+}
+
+func (s *Server) handleRestart(w http.ResponseWriter, r *http.Request) {
+	if err := s.client.Restart(); err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, struct{}{})
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{Error: err.Error()})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+	"xray-telegram-bot/agent"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/xray"
+)
+
+// The agent runs next to Xray on a VPN node. It reuses the bot's local Xray
+// client, so it edits the node's config file and restarts Xray there.
+func main() {
+	secret := os.Getenv("AGENT_SECRET")
+	if secret == "" {
+		log.Fatal("AGENT_SECRET is required")
+	}
+
+	cfg := &config.Config{
+		XrayAPIAddress: getenv("XRAY_API", "127.0.0.1:10085"),
+		XrayTag:        getenv("XRAY_TAG", "vless_tls"),
+		ConfigPath:     getenv("XRAY_CONFIG", "/usr/local/etc/xray/config.json"),
+		XrayRestart:    getenv("XRAY_RESTART", "systemd"),
+		DockerSocket:   getenv("DOCKER_SOCKET", "/var/run/docker.sock"),
+	}
+	debounce, err := time.ParseDuration(getenv("XRAY_FALLBACK_DEBOUNCE", "10s"))
+	if err != nil {
+		log.Fatal("Invalid XRAY_FALLBACK_DEBOUNCE:", err)
+	}
+	budget, err := strconv.Atoi(getenv("XRAY_RESTART_BUDGET", "6"))
+	if err != nil {
+		log.Fatal("Invalid XRAY_RESTART_BUDGET:", err)
+	}
+	cfg.FallbackDebounce, cfg.RestartBudget = debounce, budget
+
+	client := xray.NewClient(cfg)
+	if err := client.InitAPI(); err != nil {
+		log.Printf("Warning: Failed to initialize Xray API: %v", err)
+	}
+	if err := client.Supervise(); err != nil {
+		log.Fatal("Failed to start Xray:", err)
+	}
+
+	server := &http.Server{
+		Addr:              getenv("AGENT_LISTEN", ":9090"),
+		Handler:           agent.NewServer(client, secret),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	certFile, keyFile := os.Getenv("AGENT_TLS_CERT"), os.Getenv("AGENT_TLS_KEY")
+	log.Printf("Agent listening on %s", server.Addr)
+	if certFile != "" && keyFile != "" {
+		err = server.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	log.Fatal(err)
+}
+
+func getenv(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,43 @@
+// Command events prints the provisioning timeline of one user from the bot
+// database.
+//
+//	go run ./cmd/events -user 123456789
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/database"
+	"xray-telegram-bot/messages"
+)
+
+func main() {
+	userID := flag.Int64("user", 0, "Telegram user ID")
+	limit := flag.Int("limit", 100, "maximum number of events to print")
+	flag.Parse()
+
+	if *userID == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cfg := config.Load()
+
+	db, err := database.New(cfg.DatabasePath)
+	if err != nil {
+		log.Fatal("Failed to open database:", err)
+	}
+	defer db.Close()
+
+	events, err := db.GetUserEvents(*userID, *limit)
+	if err != nil {
+		log.Fatal("Failed to query events:", err)
+	}
+
+	for _, event := range events {
+		fmt.Println(messages.FormatEvent(event))
+	}
+}
@@ -0,0 +1,187 @@
+// Command import takes over VPN clients defined outside the bot, from an
+// Xray config inbound or a 3x-ui/x-ui database, keeping their UUIDs. It
+// prints what it would do unless run with -apply.
+//
+//	go run ./cmd/import -xray-config /usr/local/etc/xray/config.json -mapping users.csv
+//	go run ./cmd/import -xui /etc/x-ui/x-ui.db -server main -apply
+//
+// Clients are matched to Telegram users by the mapping CSV ("email or
+// remark,telegram id"), the Telegram ID a 3x-ui client has, or an email that
+// is a Telegram ID. Clients imported from the config of a registered server
+// are moved to the bot's email on that server.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/database"
+	"xray-telegram-bot/importer"
+	"xray-telegram-bot/models"
+	"xray-telegram-bot/services"
+	"xray-telegram-bot/xray"
+)
+
+func main() {
+	cfg := config.Load()
+
+	xrayConfig := flag.String("xray-config", "", "Xray config file to import clients from")
+	tag := flag.String("tag", cfg.XrayTag, "inbound tag of the clients in -xray-config")
+	xuiPath := flag.String("xui", "", "3x-ui or x-ui database to import clients from")
+	mappingPath := flag.String("mapping", "", "CSV file mapping client emails or remarks to Telegram IDs")
+	serverName := flag.String("server", "", "server to place imported users on (default: the server of -xray-config, else the placement policy)")
+	apply := flag.Bool("apply", false, "import the users instead of printing a dry run")
+	flag.Parse()
+
+	if (*xrayConfig == "") == (*xuiPath == "") {
+		fmt.Fprintln(os.Stderr, "exactly one of -xray-config and -xui is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var clients []*importer.Client
+	var err error
+	if *xrayConfig != "" {
+		clients, err = importer.FromXrayConfig(*xrayConfig, *tag)
+	} else {
+		clients, err = importer.FromXUI(*xuiPath)
+	}
+	if err != nil {
+		log.Fatal("Failed to read clients:", err)
+	}
+
+	mapping := importer.Mapping{}
+	if *mappingPath != "" {
+		if mapping, err = importer.LoadMapping(*mappingPath); err != nil {
+			log.Fatal("Failed to read mapping:", err)
+		}
+	}
+
+	db, err := database.New(cfg.DatabasePath)
+	if err != nil {
+		log.Fatal("Failed to open database:", err)
+	}
+	defer db.Close()
+
+	xrayClient := xray.NewClient(cfg)
+	serverService := services.NewServerService(db, xrayClient, cfg)
+	if err := serverService.Init(); err != nil {
+		log.Fatal("Failed to initialize server registry:", err)
+	}
+	userService := services.NewUserService(db, serverService, cfg)
+
+	server, replace, err := targetServer(serverService, *serverName, *xrayConfig, *tag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	entries, err := userService.PlanImport(clients, mapping)
+	if err != nil {
+		log.Fatal("Failed to plan import:", err)
+	}
+
+	target := "placement policy"
+	if server != nil {
+		target = server.Name
+	}
+	fmt.Printf("%d clients found, placing on %s\n", len(entries), target)
+	if replace {
+		fmt.Println("Clients are moved to the bot's emails in the server's config")
+	}
+
+	var imported, skipped, failed int
+	for _, entry := range entries {
+		if entry.Skip != "" {
+			skipped++
+			fmt.Printf("skip    %-32s %s\n", entry.Client.Key(), entry.Skip)
+			continue
+		}
+
+		line := fmt.Sprintf("%-32s -> %d %s", entry.Client.Key(), entry.UserID, describe(entry.Client))
+		if !*apply {
+			imported++
+			fmt.Println("import  " + line)
+			continue
+		}
+
+		status, err := userService.ImportUser(entry, server, replace)
+		if err != nil {
+			failed++
+			fmt.Printf("error   %s: %v\n", line, err)
+			continue
+		}
+		imported++
+		if status == "" {
+			status = "created"
+		}
+		fmt.Printf("%-7s %s\n", status, line)
+	}
+
+	if *apply {
+		if err := serverService.Flush(); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		fmt.Printf("Imported %d, skipped %d, failed %d\n", imported, skipped, failed)
+	} else {
+		fmt.Printf("Would import %d, skip %d. Run with -apply to import.\n", imported, skipped)
+	}
+}
+
+// targetServer returns the server to place users on and whether the
+// clients come from that server's own config.
+func targetServer(serverService *services.ServerService, name, xrayConfig, tag string) (*models.Server, bool, error) {
+	if name != "" {
+		server, err := serverService.ServerByName(name)
+		if err != nil {
+			return nil, false, err
+		}
+		if server == nil {
+			return nil, false, fmt.Errorf("server %s not found", name)
+		}
+		return server, xrayConfig != "" && sameConfig(server, xrayConfig, tag), nil
+	}
+
+	if xrayConfig == "" {
+		return nil, false, nil
+	}
+	servers, err := serverService.Servers()
+	if err != nil {
+		return nil, false, err
+	}
+	for _, server := range servers {
+		if sameConfig(server, xrayConfig, tag) {
+			return server, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func sameConfig(server *models.Server, path, tag string) bool {
+	if server.ConfigPath == "" || server.AgentURL != "" || server.InboundTag != tag {
+		return false
+	}
+	a, errA := filepath.Abs(server.ConfigPath)
+	b, errB := filepath.Abs(path)
+	return errA == nil && errB == nil && a == b
+}
+
+// describe summarises the access a client is imported with.
+func describe(client *importer.Client) string {
+	text := client.UUID
+	if !client.Enabled {
+		text += ", suspended"
+	}
+	if client.ExpiresAt != nil {
+		text += ", until " + client.ExpiresAt.Format("2006-01-02 15:04")
+	}
+	if client.TrafficLimit > 0 {
+		text += fmt.Sprintf(", %d MB", client.TrafficLimit>>20)
+	}
+	if client.IPLimit > 0 {
+		text += fmt.Sprintf(", %d IPs", client.IPLimit)
+	}
+	return text
+}
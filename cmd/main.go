@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"xray-telegram-bot/config"
 	"xray-telegram-bot/database"
@@ -30,6 +31,11 @@ func main() {
 		log.Println("Please ensure Xray API is properly configured")
 	}
 
+	// Start Xray when the bot supervises it
+	if err := xrayClient.Supervise(); err != nil {
+		log.Fatal("Failed to start Xray:", err)
+	}
+
 	// Test API connectivity
 	if err := xrayClient.TestAPI(); err != nil {
 		log.Printf("Warning: Xray API test failed: %v", err)
@@ -38,7 +44,21 @@ func main() {
 	}
 
 	// Initialize services
-	userService := services.NewUserService(db, xrayClient)
+	serverService := services.NewServerService(db, xrayClient, cfg)
+	if err := serverService.Init(); err != nil {
+		log.Fatal("Failed to initialize server registry:", err)
+	}
+	userService := services.NewUserService(db, serverService, cfg)
+
+	// Apply routing profiles chosen by users
+	if err := userService.SyncRoutingProfiles(); err != nil {
+		log.Printf("Warning: Failed to sync routing profiles: %v", err)
+	}
+
+	// Bring Xray in line with the database
+	if err := userService.Reconcile(); err != nil {
+		log.Printf("Warning: Failed to reconcile users with Xray: %v", err)
+	}
 
 	// Initialize Telegram bot
 	bot, err := tgbotapi.NewBotAPI(cfg.TelegramBotToken)
@@ -49,11 +69,41 @@ func main() {
 	bot.Debug = true
 	log.Printf("Authorized on account %s", bot.Self.UserName)
 
-	telegramService := services.NewTelegramService(bot, cfg, userService)
+	broadcastService := services.NewBroadcastService(bot, db, cfg)
+	activityService := services.NewActivityService(db, xrayClient, cfg)
+	sharingService := services.NewSharingService(bot, db, cfg, userService, activityService)
+	trialService := services.NewTrialService(bot, db, cfg, userService)
+	referralService := services.NewReferralService(bot, db, cfg, userService)
+	paymentService := services.NewPaymentService(bot, db, cfg, userService)
+	promoService := services.NewPromoService(bot, db, cfg, userService, paymentService)
+	healthService := services.NewHealthService(bot, db, cfg, serverService)
+	telegramService := services.NewTelegramService(bot, cfg, userService, broadcastService, activityService, sharingService, trialService,
+		referralService, paymentService, promoService, serverService, healthService)
+
+	// Start access log ingestion and shared-link detection
+	activityService.Start(context.Background())
+	sharingService.Start()
 
 	// Start subscription checker
 	telegramService.StartSubscriptionChecker()
 
+	// Start ban expiry, access expiry and trial checkers
+	telegramService.StartBanExpiryChecker()
+	telegramService.StartExpiryChecker()
+	trialService.StartChecker()
+
+	// Probe servers and their certificates, sample their traffic for placement and finish migrations
+	healthService.Start()
+	healthService.StartCertificateChecker()
+	serverService.StartTrafficSampler()
+	userService.StartDrainChecker()
+
+	// Receive payment provider callbacks and serve subscriptions
+	services.StartHTTPServer(cfg, paymentService, userService)
+
+	// Resume broadcasts interrupted by a restart
+	broadcastService.Resume()
+
 	// Start bot
 	updateConfig := tgbotapi.NewUpdate(0)
 	updateConfig.Timeout = 60
@@ -61,6 +111,14 @@ func main() {
 	updates := bot.GetUpdatesChan(updateConfig)
 
 	for update := range updates {
+		if update.CallbackQuery != nil {
+			go telegramService.HandleCallback(update)
+			continue
+		}
+		if update.PreCheckoutQuery != nil {
+			go telegramService.HandlePreCheckout(update)
+			continue
+		}
 		if update.Message == nil {
 			continue
 		}
@@ -3,6 +3,9 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
@@ -15,6 +18,68 @@ type Config struct {
 	ConfigPath       string
 	DatabasePath     string
 	DataDir          string
+	AdminIDs         []int64
+	BroadcastRate    int
+	AccessLogPath    string
+
+	// XrayRestart is how Xray is restarted after config file changes, see
+	// xray.NewRestarter. DockerSocket is used by the "docker" strategy.
+	XrayRestart  string
+	DockerSocket string
+
+	// FallbackDebounce is how long config file changes made while the API
+	// is unavailable are collected before one write and restart.
+	// RestartBudget caps those restarts per hour, zero meaning no cap.
+	FallbackDebounce time.Duration
+	RestartBudget    int
+
+	// SingBoxReload is the command that makes sing-box apply its config
+	// file, for servers with the sing-box backend.
+	SingBoxReload string
+
+	// ServerName and ServerFlag describe the server above when it seeds the
+	// server registry on first start. ServerBackend and ServerBackendURL
+	// pick the panel provisioning its users, if any, see models.Server.
+	ServerName       string
+	ServerFlag       string
+	ServerBackend    string
+	ServerBackendURL string
+
+	// PlacementPolicy picks the server of new users: "users" for the fewest
+	// active users, "traffic" for the least recent traffic, both relative
+	// to the server weight, or "weight" for a weighted random choice.
+	PlacementPolicy string
+
+	// HealthCheckInterval is how often every server is probed.
+	HealthCheckInterval time.Duration
+
+	// MaxConcurrentIPs is the default number of source IPs a user may use at
+	// the same time. SharingEscalation lists the actions taken on the first,
+	// second and further breaches: "warn", "rotate" or "suspend".
+	MaxConcurrentIPs   int
+	SharingEscalation  []string
+	SharingOnlineStats bool
+
+	// TrialDuration enables one-time trial access for non-subscribers when
+	// positive. TrialTrafficMB caps the traffic of a trial.
+	TrialDuration  time.Duration
+	TrialTrafficMB int
+
+	// ReferralRewards are granted to the inviter once per activated invitee,
+	// each as "<kind>:<amount>": "ips:1" for one more concurrent IP,
+	// "days:7" for a week more access, "gb:10" for 10 GB more plan traffic.
+	ReferralRewards []string
+
+	RoutingProfiles       []RoutingProfile
+	DefaultRoutingProfile string
+	Plans                 []Plan
+	PaymentProviders      []PaymentProvider
+
+	// WebhookListen is the address of the HTTP server receiving payment
+	// callbacks and serving subscriptions; WebhookPublicURL is how
+	// providers and clients reach it.
+	WebhookListen    string
+	WebhookPublicURL string
 }
 
 func Load() *Config {
@@ -26,7 +91,7 @@ func Load() *Config {
 
 	dbPath := filepath.Join(dataDir, "users.db") + "?_timeout=5000&_journal_mode=WAL&_busy_timeout=5000"
 
-	return &Config{
+	cfg := &Config{
 		TelegramBotToken: os.Getenv("TELEGRAM_BOT_TOKEN"),
 		ChannelUsername:  "@art_rom",
 		XrayAPIAddress:   "127.0.0.1:10085",
@@ -36,5 +101,109 @@ func Load() *Config {
 		ConfigPath:       "/usr/local/etc/xray/config.json",
 		DatabasePath:     dbPath,
 		DataDir:          dataDir,
+		AdminIDs:         parseIDs(os.Getenv("ADMIN_IDS")),
+		BroadcastRate:    envInt("BROADCAST_RATE", 25),
+		AccessLogPath:    os.Getenv("XRAY_ACCESS_LOG"),
+
+		XrayRestart:  envString("XRAY_RESTART", "systemd"),
+		DockerSocket: envString("DOCKER_SOCKET", "/var/run/docker.sock"),
+
+		FallbackDebounce: envDuration("XRAY_FALLBACK_DEBOUNCE", 10*time.Second),
+		RestartBudget:    envInt("XRAY_RESTART_BUDGET", 6),
+
+		SingBoxReload: envString("SINGBOX_RELOAD", "systemctl reload sing-box"),
+
+		ServerName:       envString("SERVER_NAME", "main"),
+		ServerFlag:       os.Getenv("SERVER_FLAG"),
+		ServerBackend:    envString("SERVER_BACKEND", "xray"),
+		ServerBackendURL: os.Getenv("SERVER_BACKEND_URL"),
+
+		PlacementPolicy:     envString("PLACEMENT_POLICY", "users"),
+		HealthCheckInterval: envDuration("HEALTH_CHECK_INTERVAL", time.Minute),
+
+		MaxConcurrentIPs:   envInt("MAX_CONCURRENT_IPS", 3),
+		SharingEscalation:  envList("SHARING_ESCALATION", "warn,rotate,suspend"),
+		SharingOnlineStats: os.Getenv("SHARING_ONLINE_STATS") == "1",
+
+		TrialDuration:  envDuration("TRIAL_DURATION", 0),
+		TrialTrafficMB: envInt("TRIAL_TRAFFIC_MB", 1024),
+
+		ReferralRewards: envList("REFERRAL_REWARDS", "ips:1"),
+
+		WebhookListen:    os.Getenv("WEBHOOK_LISTEN"),
+		WebhookPublicURL: os.Getenv("WEBHOOK_PUBLIC_URL"),
+
+		RoutingProfiles:       defaultRoutingProfiles,
+		DefaultRoutingProfile: "full",
+	}
+
+	configFile := os.Getenv("BOT_CONFIG")
+	if configFile == "" {
+		configFile = filepath.Join(dataDir, "bot.json")
+	}
+	if err := cfg.loadFile(configFile); err != nil {
+		panic("Failed to load bot config: " + err.Error())
+	}
+
+	return cfg
+}
+
+// IsAdmin reports whether the Telegram user may run admin commands.
+func (c *Config) IsAdmin(userID int64) bool {
+	for _, id := range c.AdminIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func parseIDs(value string) []int64 {
+	var ids []int64
+	for _, field := range strings.Split(value, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func envString(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+func envList(name, fallback string) []string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = fallback
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
 }
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// RoutingProfile is a named set of Xray routing rules. The bot fills in the
+// "user" field of every rule with the emails of the users on the profile, so
+// rules here must not set it themselves.
+type RoutingProfile struct {
+	Name  string                   `json:"name"`
+	Title string                   `json:"title"`
+	Rules []map[string]interface{} `json:"rules"`
+}
+
+// Plan is a paid tier. Servers lists the server names the plan gives access
+// to; an empty list means all servers.
+type Plan struct {
+	Name      string   `json:"name"`
+	Title     string   `json:"title"`
+	Days      int      `json:"days"`
+	TrafficGB int      `json:"traffic_gb"`
+	Devices   int      `json:"devices"`
+	Servers   []string `json:"servers"`
+	Stars     int      `json:"stars"`
+	// Price is in minor units of Currency and is charged through external
+	// payment providers.
+	Price    int64  `json:"price"`
+	Currency string `json:"currency"`
+}
+
+// PaymentProvider configures an external payment provider. Type selects the
+// implementation in the payments package.
+type PaymentProvider struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Title   string `json:"title"`
+	BaseURL string `json:"base_url"`
+	APIKey  string `json:"api_key"`
+	Secret  string `json:"secret"`
+}
+
+// fileConfig is the optional JSON file for settings that do not fit into
+// environment variables.
+type fileConfig struct {
+	DefaultRoutingProfile string            `json:"default_routing_profile"`
+	RoutingProfiles       []RoutingProfile  `json:"routing_profiles"`
+	Plans                 []Plan            `json:"plans"`
+	PaymentProviders      []PaymentProvider `json:"payment_providers"`
+}
+
+var defaultRoutingProfiles = []RoutingProfile{
+	{
+		Name:  "full",
+		Title: "Весь трафик через VPN",
+	},
+	{
+		Name:  "noads",
+		Title: "Блокировать рекламу и трекеры",
+		Rules: []map[string]interface{}{
+			{"domain": []string{"geosite:category-ads-all"}, "outboundTag": "block"},
+		},
+	},
+	{
+		Name:  "direct-ru",
+		Title: "Российские сайты напрямую",
+		Rules: []map[string]interface{}{
+			{"domain": []string{"geosite:category-ru"}, "outboundTag": "direct"},
+			{"ip": []string{"geoip:ru"}, "outboundTag": "direct"},
+		},
+	},
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var file fileConfig
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	if len(file.RoutingProfiles) > 0 {
+		c.RoutingProfiles = file.RoutingProfiles
+	}
+	if len(file.Plans) > 0 {
+		c.Plans = file.Plans
+	}
+	if len(file.PaymentProviders) > 0 {
+		c.PaymentProviders = file.PaymentProviders
+	}
+	if file.DefaultRoutingProfile != "" {
+		c.DefaultRoutingProfile = file.DefaultRoutingProfile
+	}
+
+	return nil
+}
+
+// RoutingProfile looks up a profile by name.
+func (c *Config) RoutingProfile(name string) (RoutingProfile, bool) {
+	for _, profile := range c.RoutingProfiles {
+		if profile.Name == name {
+			return profile, true
+		}
+	}
+	return RoutingProfile{}, false
+}
+
+// Plan looks up a paid plan by name.
+func (c *Config) Plan(name string) (Plan, bool) {
+	for _, plan := range c.Plans {
+		if plan.Name == name {
+			return plan, true
+		}
+	}
+	return Plan{}, false
+}
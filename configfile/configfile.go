@@ -0,0 +1,107 @@
+// Package configfile replaces the config files of proxies safely: a new
+// config is checked by the proxy itself before it takes the place of the
+// old one, and a snapshot of the old one is kept to roll back to.
+package configfile
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Validator checks a candidate config file, returning an error when the
+// proxy would not start with it.
+type Validator func(path string) error
+
+// Command returns a validator that runs the command with the path of the
+// candidate appended, e.g. Command("sing-box", "check", "-c").
+func Command(name string, args ...string) Validator {
+	return func(path string) error {
+		output, err := exec.Command(name, append(args, path)...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s %s rejected the config: %v, output: %s",
+				name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+		}
+		return nil
+	}
+}
+
+// Snapshot is a config file as it was before a change.
+type Snapshot struct {
+	path string
+	data []byte
+	mode os.FileMode
+}
+
+// Take reads the config file at path.
+func Take(path string) (*Snapshot, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{path: path, data: data, mode: info.Mode().Perm()}, nil
+}
+
+// Data returns the config as it was taken.
+func (s *Snapshot) Data() []byte {
+	return s.data
+}
+
+// Replace validates data and puts it in place of the config file, keeping
+// the file's permissions.
+func (s *Snapshot) Replace(data []byte, validate Validator) error {
+	return write(s.path, data, s.mode, validate)
+}
+
+// Restore puts the config back as it was taken.
+func (s *Snapshot) Restore() error {
+	return write(s.path, s.data, s.mode, nil)
+}
+
+// Write validates data and puts it in place of the config file at path,
+// creating it with mode if it does not exist.
+func Write(path string, data []byte, mode os.FileMode, validate Validator) error {
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	return write(path, data, mode, validate)
+}
+
+// write puts the candidate next to the config file, so that relative paths
+// in it resolve the same way, and renames it into place once the validator
+// accepts it. The proxy never sees a half-written file. The candidate keeps
+// the extension, from which proxies tell the format.
+func write(path string, data []byte, mode os.FileMode, validate Validator) error {
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	file, err := os.CreateTemp(filepath.Dir(path), "."+strings.TrimSuffix(name, ext)+"-*"+ext)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Chmod(mode); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if validate != nil {
+		if err := validate(file.Name()); err != nil {
+			return err
+		}
+	}
+	return os.Rename(file.Name(), path)
+}
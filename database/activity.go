@@ -0,0 +1,128 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+	"xray-telegram-bot/models"
+)
+
+// ActivityDelta is what the access log added for one user and day since the
+// last flush.
+type ActivityDelta struct {
+	UserID       int64
+	Day          string
+	LastSeen     time.Time
+	IPCount      int
+	Destinations map[string]int
+}
+
+// SaveActivity merges the deltas, keeps only the topDestinations busiest
+// destinations per user and day, and drops days older than retention.
+func (d *Database) SaveActivity(deltas []ActivityDelta, topDestinations int, retention time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, delta := range deltas {
+		if _, err := tx.Exec(`
+            INSERT INTO user_activity (user_id, last_seen) VALUES (?, ?)
+            ON CONFLICT (user_id) DO UPDATE SET last_seen = MAX(last_seen, excluded.last_seen)`,
+			delta.UserID, delta.LastSeen,
+		); err != nil {
+			return err
+		}
+
+		// Distinct IPs are tracked in memory only, so after a restart the
+		// in-memory count starts over and must not lower the stored one.
+		if _, err := tx.Exec(`
+            INSERT INTO user_daily_ips (user_id, day, ip_count) VALUES (?, ?, ?)
+            ON CONFLICT (user_id, day) DO UPDATE SET ip_count = MAX(ip_count, excluded.ip_count)`,
+			delta.UserID, delta.Day, delta.IPCount,
+		); err != nil {
+			return err
+		}
+
+		for destination, hits := range delta.Destinations {
+			if _, err := tx.Exec(`
+                INSERT INTO user_daily_destinations (user_id, day, destination, hits) VALUES (?, ?, ?, ?)
+                ON CONFLICT (user_id, day, destination) DO UPDATE SET hits = hits + excluded.hits`,
+				delta.UserID, delta.Day, destination, hits,
+			); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := tx.Exec(`
+        DELETE FROM user_daily_destinations WHERE rowid IN (
+            SELECT rowid FROM (
+                SELECT rowid, ROW_NUMBER() OVER (PARTITION BY user_id, day ORDER BY hits DESC) AS rank
+                FROM user_daily_destinations
+            ) WHERE rank > ?
+        )`, topDestinations,
+	); err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-retention).Format(models.DayLayout)
+	if _, err := tx.Exec("DELETE FROM user_daily_ips WHERE day < ?", cutoff); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_daily_destinations WHERE day < ?", cutoff); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetActivity returns the user's last-seen time, today's distinct IP count
+// and the busiest destinations of the last days.
+func (d *Database) GetActivity(userID int64, since string, limit int) (*models.Activity, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	activity := &models.Activity{UserID: userID}
+
+	var lastSeen sql.NullTime
+	err := d.db.QueryRow("SELECT last_seen FROM user_activity WHERE user_id = ?", userID).Scan(&lastSeen)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if lastSeen.Valid {
+		activity.LastSeen = &lastSeen.Time
+	}
+
+	err = d.db.QueryRow(
+		"SELECT ip_count FROM user_daily_ips WHERE user_id = ? AND day = ?",
+		userID, time.Now().Format(models.DayLayout),
+	).Scan(&activity.IPsToday)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	rows, err := d.db.Query(`
+        SELECT destination, SUM(hits) AS total FROM user_daily_destinations
+        WHERE user_id = ? AND day >= ?
+        GROUP BY destination ORDER BY total DESC LIMIT ?`,
+		userID, since, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var destination models.DestinationCount
+		if err := rows.Scan(&destination.Destination, &destination.Hits); err != nil {
+			return nil, err
+		}
+		activity.Destinations = append(activity.Destinations, destination)
+	}
+
+	return activity, rows.Err()
+}
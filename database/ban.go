@@ -0,0 +1,139 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+	"xray-telegram-bot/models"
+)
+
+const banColumns = "user_id, reason, admin_id, uuid, created_at, expires_at"
+
+func scanBan(row scanner) (*models.Ban, error) {
+	var ban models.Ban
+	var expiresAt sql.NullTime
+	if err := row.Scan(&ban.UserID, &ban.Reason, &ban.AdminID, &ban.UUID, &ban.CreatedAt, &expiresAt); err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		ban.ExpiresAt = &expiresAt.Time
+	}
+	return &ban, nil
+}
+
+func (d *Database) GetBan(userID int64) (*models.Ban, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ban, err := scanBan(d.db.QueryRow("SELECT "+banColumns+" FROM bans WHERE user_id = ?", userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return ban, err
+}
+
+func (d *Database) GetBans() ([]*models.Ban, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query("SELECT " + banColumns + " FROM bans ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []*models.Ban
+	for rows.Next() {
+		ban, err := scanBan(rows)
+		if err != nil {
+			log.Printf("Error scanning ban: %v", err)
+			continue
+		}
+		bans = append(bans, ban)
+	}
+
+	return bans, nil
+}
+
+// CreateBan stores or replaces the active ban and appends it to the history.
+func (d *Database) CreateBan(ban *models.Ban) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"INSERT OR REPLACE INTO bans ("+banColumns+") VALUES (?, ?, ?, ?, ?, ?)",
+		ban.UserID, ban.Reason, ban.AdminID, ban.UUID, ban.CreatedAt, ban.ExpiresAt,
+	); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO ban_history (user_id, action, reason, admin_id, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		ban.UserID, models.BanActionBan, ban.Reason, ban.AdminID, ban.ExpiresAt, ban.CreatedAt,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteBan lifts the active ban and records who lifted it.
+func (d *Database) DeleteBan(userID, adminID int64, reason string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM bans WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO ban_history (user_id, action, reason, admin_id, created_at) VALUES (?, ?, ?, ?, ?)",
+		userID, models.BanActionUnban, reason, adminID, time.Now(),
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (d *Database) GetBanHistory(userID int64) ([]*models.BanRecord, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query(
+		"SELECT id, user_id, action, reason, admin_id, expires_at, created_at FROM ban_history WHERE user_id = ? ORDER BY id",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*models.BanRecord
+	for rows.Next() {
+		var record models.BanRecord
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&record.ID, &record.UserID, &record.Action, &record.Reason, &record.AdminID, &expiresAt, &record.CreatedAt); err != nil {
+			log.Printf("Error scanning ban record: %v", err)
+			continue
+		}
+		if expiresAt.Valid {
+			record.ExpiresAt = &expiresAt.Time
+		}
+		records = append(records, &record)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,223 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+	"xray-telegram-bot/models"
+)
+
+const broadcastColumns = "id, admin_id, text, segment, status, progress_message_id, total, delivered, blocked, failed, created_at"
+
+func scanBroadcast(row scanner) (*models.Broadcast, error) {
+	var b models.Broadcast
+	err := row.Scan(&b.ID, &b.AdminID, &b.Text, &b.Segment, &b.Status, &b.ProgressMessageID,
+		&b.Total, &b.Delivered, &b.Blocked, &b.Failed, &b.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (d *Database) CreateBroadcast(b *models.Broadcast) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	b.CreatedAt = time.Now()
+	result, err := d.db.Exec(
+		"INSERT INTO broadcasts (admin_id, text, segment, status, created_at) VALUES (?, ?, ?, ?, ?)",
+		b.AdminID, b.Text, b.Segment, b.Status, b.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	b.ID, err = result.LastInsertId()
+	return err
+}
+
+func (d *Database) GetBroadcast(id int64) (*models.Broadcast, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	b, err := scanBroadcast(d.db.QueryRow("SELECT "+broadcastColumns+" FROM broadcasts WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return b, err
+}
+
+func (d *Database) GetBroadcastsByStatus(status string) ([]*models.Broadcast, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query("SELECT "+broadcastColumns+" FROM broadcasts WHERE status = ? ORDER BY id", status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var broadcasts []*models.Broadcast
+	for rows.Next() {
+		b, err := scanBroadcast(rows)
+		if err != nil {
+			log.Printf("Error scanning broadcast: %v", err)
+			continue
+		}
+		broadcasts = append(broadcasts, b)
+	}
+
+	return broadcasts, nil
+}
+
+func (d *Database) UpdateBroadcastSegment(id int64, segment string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("UPDATE broadcasts SET segment = ? WHERE id = ?", segment, id)
+	return err
+}
+
+func (d *Database) UpdateBroadcastStatus(id int64, status string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("UPDATE broadcasts SET status = ? WHERE id = ?", status, id)
+	return err
+}
+
+// FinishBroadcast marks a broadcast that is still being sent done. It
+// reports false when the broadcast was cancelled in the meantime.
+func (d *Database) FinishBroadcast(id int64) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	result, err := d.db.Exec("UPDATE broadcasts SET status = ? WHERE id = ? AND status = ?", models.BroadcastDone, id, models.BroadcastSending)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (d *Database) SetBroadcastProgressMessage(id int64, messageID int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("UPDATE broadcasts SET progress_message_id = ? WHERE id = ?", messageID, id)
+	return err
+}
+
+// StartBroadcast snapshots the recipients and moves the broadcast to sending
+// in one transaction, so a restart never sees a half-filled recipient list.
+func (d *Database) StartBroadcast(id int64, userIDs []int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, userID := range userIDs {
+		if _, err := tx.Exec(
+			"INSERT OR IGNORE INTO broadcast_recipients (broadcast_id, user_id, state) VALUES (?, ?, ?)",
+			id, userID, models.RecipientPending,
+		); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE broadcasts SET status = ?, total = ? WHERE id = ? AND status = ?",
+		models.BroadcastSending, len(userIDs), id, models.BroadcastDraft,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (d *Database) GetPendingRecipients(id int64) ([]int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query(
+		"SELECT user_id FROM broadcast_recipients WHERE broadcast_id = ? AND state = ? ORDER BY user_id",
+		id, models.RecipientPending,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, rows.Err()
+}
+
+// RecordDelivery stores the outcome for one recipient and bumps the matching
+// counter on the broadcast.
+func (d *Database) RecordDelivery(id, userID int64, state string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"UPDATE broadcast_recipients SET state = ? WHERE broadcast_id = ? AND user_id = ?",
+		state, id, userID,
+	); err != nil {
+		return err
+	}
+
+	var counter string
+	switch state {
+	case models.RecipientDelivered:
+		counter = "delivered"
+	case models.RecipientBlocked:
+		counter = "blocked"
+	default:
+		counter = "failed"
+	}
+
+	if _, err := tx.Exec("UPDATE broadcasts SET "+counter+" = "+counter+" + 1 WHERE id = ?", id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (d *Database) GetUserLanguages() ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query("SELECT DISTINCT language_code FROM users WHERE language_code != '' ORDER BY language_code")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var languages []string
+	for rows.Next() {
+		var language string
+		if err := rows.Scan(&language); err != nil {
+			return nil, err
+		}
+		languages = append(languages, language)
+	}
+
+	return languages, rows.Err()
+}
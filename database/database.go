@@ -3,10 +3,12 @@ package database
 import (
 	"database/sql"
 	"log"
+	"strings"
 	"sync"
+	"time"
 	"xray-telegram-bot/models"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 type Database struct {
@@ -14,6 +16,211 @@ type Database struct {
 	mu sync.Mutex
 }
 
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS users (
+        user_id INTEGER PRIMARY KEY,
+        username TEXT,
+        uuid TEXT,
+        created_at TIMESTAMP
+    );`,
+	`CREATE TABLE IF NOT EXISTS broadcasts (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        admin_id INTEGER NOT NULL,
+        text TEXT NOT NULL,
+        segment TEXT NOT NULL DEFAULT 'all',
+        status TEXT NOT NULL,
+        progress_message_id INTEGER NOT NULL DEFAULT 0,
+        total INTEGER NOT NULL DEFAULT 0,
+        delivered INTEGER NOT NULL DEFAULT 0,
+        blocked INTEGER NOT NULL DEFAULT 0,
+        failed INTEGER NOT NULL DEFAULT 0,
+        created_at TIMESTAMP
+    );`,
+	`CREATE TABLE IF NOT EXISTS broadcast_recipients (
+        broadcast_id INTEGER NOT NULL,
+        user_id INTEGER NOT NULL,
+        state TEXT NOT NULL,
+        PRIMARY KEY (broadcast_id, user_id)
+    );`,
+	`CREATE TABLE IF NOT EXISTS bans (
+        user_id INTEGER PRIMARY KEY,
+        reason TEXT NOT NULL,
+        admin_id INTEGER NOT NULL,
+        uuid TEXT NOT NULL DEFAULT '',
+        created_at TIMESTAMP,
+        expires_at TIMESTAMP
+    );`,
+	`CREATE TABLE IF NOT EXISTS ban_history (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        action TEXT NOT NULL,
+        reason TEXT NOT NULL DEFAULT '',
+        admin_id INTEGER NOT NULL,
+        expires_at TIMESTAMP,
+        created_at TIMESTAMP
+    );`,
+	`CREATE TABLE IF NOT EXISTS events (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        actor_type TEXT NOT NULL,
+        actor_id INTEGER NOT NULL DEFAULT 0,
+        action TEXT NOT NULL,
+        reason TEXT NOT NULL DEFAULT '',
+        xray_result TEXT NOT NULL DEFAULT '',
+        created_at TIMESTAMP
+    );`,
+	`CREATE INDEX IF NOT EXISTS events_user_id ON events (user_id, id);`,
+	`CREATE TABLE IF NOT EXISTS sharing_breaches (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        ip_count INTEGER NOT NULL,
+        action TEXT NOT NULL,
+        created_at TIMESTAMP
+    );`,
+	`CREATE TABLE IF NOT EXISTS trials (
+        user_id INTEGER PRIMARY KEY,
+        started_at TIMESTAMP,
+        expires_at TIMESTAMP,
+        traffic_limit INTEGER NOT NULL,
+        traffic_used INTEGER NOT NULL DEFAULT 0,
+        traffic_counter INTEGER NOT NULL DEFAULT 0,
+        ended_at TIMESTAMP,
+        end_reason TEXT NOT NULL DEFAULT '',
+        converted_at TIMESTAMP
+    );`,
+	`CREATE TABLE IF NOT EXISTS referrals (
+        invitee_id INTEGER PRIMARY KEY,
+        inviter_id INTEGER NOT NULL,
+        created_at TIMESTAMP,
+        rewarded_at TIMESTAMP,
+        reward TEXT NOT NULL DEFAULT ''
+    );`,
+	`CREATE INDEX IF NOT EXISTS referrals_inviter_id ON referrals (inviter_id);`,
+	`CREATE TABLE IF NOT EXISTS payments (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        provider TEXT NOT NULL,
+        plan TEXT NOT NULL,
+        days INTEGER NOT NULL,
+        amount INTEGER NOT NULL,
+        currency TEXT NOT NULL,
+        charge_id TEXT NOT NULL,
+        provider_charge_id TEXT NOT NULL DEFAULT '',
+        paid_until TIMESTAMP,
+        created_at TIMESTAMP,
+        refunded_at TIMESTAMP,
+        UNIQUE (provider, charge_id)
+    );`,
+	`CREATE TABLE IF NOT EXISTS promo_codes (
+        code TEXT PRIMARY KEY,
+        batch TEXT NOT NULL,
+        benefit TEXT NOT NULL,
+        max_uses INTEGER NOT NULL,
+        uses INTEGER NOT NULL DEFAULT 0,
+        expires_at TIMESTAMP,
+        created_by INTEGER NOT NULL,
+        created_at TIMESTAMP
+    );`,
+	`CREATE INDEX IF NOT EXISTS promo_codes_batch ON promo_codes (batch);`,
+	`CREATE TABLE IF NOT EXISTS promo_redemptions (
+        code TEXT NOT NULL,
+        user_id INTEGER NOT NULL,
+        redeemed_at TIMESTAMP,
+        PRIMARY KEY (code, user_id)
+    );`,
+	`CREATE TABLE IF NOT EXISTS servers (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        name TEXT NOT NULL UNIQUE,
+        flag TEXT NOT NULL DEFAULT '',
+        domain TEXT NOT NULL,
+        port INTEGER NOT NULL,
+        api_address TEXT NOT NULL,
+        inbound_tag TEXT NOT NULL,
+        config_path TEXT NOT NULL DEFAULT '',
+        capacity INTEGER NOT NULL DEFAULT 0,
+        enabled INTEGER NOT NULL DEFAULT 1,
+        created_at TIMESTAMP
+    );`,
+	`CREATE TABLE IF NOT EXISTS user_servers (
+        user_id INTEGER NOT NULL,
+        server_id INTEGER NOT NULL,
+        created_at TIMESTAMP,
+        PRIMARY KEY (user_id, server_id)
+    );`,
+	`CREATE INDEX IF NOT EXISTS user_servers_server_id ON user_servers (server_id);`,
+	`CREATE TABLE IF NOT EXISTS server_health (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        server_id INTEGER NOT NULL,
+        health TEXT NOT NULL,
+        detail TEXT NOT NULL DEFAULT '',
+        created_at TIMESTAMP
+    );`,
+	`CREATE INDEX IF NOT EXISTS server_health_server_id ON server_health (server_id);`,
+	`CREATE TABLE IF NOT EXISTS server_certificates (
+        server_id INTEGER NOT NULL,
+        fingerprint TEXT NOT NULL,
+        inbound_tag TEXT NOT NULL,
+        source TEXT NOT NULL,
+        subject TEXT NOT NULL,
+        not_after TIMESTAMP,
+        mismatch INTEGER NOT NULL DEFAULT 0,
+        warned INTEGER NOT NULL DEFAULT 0,
+        PRIMARY KEY (server_id, fingerprint)
+    );`,
+	`CREATE TABLE IF NOT EXISTS user_activity (
+        user_id INTEGER PRIMARY KEY,
+        last_seen TIMESTAMP
+    );`,
+	`CREATE TABLE IF NOT EXISTS user_daily_ips (
+        user_id INTEGER NOT NULL,
+        day TEXT NOT NULL,
+        ip_count INTEGER NOT NULL,
+        PRIMARY KEY (user_id, day)
+    );`,
+	`CREATE TABLE IF NOT EXISTS user_daily_destinations (
+        user_id INTEGER NOT NULL,
+        day TEXT NOT NULL,
+        destination TEXT NOT NULL,
+        hits INTEGER NOT NULL,
+        PRIMARY KEY (user_id, day, destination)
+    );`,
+	`CREATE TABLE IF NOT EXISTS wireguard_peers (
+        server_id INTEGER NOT NULL,
+        user_id INTEGER NOT NULL,
+        uuid TEXT NOT NULL,
+        private_key TEXT NOT NULL,
+        public_key TEXT NOT NULL,
+        address TEXT NOT NULL,
+        created_at TIMESTAMP,
+        PRIMARY KEY (server_id, user_id),
+        UNIQUE (server_id, address)
+    );`,
+}
+
+// columns lists columns added to tables after they were first released.
+var columns = []struct{ table, name, definition string }{
+	{"users", "language_code", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "status", "TEXT NOT NULL DEFAULT 'active'"},
+	{"users", "routing_profile", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "ip_limit", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "is_trial", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "plan", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "expires_at", "TIMESTAMP"},
+	{"users", "traffic_limit", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "expiry_reminder", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "server_id", "INTEGER NOT NULL DEFAULT 0"},
+	{"servers", "agent_url", "TEXT NOT NULL DEFAULT ''"},
+	{"servers", "agent_secret", "TEXT NOT NULL DEFAULT ''"},
+	{"servers", "weight", "INTEGER NOT NULL DEFAULT 1"},
+	{"user_servers", "drain_until", "TIMESTAMP"},
+	{"servers", "health", "TEXT NOT NULL DEFAULT 'up'"},
+	{"servers", "health_changed_at", "TIMESTAMP"},
+	{"servers", "backend", "TEXT NOT NULL DEFAULT 'xray'"},
+	{"servers", "backend_url", "TEXT NOT NULL DEFAULT ''"},
+	{"users", "traffic_used", "INTEGER NOT NULL DEFAULT 0"},
+	{"users", "traffic_counter", "INTEGER NOT NULL DEFAULT 0"},
+}
+
 func New(databasePath string) (*Database, error) {
 	db, err := sql.Open("sqlite3", databasePath)
 	if err != nil {
@@ -36,29 +243,77 @@ func (d *Database) Close() error {
 }
 
 func (d *Database) createTable() error {
-	query := `
-    CREATE TABLE IF NOT EXISTS users (
-        user_id INTEGER PRIMARY KEY,
-        username TEXT,
-        uuid TEXT,
-        created_at TIMESTAMP
-    );`
-
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	_, err := d.db.Exec(query)
-	return err
+	for _, query := range schema {
+		if _, err := d.db.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	for _, column := range columns {
+		_, err := d.db.Exec("ALTER TABLE " + column.table + " ADD COLUMN " + column.name + " " + column.definition)
+		if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseTimestamp parses a timestamp SQLite returns as text, which happens for
+// aggregates such as MAX(created_at) that lose the column type.
+func parseTimestamp(value string) time.Time {
+	for _, layout := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+const userColumns = "user_id, username, uuid, created_at, language_code, status, routing_profile, ip_limit, is_trial, plan, expires_at, traffic_limit, expiry_reminder, server_id, traffic_used, traffic_counter"
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row scanner) (*models.User, error) {
+	var user models.User
+	var expiresAt sql.NullTime
+	err := row.Scan(&user.ID, &user.Username, &user.UUID, &user.CreatedAt, &user.LanguageCode, &user.Status,
+		&user.RoutingProfile, &user.IPLimit, &user.IsTrial, &user.Plan, &expiresAt, &user.TrafficLimit, &user.ExpiryReminder, &user.ServerID,
+		&user.TrafficUsed, &user.TrafficCounter)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		user.ExpiresAt = &expiresAt.Time
+	}
+	return &user, nil
 }
 
 func (d *Database) GetUser(userID int64) (*models.User, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	var user models.User
-	err := d.db.QueryRow("SELECT user_id, username, uuid, created_at FROM users WHERE user_id = ?", userID).
-		Scan(&user.ID, &user.Username, &user.UUID, &user.CreatedAt)
+	user, err := scanUser(d.db.QueryRow("SELECT "+userColumns+" FROM users WHERE user_id = ?", userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (d *Database) GetUserByUUID(uuid string) (*models.User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
+	user, err := scanUser(d.db.QueryRow("SELECT "+userColumns+" FROM users WHERE uuid = ?", uuid))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -66,33 +321,139 @@ func (d *Database) GetUser(userID int64) (*models.User, error) {
 		return nil, err
 	}
 
-	return &user, nil
+	return user, nil
 }
 
 func (d *Database) CreateUser(user *models.User) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
+	if user.Status == "" {
+		user.Status = models.UserStatusActive
+	}
+
 	_, err := d.db.Exec(
-		"INSERT INTO users (user_id, username, uuid, created_at) VALUES (?, ?, ?, ?)",
-		user.ID, user.Username, user.UUID, user.CreatedAt,
+		"INSERT INTO users ("+userColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		user.ID, user.Username, user.UUID, user.CreatedAt, user.LanguageCode, user.Status, user.RoutingProfile, user.IPLimit, user.IsTrial,
+		user.Plan, user.ExpiresAt, user.TrafficLimit, user.ExpiryReminder, user.ServerID, user.TrafficUsed, user.TrafficCounter,
 	)
 	return err
 }
 
-func (d *Database) DeleteUser(userID int64) error {
+func (d *Database) UpdateUserLanguage(userID int64, languageCode string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("UPDATE users SET language_code = ? WHERE user_id = ?", languageCode, userID)
+	return err
+}
+
+func (d *Database) UpdateUserStatus(userID int64, status string) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	_, err := d.db.Exec("DELETE FROM users WHERE user_id = ?", userID)
+	_, err := d.db.Exec("UPDATE users SET status = ? WHERE user_id = ?", status, userID)
 	return err
 }
 
+func (d *Database) UpdateUserRoutingProfile(userID int64, profile string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("UPDATE users SET routing_profile = ? WHERE user_id = ?", profile, userID)
+	return err
+}
+
+func (d *Database) UpdateUserUUID(userID int64, uuid string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("UPDATE users SET uuid = ? WHERE user_id = ?", uuid, userID)
+	return err
+}
+
+func (d *Database) UpdateUserIPLimit(userID int64, limit int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("UPDATE users SET ip_limit = ? WHERE user_id = ?", limit, userID)
+	return err
+}
+
+// UpdateUserExpiry moves the user's access end and re-arms the reminders.
+func (d *Database) UpdateUserExpiry(userID int64, expiresAt time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("UPDATE users SET expires_at = ?, expiry_reminder = 0 WHERE user_id = ?", expiresAt, userID)
+	return err
+}
+
+func (d *Database) UpdateUserTrafficLimit(userID int64, limit int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("UPDATE users SET traffic_limit = ? WHERE user_id = ?", limit, userID)
+	return err
+}
+
+func (d *Database) UpdateUserTraffic(userID int64, used, counter int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("UPDATE users SET traffic_used = ?, traffic_counter = ? WHERE user_id = ?", used, counter, userID)
+	return err
+}
+
+// ClearUserPlan drops the user's time-limited access and its limits.
+func (d *Database) ClearUserPlan(userID int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec(
+		"UPDATE users SET plan = '', expires_at = NULL, traffic_limit = 0, expiry_reminder = 0 WHERE user_id = ?",
+		userID,
+	)
+	return err
+}
+
+func (d *Database) UpdateUserExpiryReminder(userID int64, days int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("UPDATE users SET expiry_reminder = ? WHERE user_id = ?", days, userID)
+	return err
+}
+
+// DeleteUser removes the user together with their server placements.
+func (d *Database) DeleteUser(userID int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM users WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM user_servers WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM wireguard_peers WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (d *Database) GetAllUsers() ([]*models.User, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	rows, err := d.db.Query("SELECT user_id, username, uuid, created_at FROM users")
+	rows, err := d.db.Query("SELECT " + userColumns + " FROM users")
 	if err != nil {
 		return nil, err
 	}
@@ -100,12 +461,12 @@ func (d *Database) GetAllUsers() ([]*models.User, error) {
 
 	var users []*models.User
 	for rows.Next() {
-		var user models.User
-		if err := rows.Scan(&user.ID, &user.Username, &user.UUID, &user.CreatedAt); err != nil {
+		user, err := scanUser(rows)
+		if err != nil {
 			log.Printf("Error scanning user: %v", err)
 			continue
 		}
-		users = append(users, &user)
+		users = append(users, user)
 	}
 
 	return users, nil
@@ -0,0 +1,54 @@
+package database
+
+import (
+	"log"
+	"xray-telegram-bot/models"
+)
+
+// CreateEvent appends to the events table. Events are never updated or
+// deleted.
+func (d *Database) CreateEvent(event *models.Event) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	result, err := d.db.Exec(
+		"INSERT INTO events (user_id, actor_type, actor_id, action, reason, xray_result, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		event.UserID, event.ActorType, event.ActorID, event.Action, event.Reason, event.XrayResult, event.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	event.ID, err = result.LastInsertId()
+	return err
+}
+
+// GetUserEvents returns the latest limit events of a user, oldest first.
+func (d *Database) GetUserEvents(userID int64, limit int) ([]*models.Event, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query(`
+        SELECT id, user_id, actor_type, actor_id, action, reason, xray_result, created_at FROM (
+            SELECT * FROM events WHERE user_id = ? ORDER BY id DESC LIMIT ?
+        ) ORDER BY id`,
+		userID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*models.Event
+	for rows.Next() {
+		var event models.Event
+		if err := rows.Scan(&event.ID, &event.UserID, &event.ActorType, &event.ActorID,
+			&event.Action, &event.Reason, &event.XrayResult, &event.CreatedAt); err != nil {
+			log.Printf("Error scanning event: %v", err)
+			continue
+		}
+		events = append(events, &event)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+	"xray-telegram-bot/models"
+)
+
+const paymentColumns = "id, user_id, provider, plan, days, amount, currency, charge_id, provider_charge_id, paid_until, created_at, refunded_at"
+
+func scanPayment(row scanner) (*models.Payment, error) {
+	var payment models.Payment
+	var refundedAt sql.NullTime
+	if err := row.Scan(&payment.ID, &payment.UserID, &payment.Provider, &payment.Plan, &payment.Days, &payment.Amount,
+		&payment.Currency, &payment.ChargeID, &payment.ProviderChargeID, &payment.PaidUntil, &payment.CreatedAt, &refundedAt); err != nil {
+		return nil, err
+	}
+	if refundedAt.Valid {
+		payment.RefundedAt = &refundedAt.Time
+	}
+	return &payment, nil
+}
+
+func (d *Database) GetPaymentByCharge(provider, chargeID string) (*models.Payment, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	payment, err := scanPayment(d.db.QueryRow(
+		"SELECT "+paymentColumns+" FROM payments WHERE provider = ? AND charge_id = ?",
+		provider, chargeID,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return payment, err
+}
+
+func (d *Database) GetUserPayments(userID int64) ([]*models.Payment, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query("SELECT "+paymentColumns+" FROM payments WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []*models.Payment
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			log.Printf("Error scanning payment: %v", err)
+			continue
+		}
+		payments = append(payments, payment)
+	}
+
+	return payments, nil
+}
+
+// ApplyPayment stores the payment, moves the user onto its plan with a fresh
+// traffic quota and converts their trial, if any, in one transaction. It returns false without
+// changing anything when the charge was already recorded.
+func (d *Database) ApplyPayment(payment *models.Payment, ipLimit int, trafficLimit int64) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+        INSERT OR IGNORE INTO payments (user_id, provider, plan, days, amount, currency, charge_id, provider_charge_id, paid_until, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		payment.UserID, payment.Provider, payment.Plan, payment.Days, payment.Amount, payment.Currency,
+		payment.ChargeID, payment.ProviderChargeID, payment.PaidUntil, payment.CreatedAt,
+	)
+	if err != nil {
+		return false, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return false, err
+	}
+	if payment.ID, err = result.LastInsertId(); err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec(
+		"UPDATE users SET plan = ?, expires_at = ?, ip_limit = ?, traffic_limit = ?, traffic_used = 0, expiry_reminder = 0 WHERE user_id = ?",
+		payment.Plan, payment.PaidUntil, ipLimit, trafficLimit, payment.UserID,
+	); err != nil {
+		return false, err
+	}
+	if err := convertTrial(tx, payment.UserID, payment.CreatedAt); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// RefundPayment marks the payment refunded and sets the user's access end to
+// the given time.
+func (d *Database) RefundPayment(paymentID, userID int64, expiresAt time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE payments SET refunded_at = ? WHERE id = ?", time.Now(), paymentID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE users SET expires_at = ?, expiry_reminder = 0 WHERE user_id = ?", expiresAt, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+	"xray-telegram-bot/models"
+)
+
+const promoColumns = "code, batch, benefit, max_uses, uses, expires_at, created_by, created_at"
+
+func scanPromoCode(row scanner) (*models.PromoCode, error) {
+	var promo models.PromoCode
+	var expiresAt sql.NullTime
+	if err := row.Scan(&promo.Code, &promo.Batch, &promo.Benefit, &promo.MaxUses, &promo.Uses,
+		&expiresAt, &promo.CreatedBy, &promo.CreatedAt); err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		promo.ExpiresAt = &expiresAt.Time
+	}
+	return &promo, nil
+}
+
+// CreatePromoCodes inserts a batch atomically; a duplicate code fails the
+// whole batch.
+func (d *Database) CreatePromoCodes(codes []*models.PromoCode) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, promo := range codes {
+		if _, err := tx.Exec(
+			"INSERT INTO promo_codes ("+promoColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			promo.Code, promo.Batch, promo.Benefit, promo.MaxUses, promo.Uses, promo.ExpiresAt, promo.CreatedBy, promo.CreatedAt,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (d *Database) GetPromoCode(code string) (*models.PromoCode, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	promo, err := scanPromoCode(d.db.QueryRow("SELECT "+promoColumns+" FROM promo_codes WHERE code = ?", code))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return promo, err
+}
+
+func (d *Database) GetPromoBatch(batch string) ([]*models.PromoCode, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query("SELECT "+promoColumns+" FROM promo_codes WHERE batch = ? ORDER BY code", batch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []*models.PromoCode
+	for rows.Next() {
+		promo, err := scanPromoCode(rows)
+		if err != nil {
+			log.Printf("Error scanning promo code: %v", err)
+			continue
+		}
+		codes = append(codes, promo)
+	}
+
+	return codes, nil
+}
+
+// GetPromoBatchRedemptions returns who redeemed the codes of a batch, in
+// redemption order.
+func (d *Database) GetPromoBatchRedemptions(batch string) ([]*models.PromoRedemption, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query(`
+        SELECT r.code, r.user_id, r.redeemed_at
+        FROM promo_redemptions r JOIN promo_codes c ON c.code = r.code
+        WHERE c.batch = ?
+        ORDER BY r.redeemed_at`,
+		batch,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var redemptions []*models.PromoRedemption
+	for rows.Next() {
+		var redemption models.PromoRedemption
+		if err := rows.Scan(&redemption.Code, &redemption.UserID, &redemption.RedeemedAt); err != nil {
+			log.Printf("Error scanning promo redemption: %v", err)
+			continue
+		}
+		redemptions = append(redemptions, &redemption)
+	}
+
+	return redemptions, nil
+}
+
+func (d *Database) HasRedeemedPromoCode(code string, userID int64) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var count int
+	err := d.db.QueryRow(
+		"SELECT COUNT(*) FROM promo_redemptions WHERE code = ? AND user_id = ?",
+		code, userID,
+	).Scan(&count)
+	return count > 0, err
+}
+
+// RedeemPromoCode records the redemption and counts the use in one
+// transaction. It returns false without changing anything when the code is
+// used up or the user already redeemed it.
+func (d *Database) RedeemPromoCode(code string, userID int64, redeemedAt time.Time) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"INSERT OR IGNORE INTO promo_redemptions (code, user_id, redeemed_at) VALUES (?, ?, ?)",
+		code, userID, redeemedAt,
+	)
+	if err != nil {
+		return false, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return false, err
+	}
+
+	result, err = tx.Exec("UPDATE promo_codes SET uses = uses + 1 WHERE code = ? AND uses < max_uses", code)
+	if err != nil {
+		return false, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// UnredeemPromoCode takes back a redemption whose benefit could not be
+// applied, so that the user can redeem the code again.
+func (d *Database) UnredeemPromoCode(code string, userID int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM promo_redemptions WHERE code = ? AND user_id = ?", code, userID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE promo_codes SET uses = uses - 1 WHERE code = ? AND uses > 0", code); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetPromoStats summarises every batch, newest first.
+func (d *Database) GetPromoStats() ([]*models.PromoBatchStats, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query(`
+        SELECT batch, MIN(benefit), COUNT(*), SUM(max_uses), SUM(uses), MIN(created_at) AS created
+        FROM promo_codes
+        GROUP BY batch
+        ORDER BY created DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []*models.PromoBatchStats
+	for rows.Next() {
+		var batch models.PromoBatchStats
+		var createdAt string
+		if err := rows.Scan(&batch.Batch, &batch.Benefit, &batch.Codes, &batch.Capacity, &batch.Redemptions, &createdAt); err != nil {
+			log.Printf("Error scanning promo stats: %v", err)
+			continue
+		}
+		batch.CreatedAt = parseTimestamp(createdAt)
+		stats = append(stats, &batch)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+	"xray-telegram-bot/models"
+)
+
+func (d *Database) GetReferral(inviteeID int64) (*models.Referral, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var referral models.Referral
+	var rewardedAt sql.NullTime
+	err := d.db.QueryRow(
+		"SELECT invitee_id, inviter_id, created_at, rewarded_at, reward FROM referrals WHERE invitee_id = ?",
+		inviteeID,
+	).Scan(&referral.InviteeID, &referral.InviterID, &referral.CreatedAt, &rewardedAt, &referral.Reward)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rewardedAt.Valid {
+		referral.RewardedAt = &rewardedAt.Time
+	}
+
+	return &referral, nil
+}
+
+// CreateReferral fails if the invitee was already referred by someone.
+func (d *Database) CreateReferral(referral *models.Referral) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec(
+		"INSERT INTO referrals (invitee_id, inviter_id, created_at) VALUES (?, ?, ?)",
+		referral.InviteeID, referral.InviterID, referral.CreatedAt,
+	)
+	return err
+}
+
+// MarkReferralRewarded returns false when the referral was already rewarded,
+// so that concurrent activations reward the inviter only once.
+func (d *Database) MarkReferralRewarded(inviteeID int64, reward string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	result, err := d.db.Exec(
+		"UPDATE referrals SET rewarded_at = ?, reward = ? WHERE invitee_id = ? AND rewarded_at IS NULL",
+		time.Now(), reward, inviteeID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func (d *Database) GetReferralStats(inviterID int64) (*models.ReferralStats, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var stats models.ReferralStats
+	err := d.db.QueryRow(
+		"SELECT COUNT(*), COUNT(rewarded_at) FROM referrals WHERE inviter_id = ?",
+		inviterID,
+	).Scan(&stats.Invited, &stats.Activated)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
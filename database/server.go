@@ -0,0 +1,478 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+	"xray-telegram-bot/models"
+)
+
+const serverColumns = "id, name, flag, domain, port, api_address, inbound_tag, config_path, capacity, enabled, created_at, agent_url, agent_secret, weight, health, health_changed_at, backend, backend_url"
+
+func scanServer(row scanner) (*models.Server, error) {
+	var server models.Server
+	if err := row.Scan(&server.ID, &server.Name, &server.Flag, &server.Domain, &server.Port, &server.APIAddress,
+		&server.InboundTag, &server.ConfigPath, &server.Capacity, &server.Enabled, &server.CreatedAt,
+		&server.AgentURL, &server.AgentSecret, &server.Weight,
+		&server.Health, &server.HealthChangedAt, &server.Backend, &server.BackendURL); err != nil {
+		return nil, err
+	}
+	return &server, nil
+}
+
+func (d *Database) GetServers() ([]*models.Server, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query("SELECT " + serverColumns + " FROM servers ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var servers []*models.Server
+	for rows.Next() {
+		server, err := scanServer(rows)
+		if err != nil {
+			log.Printf("Error scanning server: %v", err)
+			continue
+		}
+		servers = append(servers, server)
+	}
+
+	return servers, nil
+}
+
+func (d *Database) GetServer(id int64) (*models.Server, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	server, err := scanServer(d.db.QueryRow("SELECT "+serverColumns+" FROM servers WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return server, err
+}
+
+func (d *Database) GetServerByName(name string) (*models.Server, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	server, err := scanServer(d.db.QueryRow("SELECT "+serverColumns+" FROM servers WHERE name = ?", name))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return server, err
+}
+
+func (d *Database) CreateServer(server *models.Server) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	result, err := d.db.Exec(`
+        INSERT INTO servers (name, flag, domain, port, api_address, inbound_tag, config_path, capacity, enabled, created_at,
+            agent_url, agent_secret, weight, backend, backend_url)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		server.Name, server.Flag, server.Domain, server.Port, server.APIAddress, server.InboundTag,
+		server.ConfigPath, server.Capacity, server.Enabled, server.CreatedAt, server.AgentURL, server.AgentSecret,
+		server.Weight, server.Backend, server.BackendURL,
+	)
+	if err != nil {
+		return err
+	}
+
+	server.ID, err = result.LastInsertId()
+	return err
+}
+
+func (d *Database) UpdateServerEnabled(id int64, enabled bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("UPDATE servers SET enabled = ? WHERE id = ?", enabled, id)
+	return err
+}
+
+func (d *Database) UpdateServerCapacity(id int64, capacity int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("UPDATE servers SET capacity = ? WHERE id = ?", capacity, id)
+	return err
+}
+
+func (d *Database) UpdateServerAgent(id int64, agentURL, agentSecret string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("UPDATE servers SET agent_url = ?, agent_secret = ? WHERE id = ?", agentURL, agentSecret, id)
+	return err
+}
+
+func (d *Database) UpdateServerBackend(id int64, backend, backendURL string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("UPDATE servers SET backend = ?, backend_url = ? WHERE id = ?", backend, backendURL, id)
+	return err
+}
+
+func (d *Database) UpdateServerWeight(id int64, weight int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("UPDATE servers SET weight = ? WHERE id = ?", weight, id)
+	return err
+}
+
+// UpdateServerHealth records a change of the server's health in its
+// history.
+func (d *Database) UpdateServerHealth(change *models.HealthChange) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE servers SET health = ?, health_changed_at = ? WHERE id = ?",
+		change.Health, change.CreatedAt, change.ServerID); err != nil {
+		return err
+	}
+	result, err := tx.Exec("INSERT INTO server_health (server_id, health, detail, created_at) VALUES (?, ?, ?, ?)",
+		change.ServerID, change.Health, change.Detail, change.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if change.ID, err = result.LastInsertId(); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetServerHealth returns the latest entries of the server's health
+// history, newest first.
+func (d *Database) GetServerHealth(serverID int64, limit int) ([]*models.HealthChange, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query(`
+        SELECT id, server_id, health, detail, created_at FROM server_health
+        WHERE server_id = ? ORDER BY id DESC LIMIT ?`, serverID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []*models.HealthChange
+	for rows.Next() {
+		var change models.HealthChange
+		if err := rows.Scan(&change.ID, &change.ServerID, &change.Health, &change.Detail, &change.CreatedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, &change)
+	}
+
+	return changes, rows.Err()
+}
+
+// CountServerUsers returns how many users picked each server.
+func (d *Database) CountServerUsers() (map[int64]int, error) {
+	return d.countServerUsers("SELECT server_id, COUNT(*) FROM users GROUP BY server_id")
+}
+
+// CountActiveServerUsers returns how many active users picked each server.
+func (d *Database) CountActiveServerUsers() (map[int64]int, error) {
+	return d.countServerUsers("SELECT server_id, COUNT(*) FROM users WHERE status = ? GROUP BY server_id",
+		models.UserStatusActive)
+}
+
+func (d *Database) countServerUsers(query string, args ...interface{}) (map[int64]int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int64]int)
+	for rows.Next() {
+		var serverID int64
+		var count int
+		if err := rows.Scan(&serverID, &count); err != nil {
+			return nil, err
+		}
+		counts[serverID] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// AssignUnplacedUsers places users from before the server registry on the
+// given server.
+func (d *Database) AssignUnplacedUsers(serverID int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET server_id = ? WHERE server_id = 0", serverID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+        INSERT OR IGNORE INTO user_servers (user_id, server_id, created_at)
+        SELECT user_id, server_id, ? FROM users
+        WHERE user_id NOT IN (SELECT user_id FROM user_servers)`,
+		time.Now(),
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (d *Database) UpdateUserServer(userID, serverID int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("UPDATE users SET server_id = ? WHERE user_id = ?", serverID, userID)
+	return err
+}
+
+// GetUserServers returns the IDs of the servers the user is placed on.
+func (d *Database) GetUserServers(userID int64) ([]int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query("SELECT server_id FROM user_servers WHERE user_id = ? ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var serverIDs []int64
+	for rows.Next() {
+		var serverID int64
+		if err := rows.Scan(&serverID); err != nil {
+			return nil, err
+		}
+		serverIDs = append(serverIDs, serverID)
+	}
+
+	return serverIDs, rows.Err()
+}
+
+// GetAllUserServers returns the placements of every user.
+func (d *Database) GetAllUserServers() (map[int64][]int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query("SELECT user_id, server_id FROM user_servers ORDER BY created_at")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	placements := make(map[int64][]int64)
+	for rows.Next() {
+		var userID, serverID int64
+		if err := rows.Scan(&userID, &serverID); err != nil {
+			return nil, err
+		}
+		placements[userID] = append(placements[userID], serverID)
+	}
+
+	return placements, rows.Err()
+}
+
+// AddUserServer places the user on the server, ending a drain of an
+// earlier placement there.
+func (d *Database) AddUserServer(userID, serverID int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec(`
+        INSERT INTO user_servers (user_id, server_id, created_at) VALUES (?, ?, ?)
+        ON CONFLICT (user_id, server_id) DO UPDATE SET drain_until = NULL`,
+		userID, serverID, time.Now(),
+	)
+	return err
+}
+
+func (d *Database) RemoveUserServer(userID, serverID int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("DELETE FROM user_servers WHERE user_id = ? AND server_id = ?", userID, serverID)
+	return err
+}
+
+// GetServerUserIDs returns the users whose location is the server.
+func (d *Database) GetServerUserIDs(serverID int64) ([]int64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query("SELECT user_id FROM users WHERE server_id = ? ORDER BY user_id", serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, rows.Err()
+}
+
+// MoveUserServer makes the user's location the server to, placing them
+// there. The placement on from drains until drainUntil, or is removed right
+// away when drainUntil is nil.
+func (d *Database) MoveUserServer(userID, from, to int64, drainUntil *time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+        INSERT INTO user_servers (user_id, server_id, created_at) VALUES (?, ?, ?)
+        ON CONFLICT (user_id, server_id) DO UPDATE SET drain_until = NULL`,
+		userID, to, time.Now(),
+	); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE users SET server_id = ? WHERE user_id = ?", to, userID); err != nil {
+		return err
+	}
+
+	if drainUntil == nil {
+		_, err = tx.Exec("DELETE FROM user_servers WHERE user_id = ? AND server_id = ?", userID, from)
+	} else {
+		_, err = tx.Exec("UPDATE user_servers SET drain_until = ? WHERE user_id = ? AND server_id = ?", *drainUntil, userID, from)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetDrainingServers returns the IDs of the servers the user is being
+// migrated away from.
+func (d *Database) GetDrainingServers(userID int64) (map[int64]bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query("SELECT server_id FROM user_servers WHERE user_id = ? AND drain_until IS NOT NULL", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	draining := make(map[int64]bool)
+	for rows.Next() {
+		var serverID int64
+		if err := rows.Scan(&serverID); err != nil {
+			return nil, err
+		}
+		draining[serverID] = true
+	}
+
+	return draining, rows.Err()
+}
+
+// GetDrainingPlacements returns the placements left over from migrations.
+func (d *Database) GetDrainingPlacements() ([]models.Placement, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query("SELECT user_id, server_id, drain_until FROM user_servers WHERE drain_until IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var placements []models.Placement
+	for rows.Next() {
+		var placement models.Placement
+		if err := rows.Scan(&placement.UserID, &placement.ServerID, &placement.DrainUntil); err != nil {
+			return nil, err
+		}
+		placements = append(placements, placement)
+	}
+
+	return placements, rows.Err()
+}
+
+func (d *Database) GetServerCertificates(serverID int64) ([]*models.ServerCertificate, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query(`
+        SELECT server_id, fingerprint, inbound_tag, source, subject, not_after, mismatch, warned
+        FROM server_certificates WHERE server_id = ? ORDER BY inbound_tag, not_after`, serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var certificates []*models.ServerCertificate
+	for rows.Next() {
+		var certificate models.ServerCertificate
+		if err := rows.Scan(&certificate.ServerID, &certificate.Fingerprint, &certificate.InboundTag, &certificate.Source,
+			&certificate.Subject, &certificate.NotAfter, &certificate.Mismatch, &certificate.Warned); err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, &certificate)
+	}
+
+	return certificates, rows.Err()
+}
+
+// ReplaceServerCertificates stores the certificates currently found on the
+// server, forgetting the ones that were replaced.
+func (d *Database) ReplaceServerCertificates(serverID int64, certificates []*models.ServerCertificate) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM server_certificates WHERE server_id = ?", serverID); err != nil {
+		return err
+	}
+	for _, certificate := range certificates {
+		if _, err := tx.Exec(`
+            INSERT OR REPLACE INTO server_certificates
+                (server_id, fingerprint, inbound_tag, source, subject, not_after, mismatch, warned)
+            VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			serverID, certificate.Fingerprint, certificate.InboundTag, certificate.Source, certificate.Subject,
+			certificate.NotAfter, certificate.Mismatch, certificate.Warned,
+		); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
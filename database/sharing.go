@@ -0,0 +1,75 @@
+package database
+
+import (
+	"time"
+	"xray-telegram-bot/models"
+)
+
+func (d *Database) CreateSharingBreach(breach *models.SharingBreach) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec(
+		"INSERT INTO sharing_breaches (user_id, ip_count, action, created_at) VALUES (?, ?, ?, ?)",
+		breach.UserID, breach.IPCount, breach.Action, breach.CreatedAt,
+	)
+	return err
+}
+
+// GetSharingBreaches returns the user's breaches since the given time,
+// oldest first.
+func (d *Database) GetSharingBreaches(userID int64, since time.Time) ([]*models.SharingBreach, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query(
+		"SELECT id, user_id, ip_count, action, created_at FROM sharing_breaches WHERE user_id = ? AND created_at >= ? ORDER BY id",
+		userID, since,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var breaches []*models.SharingBreach
+	for rows.Next() {
+		var breach models.SharingBreach
+		if err := rows.Scan(&breach.ID, &breach.UserID, &breach.IPCount, &breach.Action, &breach.CreatedAt); err != nil {
+			return nil, err
+		}
+		breaches = append(breaches, &breach)
+	}
+
+	return breaches, rows.Err()
+}
+
+// GetSharingOffenders ranks users by the number of breaches since the given
+// time.
+func (d *Database) GetSharingOffenders(since time.Time, limit int) ([]*models.SharingOffender, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query(`
+        SELECT user_id, COUNT(*) AS breaches, MAX(ip_count), MAX(created_at) FROM sharing_breaches
+        WHERE created_at >= ?
+        GROUP BY user_id ORDER BY breaches DESC, MAX(ip_count) DESC LIMIT ?`,
+		since, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var offenders []*models.SharingOffender
+	for rows.Next() {
+		var offender models.SharingOffender
+		var lastAt string
+		if err := rows.Scan(&offender.UserID, &offender.Breaches, &offender.MaxIPs, &lastAt); err != nil {
+			return nil, err
+		}
+		offender.LastAt = parseTimestamp(lastAt)
+		offenders = append(offenders, &offender)
+	}
+
+	return offenders, rows.Err()
+}
@@ -0,0 +1,151 @@
+package database
+
+import (
+	"database/sql"
+	"log"
+	"time"
+	"xray-telegram-bot/models"
+)
+
+const trialColumns = "user_id, started_at, expires_at, traffic_limit, traffic_used, traffic_counter, ended_at, end_reason, converted_at"
+
+func scanTrial(row scanner) (*models.Trial, error) {
+	var trial models.Trial
+	var endedAt, convertedAt sql.NullTime
+	if err := row.Scan(&trial.UserID, &trial.StartedAt, &trial.ExpiresAt, &trial.TrafficLimit, &trial.TrafficUsed,
+		&trial.TrafficCounter, &endedAt, &trial.EndReason, &convertedAt); err != nil {
+		return nil, err
+	}
+	if endedAt.Valid {
+		trial.EndedAt = &endedAt.Time
+	}
+	if convertedAt.Valid {
+		trial.ConvertedAt = &convertedAt.Time
+	}
+	return &trial, nil
+}
+
+func (d *Database) GetTrial(userID int64) (*models.Trial, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	trial, err := scanTrial(d.db.QueryRow("SELECT "+trialColumns+" FROM trials WHERE user_id = ?", userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return trial, err
+}
+
+func (d *Database) GetActiveTrials() ([]*models.Trial, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rows, err := d.db.Query("SELECT " + trialColumns + " FROM trials WHERE ended_at IS NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var trials []*models.Trial
+	for rows.Next() {
+		trial, err := scanTrial(rows)
+		if err != nil {
+			log.Printf("Error scanning trial: %v", err)
+			continue
+		}
+		trials = append(trials, trial)
+	}
+
+	return trials, nil
+}
+
+// CreateTrial fails if the user ever had a trial.
+func (d *Database) CreateTrial(trial *models.Trial) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec(
+		"INSERT INTO trials (user_id, started_at, expires_at, traffic_limit) VALUES (?, ?, ?, ?)",
+		trial.UserID, trial.StartedAt, trial.ExpiresAt, trial.TrafficLimit,
+	)
+	return err
+}
+
+func (d *Database) DeleteTrial(userID int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("DELETE FROM trials WHERE user_id = ?", userID)
+	return err
+}
+
+func (d *Database) UpdateTrialTraffic(userID, used, counter int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec("UPDATE trials SET traffic_used = ?, traffic_counter = ? WHERE user_id = ?", used, counter, userID)
+	return err
+}
+
+func (d *Database) EndTrial(userID int64, reason string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec(
+		"UPDATE trials SET ended_at = ?, end_reason = ? WHERE user_id = ? AND ended_at IS NULL",
+		time.Now(), reason, userID,
+	)
+	return err
+}
+
+// ConvertTrial marks the user's trial as converted to a subscription and
+// clears the trial flag on the user. It is a no-op for users without a trial.
+func (d *Database) ConvertTrial(userID int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := convertTrial(tx, userID, time.Now()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// convertTrial ends the user's trial as converted, so that the trial checker
+// leaves the user alone, and clears the trial flag.
+func convertTrial(tx *sql.Tx, userID int64, now time.Time) error {
+	if _, err := tx.Exec(
+		"UPDATE trials SET converted_at = ?, ended_at = COALESCE(ended_at, ?), end_reason = CASE WHEN ended_at IS NULL THEN 'converted' ELSE end_reason END WHERE user_id = ? AND converted_at IS NULL",
+		now, now, userID,
+	); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec("UPDATE users SET is_trial = 0 WHERE user_id = ?", userID)
+	return err
+}
+
+func (d *Database) GetTrialStats() (*models.TrialStats, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var stats models.TrialStats
+	err := d.db.QueryRow(`
+        SELECT COUNT(*),
+            COUNT(*) FILTER (WHERE ended_at IS NULL),
+            COUNT(*) FILTER (WHERE ended_at IS NOT NULL),
+            COUNT(converted_at)
+        FROM trials`,
+	).Scan(&stats.Started, &stats.Active, &stats.Ended, &stats.Converted)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
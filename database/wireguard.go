@@ -0,0 +1,107 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"net/netip"
+	"xray-telegram-bot/models"
+)
+
+func (d *Database) GetWireGuardPeer(serverID, userID int64) (*models.WireGuardPeer, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var peer models.WireGuardPeer
+	err := d.db.QueryRow(`
+        SELECT server_id, user_id, uuid, private_key, public_key, address, created_at
+        FROM wireguard_peers WHERE server_id = ? AND user_id = ?`,
+		serverID, userID,
+	).Scan(&peer.ServerID, &peer.UserID, &peer.UUID, &peer.PrivateKey, &peer.PublicKey, &peer.Address, &peer.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &peer, nil
+}
+
+// CreateWireGuardPeer stores the peer with the first address of the subnet
+// that no other peer of the server has and that is not reserved. The
+// network and broadcast addresses are never handed out.
+func (d *Database) CreateWireGuardPeer(peer *models.WireGuardPeer, subnet netip.Prefix, reserved []netip.Addr) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT address FROM wireguard_peers WHERE server_id = ?", peer.ServerID)
+	if err != nil {
+		return err
+	}
+	taken := make(map[netip.Addr]bool)
+	for _, address := range reserved {
+		taken[address] = true
+	}
+	for rows.Next() {
+		var address string
+		if err := rows.Scan(&address); err != nil {
+			rows.Close()
+			return err
+		}
+		if parsed, err := netip.ParseAddr(address); err == nil {
+			taken[parsed] = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	subnet = subnet.Masked()
+	var address netip.Addr
+	for candidate := subnet.Addr().Next(); subnet.Contains(candidate); candidate = candidate.Next() {
+		if taken[candidate] {
+			continue
+		}
+		if next := candidate.Next(); candidate.Is4() && !subnet.Contains(next) {
+			break
+		}
+		address = candidate
+		break
+	}
+	if !address.IsValid() {
+		return fmt.Errorf("no free address left in %s", subnet)
+	}
+
+	if _, err := tx.Exec(`
+        INSERT INTO wireguard_peers (server_id, user_id, uuid, private_key, public_key, address, created_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		peer.ServerID, peer.UserID, peer.UUID, peer.PrivateKey, peer.PublicKey, address.String(), peer.CreatedAt,
+	); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	peer.Address = address.String()
+	return nil
+}
+
+// UpdateWireGuardPeerKeys replaces the keys of the peer, keeping its
+// address.
+func (d *Database) UpdateWireGuardPeerKeys(serverID, userID int64, uuid, privateKey, publicKey string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, err := d.db.Exec(
+		"UPDATE wireguard_peers SET uuid = ?, private_key = ?, public_key = ? WHERE server_id = ? AND user_id = ?",
+		uuid, privateKey, publicKey, serverID, userID,
+	)
+	return err
+}
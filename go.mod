@@ -6,4 +6,5 @@ require (
 	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
 	github.com/google/uuid v1.6.0
 	github.com/mattn/go-sqlite3 v1.14.24
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 )
@@ -0,0 +1,103 @@
+// Package importer reads VPN clients defined outside the bot, in an Xray
+// config or a 3x-ui/x-ui panel database, so that they can be taken over.
+package importer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Client is a VPN client found in a source.
+type Client struct {
+	// Source names where the client was found, e.g. "config.json, inbound
+	// vless_tls".
+	Source string
+	Email  string
+	// Remark is the panel's name of the client's inbound, if any.
+	Remark string
+	UUID   string
+	// TelegramID is the Telegram user ID the panel knows, zero if none.
+	TelegramID   int64
+	Enabled      bool
+	ExpiresAt    *time.Time
+	TrafficLimit int64
+	IPLimit      int
+}
+
+// Key is the name the client is matched by in a mapping: its email, or the
+// remark of its inbound for panels without client emails.
+func (c *Client) Key() string {
+	if c.Email != "" {
+		return c.Email
+	}
+	return c.Remark
+}
+
+// Mapping maps client emails or remarks to Telegram user IDs.
+type Mapping map[string]int64
+
+// LoadMapping reads a CSV file of "email or remark,telegram id" lines. A
+// header line and lines starting with # are skipped.
+func LoadMapping(path string) (Mapping, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	mapping := make(Mapping)
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return mapping, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		userID, err := strconv.ParseInt(strings.TrimSpace(record[1]), 10, 64)
+		if err != nil || userID <= 0 {
+			if line == 1 {
+				continue
+			}
+			return nil, fmt.Errorf("%s: line %d: invalid telegram id %q", path, line, record[1])
+		}
+		mapping[normalizeKey(record[0])] = userID
+	}
+}
+
+// Lookup returns the Telegram user ID mapped to the client's email or
+// remark.
+func (m Mapping) Lookup(client *Client) (int64, bool) {
+	for _, key := range []string{client.Email, client.Remark} {
+		if key == "" {
+			continue
+		}
+		if userID, ok := m[normalizeKey(key)]; ok {
+			return userID, true
+		}
+	}
+	return 0, false
+}
+
+func normalizeKey(key string) string {
+	return strings.ToLower(strings.TrimSpace(key))
+}
+
+// validUUID reports whether id can be used as a VLESS or VMess user ID.
+func validUUID(id string) bool {
+	_, err := uuid.Parse(id)
+	return err == nil
+}
@@ -0,0 +1,58 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// xrayInbound is the part of an Xray inbound the importer reads.
+type xrayInbound struct {
+	Tag      string `json:"tag"`
+	Settings struct {
+		Clients []struct {
+			ID    string `json:"id"`
+			Email string `json:"email"`
+		} `json:"clients"`
+	} `json:"settings"`
+}
+
+// FromXrayConfig reads the clients of the inbound with the given tag from an
+// Xray config file. Clients without a UUID, e.g. Trojan ones, are skipped.
+func FromXrayConfig(path, tag string) ([]*Client, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config struct {
+		Inbounds []xrayInbound `json:"inbounds"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+
+	for _, inbound := range config.Inbounds {
+		if inbound.Tag != tag {
+			continue
+		}
+
+		source := fmt.Sprintf("%s, inbound %s", filepath.Base(path), tag)
+		var clients []*Client
+		for _, client := range inbound.Settings.Clients {
+			if !validUUID(client.ID) {
+				continue
+			}
+			clients = append(clients, &Client{
+				Source:  source,
+				Email:   client.Email,
+				UUID:    client.ID,
+				Enabled: true,
+			})
+		}
+		return clients, nil
+	}
+
+	return nil, fmt.Errorf("inbound with tag %s not found in %s", tag, path)
+}
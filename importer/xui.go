@@ -0,0 +1,126 @@
+package importer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// xuiClient is a client in the settings of a 3x-ui inbound. x-ui inbounds
+// carry only the ID.
+type xuiClient struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	// Enable is missing in x-ui, where clients are always enabled.
+	Enable *bool `json:"enable"`
+	// ExpiryTime is in milliseconds since the epoch, zero meaning never;
+	// negative values are a period starting at the first connection.
+	ExpiryTime int64 `json:"expiryTime"`
+	// TotalGB is the traffic quota in bytes despite its name.
+	TotalGB int64           `json:"totalGB"`
+	LimitIP int             `json:"limitIp"`
+	TgID    json.RawMessage `json:"tgId"`
+}
+
+// FromXUI reads the VLESS and VMess clients of a 3x-ui or x-ui database.
+// Inbound expiry and quota apply to clients that have none of their own,
+// which is the only kind x-ui knows.
+func FromXUI(path string) ([]*Client, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT id, remark, enable, expiry_time, total, settings FROM inbounds
+		WHERE protocol IN ('vless', 'vmess') ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query inbounds of %s: %v", path, err)
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var clients []*Client
+	for rows.Next() {
+		var id, expiryTime, total int64
+		var remark, settings string
+		var enabled bool
+		if err := rows.Scan(&id, &remark, &enabled, &expiryTime, &total, &settings); err != nil {
+			return nil, err
+		}
+
+		var parsed struct {
+			Clients []xuiClient `json:"clients"`
+		}
+		if err := json.Unmarshal([]byte(settings), &parsed); err != nil {
+			return nil, fmt.Errorf("failed to parse settings of inbound %d: %v", id, err)
+		}
+
+		source := fmt.Sprintf("x-ui, inbound %d", id)
+		for _, entry := range parsed.Clients {
+			if !validUUID(entry.ID) {
+				continue
+			}
+
+			client := &Client{
+				Source:       source,
+				Email:        entry.Email,
+				Remark:       remark,
+				UUID:         entry.ID,
+				TelegramID:   parseTgID(entry.TgID),
+				Enabled:      enabled && (entry.Enable == nil || *entry.Enable),
+				TrafficLimit: entry.TotalGB,
+				IPLimit:      entry.LimitIP,
+			}
+			if client.TrafficLimit == 0 {
+				client.TrafficLimit = total
+			}
+			if entry.ExpiryTime == 0 {
+				entry.ExpiryTime = expiryTime
+			}
+			client.ExpiresAt = xuiExpiry(entry.ExpiryTime, now)
+			clients = append(clients, client)
+		}
+	}
+	return clients, rows.Err()
+}
+
+// xuiExpiry converts a panel expiry time. A period starting at the first
+// connection is taken to start now.
+func xuiExpiry(expiryTime int64, now time.Time) *time.Time {
+	var expiresAt time.Time
+	switch {
+	case expiryTime > 0:
+		expiresAt = time.UnixMilli(expiryTime)
+	case expiryTime < 0:
+		expiresAt = now.Add(time.Duration(-expiryTime) * time.Millisecond)
+	default:
+		return nil
+	}
+	return &expiresAt
+}
+
+// parseTgID reads the Telegram ID of a 3x-ui client, stored as a number or
+// a string depending on the panel version.
+func parseTgID(raw json.RawMessage) int64 {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return 0
+	}
+
+	var userID int64
+	switch value := value.(type) {
+	case float64:
+		userID = int64(value)
+	case string:
+		userID, _ = strconv.ParseInt(value, 10, 64)
+	}
+	if userID < 0 {
+		return 0
+	}
+	return userID
+}
@@ -0,0 +1,214 @@
+package marzban
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"xray-telegram-bot/models"
+)
+
+// stubMarzban serves the part of the Marzban API the client uses. Tokens
+// are valid until revoked.
+type stubMarzban struct {
+	mu     sync.Mutex
+	tokens map[string]bool
+	issued int
+	users  map[string]userRequest
+	puts   int
+	// subscriptionPrefix is Marzban's XRAY_SUBSCRIPTION_URL_PREFIX.
+	subscriptionPrefix string
+}
+
+func newStubMarzban(t *testing.T) (*stubMarzban, *httptest.Server) {
+	stub := &stubMarzban{tokens: make(map[string]bool), users: make(map[string]userRequest)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/admin/token", stub.token)
+	mux.HandleFunc("GET /api/inbounds", stub.auth(stub.inbounds))
+	mux.HandleFunc("POST /api/user", stub.auth(stub.createUser))
+	mux.HandleFunc("PUT /api/user/{username}", stub.auth(stub.modifyUser))
+	mux.HandleFunc("GET /api/user/{username}", stub.auth(stub.getUser))
+	mux.HandleFunc("DELETE /api/user/{username}", stub.auth(stub.deleteUser))
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return stub, server
+}
+
+func (s *stubMarzban) token(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("username") != "admin" || r.FormValue("password") != "secret" {
+		http.Error(w, `{"detail":"Incorrect username or password"}`, http.StatusUnauthorized)
+		return
+	}
+
+	s.mu.Lock()
+	s.issued++
+	token := "token-" + strconv.Itoa(s.issued)
+	s.tokens[token] = true
+	s.mu.Unlock()
+
+	json.NewEncoder(w).Encode(map[string]string{"access_token": token, "token_type": "bearer"})
+}
+
+func (s *stubMarzban) auth(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if !s.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] {
+			http.Error(w, `{"detail":"Could not validate credentials"}`, http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// revokeTokens makes every token expire.
+func (s *stubMarzban) revokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = make(map[string]bool)
+}
+
+func (s *stubMarzban) inbounds(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte(`{"vless":[{"tag":"VLESS TCP REALITY","protocol":"vless"}],"vmess":[{"tag":"VMess WS"}]}`))
+}
+
+func (s *stubMarzban) createUser(w http.ResponseWriter, r *http.Request) {
+	var request userRequest
+	json.NewDecoder(r.Body).Decode(&request)
+	if _, ok := s.users[request.Username]; ok {
+		http.Error(w, `{"detail":"User already exists"}`, http.StatusConflict)
+		return
+	}
+	s.users[request.Username] = request
+	s.writeUser(w, request.Username)
+}
+
+func (s *stubMarzban) modifyUser(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+	if _, ok := s.users[username]; !ok {
+		http.Error(w, `{"detail":"User not found"}`, http.StatusNotFound)
+		return
+	}
+	var request userRequest
+	json.NewDecoder(r.Body).Decode(&request)
+	request.Username = username
+	s.users[username] = request
+	s.puts++
+	s.writeUser(w, username)
+}
+
+func (s *stubMarzban) getUser(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.users[r.PathValue("username")]; !ok {
+		http.Error(w, `{"detail":"User not found"}`, http.StatusNotFound)
+		return
+	}
+	s.writeUser(w, r.PathValue("username"))
+}
+
+func (s *stubMarzban) deleteUser(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.users[r.PathValue("username")]; !ok {
+		http.Error(w, `{"detail":"User not found"}`, http.StatusNotFound)
+		return
+	}
+	delete(s.users, r.PathValue("username"))
+	w.Write([]byte(`{}`))
+}
+
+func (s *stubMarzban) writeUser(w http.ResponseWriter, username string) {
+	json.NewEncoder(w).Encode(userResponse{
+		Username:        username,
+		Status:          "active",
+		UsedTraffic:     1024,
+		SubscriptionURL: s.subscriptionPrefix + "/sub/" + username + "-token",
+		Links:           []string{"vless://" + s.users[username].Proxies["vless"]["id"] + "@marzban.example.com:443"},
+	})
+}
+
+func TestClient(t *testing.T) {
+	stub, server := newStubMarzban(t)
+	client, err := NewClient(strings.Replace(server.URL, "http://", "http://admin:secret@", 1)+"/", "VLESS TCP REALITY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.TestAPI(); err != nil {
+		t.Fatal(err)
+	}
+
+	const email = "user_42@myserver"
+	expiresAt := time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC)
+	user := &models.User{
+		ID:           42,
+		UUID:         "11111111-1111-1111-1111-111111111111",
+		TrafficLimit: 50 << 30,
+		ExpiresAt:    &expiresAt,
+	}
+	if status, err := client.AddUser(user, email); err != nil || status != "applied" {
+		t.Fatalf("AddUser returned %q, %v", status, err)
+	}
+	created, ok := stub.users["user_42"]
+	if !ok {
+		t.Fatalf("user not created: %+v", stub.users)
+	}
+	if created.Expire != 1793534400 || created.DataLimit != 53687091200 || created.DataLimitResetStrategy != "no_reset" {
+		t.Fatalf("user expires at %d with %d bytes, want seconds and bytes", created.Expire, created.DataLimit)
+	}
+	if created.Proxies["vless"]["id"] != user.UUID || created.Inbounds["vless"][0] != "VLESS TCP REALITY" || created.Note != "telegram 42" {
+		t.Fatalf("unexpected user %+v", created)
+	}
+
+	// The token expired; adding the user again finds it there and modifies it.
+	stub.revokeTokens()
+	user.UUID, user.ExpiresAt, user.TrafficLimit = "22222222-2222-2222-2222-222222222222", nil, 0
+	if _, err := client.AddUser(user, email); err != nil {
+		t.Fatal(err)
+	}
+	if stub.issued != 2 || stub.puts != 1 {
+		t.Fatalf("issued %d tokens and modified %d times, want 2 and 1", stub.issued, stub.puts)
+	}
+	if modified := stub.users["user_42"]; modified.Proxies["vless"]["id"] != user.UUID || modified.Expire != 0 || modified.DataLimit != 0 {
+		t.Fatalf("user not modified: %+v", modified)
+	}
+
+	if traffic, err := client.UserTraffic(email); err != nil || traffic != 1024 {
+		t.Fatalf("UserTraffic returned %d, %v", traffic, err)
+	}
+	if links, err := client.Links(email); err != nil || len(links) != 1 || !strings.Contains(links[0], user.UUID) {
+		t.Fatalf("Links returned %v, %v", links, err)
+	}
+
+	// Without a subscription URL prefix Marzban returns a path on the panel.
+	if url, err := client.SubscriptionURL(email); err != nil || url != server.URL+"/sub/user_42-token" {
+		t.Fatalf("SubscriptionURL returned %q, %v", url, err)
+	}
+	stub.subscriptionPrefix = "https://sub.example.com"
+	if url, err := client.SubscriptionURL(email); err != nil || url != "https://sub.example.com/sub/user_42-token" {
+		t.Fatalf("SubscriptionURL returned %q, %v", url, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if status, err := client.RemoveUser(email); err != nil || status != "applied" {
+			t.Fatalf("RemoveUser %d returned %q, %v", i+1, status, err)
+		}
+	}
+	if len(stub.users) != 0 {
+		t.Fatalf("user not removed: %+v", stub.users)
+	}
+}
+
+func TestClientWrongCredentials(t *testing.T) {
+	_, server := newStubMarzban(t)
+	client, err := NewClient(strings.Replace(server.URL, "http://", "http://admin:wrong@", 1), "VLESS TCP REALITY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.TestAPI(); err == nil || !strings.Contains(err.Error(), "status 401") {
+		t.Fatalf("TestAPI with wrong credentials returned %v", err)
+	}
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// DayLayout is the format of per-day aggregation keys.
+const DayLayout = "2006-01-02"
+
+// Activity summarises what the Xray access log tells about a user. Source
+// IPs are only ever counted, never stored.
+type Activity struct {
+	UserID       int64
+	LastSeen     *time.Time
+	IPsToday     int
+	Destinations []DestinationCount
+}
+
+type DestinationCount struct {
+	Destination string
+	Hits        int
+}
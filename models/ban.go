@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+const (
+	BanActionBan   = "ban"
+	BanActionUnban = "unban"
+)
+
+// Ban is an active ban. UUID keeps the user's VLESS ID so that an unban can
+// restore the same configuration.
+type Ban struct {
+	UserID    int64      `db:"user_id"`
+	Reason    string     `db:"reason"`
+	AdminID   int64      `db:"admin_id"`
+	UUID      string     `db:"uuid"`
+	CreatedAt time.Time  `db:"created_at"`
+	ExpiresAt *time.Time `db:"expires_at"`
+}
+
+// BanRecord is an entry of the append-only ban history. AdminID is zero for
+// actions taken by the bot itself, such as lifting an expired ban.
+type BanRecord struct {
+	ID        int64      `db:"id"`
+	UserID    int64      `db:"user_id"`
+	Action    string     `db:"action"`
+	Reason    string     `db:"reason"`
+	AdminID   int64      `db:"admin_id"`
+	ExpiresAt *time.Time `db:"expires_at"`
+	CreatedAt time.Time  `db:"created_at"`
+}
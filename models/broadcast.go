@@ -0,0 +1,55 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BroadcastDraft     = "draft"
+	BroadcastSending   = "sending"
+	BroadcastDone      = "done"
+	BroadcastCancelled = "cancelled"
+)
+
+const (
+	RecipientPending   = "pending"
+	RecipientDelivered = "delivered"
+	RecipientBlocked   = "blocked"
+	RecipientFailed    = "failed"
+)
+
+type Broadcast struct {
+	ID                int64     `db:"id"`
+	AdminID           int64     `db:"admin_id"`
+	Text              string    `db:"text"`
+	Segment           string    `db:"segment"`
+	Status            string    `db:"status"`
+	ProgressMessageID int       `db:"progress_message_id"`
+	Total             int       `db:"total"`
+	Delivered         int       `db:"delivered"`
+	Blocked           int       `db:"blocked"`
+	Failed            int       `db:"failed"`
+	CreatedAt         time.Time `db:"created_at"`
+}
+
+// Segment selects broadcast recipients: "all", "active", "suspended",
+// "lang:<code>" or "server:<id>".
+type Segment string
+
+const SegmentAll Segment = "all"
+
+func (s Segment) Matches(user *User) bool {
+	switch {
+	case s == SegmentAll || s == "":
+		return true
+	case s == UserStatusActive || s == UserStatusSuspended:
+		return user.Status == string(s)
+	case strings.HasPrefix(string(s), "lang:"):
+		return user.LanguageCode == strings.TrimPrefix(string(s), "lang:")
+	case strings.HasPrefix(string(s), "server:"):
+		return strconv.FormatInt(user.ServerID, 10) == strings.TrimPrefix(string(s), "server:")
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	ActorUser   = "user"
+	ActorAdmin  = "admin"
+	ActorSystem = "system"
+)
+
+const (
+	EventCreated         = "created"
+	EventRevoked         = "revoked"
+	EventRotated         = "rotated"
+	EventBanned          = "banned"
+	EventUnbanned        = "unbanned"
+	EventQuotaSuspended  = "quota-suspended"
+	EventReconciled      = "reconciled"
+	EventProfileChanged  = "profile-changed"
+	EventSuspended       = "suspended"
+	EventUnsuspended     = "unsuspended"
+	EventPaid            = "paid"
+	EventRefunded        = "refunded"
+	EventExpired         = "expired"
+	EventRenewed         = "renewed"
+	EventExpiryChanged   = "expiry-changed"
+	EventPlanEnded       = "plan-ended"
+	EventPromoRedeemed   = "promo-redeemed"
+	EventLocationChanged = "location-changed"
+	EventImported        = "imported"
+)
+
+// Actor identifies who triggered a provisioning action.
+type Actor struct {
+	Type string
+	ID   int64
+}
+
+var SystemActor = Actor{Type: ActorSystem}
+
+func UserActor(userID int64) Actor {
+	return Actor{Type: ActorUser, ID: userID}
+}
+
+func AdminActor(adminID int64) Actor {
+	if adminID == 0 {
+		return SystemActor
+	}
+	return Actor{Type: ActorAdmin, ID: adminID}
+}
+
+func (a Actor) String() string {
+	if a.Type == ActorSystem {
+		return a.Type
+	}
+	return fmt.Sprintf("%s:%d", a.Type, a.ID)
+}
+
+// Event is an entry of the append-only provisioning log. XrayResult is "ok",
+// the Xray error text, or empty when Xray was not involved.
+type Event struct {
+	ID         int64     `db:"id"`
+	UserID     int64     `db:"user_id"`
+	ActorType  string    `db:"actor_type"`
+	ActorID    int64     `db:"actor_id"`
+	Action     string    `db:"action"`
+	Reason     string    `db:"reason"`
+	XrayResult string    `db:"xray_result"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+func (e *Event) Actor() Actor {
+	return Actor{Type: e.ActorType, ID: e.ActorID}
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+const (
+	PaymentProviderStars = "stars"
+	// PaymentProviderPromo records plans granted by promo codes, with a
+	// zero amount.
+	PaymentProviderPromo = "promo"
+)
+
+// Payment is a completed purchase of a plan. ChargeID is the identifier the
+// provider needs for refunds; for Telegram Stars it is the
+// telegram_payment_charge_id.
+type Payment struct {
+	ID               int64      `db:"id"`
+	UserID           int64      `db:"user_id"`
+	Provider         string     `db:"provider"`
+	Plan             string     `db:"plan"`
+	Days             int        `db:"days"`
+	Amount           int64      `db:"amount"`
+	Currency         string     `db:"currency"`
+	ChargeID         string     `db:"charge_id"`
+	ProviderChargeID string     `db:"provider_charge_id"`
+	PaidUntil        time.Time  `db:"paid_until"`
+	CreatedAt        time.Time  `db:"created_at"`
+	RefundedAt       *time.Time `db:"refunded_at"`
+}
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Promo code benefits are "<kind>:<value>", e.g. "days:30", "gb:10" or
+// "plan:month".
+const (
+	PromoBenefitDays = "days"
+	PromoBenefitGB   = "gb"
+	PromoBenefitPlan = "plan"
+)
+
+// PromoCode is a gift code from a batch created by an admin. MaxUses counts
+// distinct users.
+type PromoCode struct {
+	Code      string     `db:"code"`
+	Batch     string     `db:"batch"`
+	Benefit   string     `db:"benefit"`
+	MaxUses   int        `db:"max_uses"`
+	Uses      int        `db:"uses"`
+	ExpiresAt *time.Time `db:"expires_at"`
+	CreatedBy int64      `db:"created_by"`
+	CreatedAt time.Time  `db:"created_at"`
+}
+
+type PromoRedemption struct {
+	Code       string    `db:"code"`
+	UserID     int64     `db:"user_id"`
+	RedeemedAt time.Time `db:"redeemed_at"`
+}
+
+type PromoBatchStats struct {
+	Batch       string
+	Benefit     string
+	Codes       int
+	Capacity    int
+	Redemptions int
+	CreatedAt   time.Time
+}
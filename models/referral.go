@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Referral links an invited user to the user whose link they followed.
+// RewardedAt is set once the invitee activated and the inviter got the reward.
+type Referral struct {
+	InviteeID  int64      `db:"invitee_id"`
+	InviterID  int64      `db:"inviter_id"`
+	CreatedAt  time.Time  `db:"created_at"`
+	RewardedAt *time.Time `db:"rewarded_at"`
+	Reward     string     `db:"reward"`
+}
+
+type ReferralStats struct {
+	Invited   int
+	Activated int
+}
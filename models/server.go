@@ -0,0 +1,113 @@
+package models
+
+import "time"
+
+// Server health as seen by the health prober. A degraded server serves
+// users but its management API does not answer.
+const (
+	ServerUp       = "up"
+	ServerDegraded = "degraded"
+	ServerDown     = "down"
+)
+
+// Provisioning backends of a server: Xray itself, through its API and
+// config file, a 3x-ui or Marzban panel managing Xray, through the panel's
+// API, or a local WireGuard interface or sing-box server, through its
+// config file.
+const (
+	BackendXray      = "xray"
+	Backend3XUI      = "3x-ui"
+	BackendMarzban   = "marzban"
+	BackendWireGuard = "wireguard"
+	BackendSingBox   = "sing-box"
+)
+
+// Server is a VPN server of the registry. Capacity limits the users who
+// may pick it as their location, zero meaning unlimited. Weight scales the
+// share of new users placed on it. ConfigPath is only
+// set for servers whose Xray config the bot can edit locally, which enables
+// the config-file fallback and routing profiles there. Servers with an
+// AgentURL are managed through the node agent instead. Servers with another
+// Backend are provisioned through it, BackendURL carrying its address and
+// credentials.
+type Server struct {
+	ID         int64     `db:"id"`
+	Name       string    `db:"name"`
+	Flag       string    `db:"flag"`
+	Domain     string    `db:"domain"`
+	Port       int       `db:"port"`
+	APIAddress string    `db:"api_address"`
+	InboundTag string    `db:"inbound_tag"`
+	ConfigPath string    `db:"config_path"`
+	Capacity   int       `db:"capacity"`
+	Enabled    bool      `db:"enabled"`
+	CreatedAt  time.Time `db:"created_at"`
+	Weight     int       `db:"weight"`
+
+	AgentURL    string `db:"agent_url"`
+	AgentSecret string `db:"agent_secret"`
+
+	Health          string     `db:"health"`
+	HealthChangedAt *time.Time `db:"health_changed_at"`
+
+	Backend    string `db:"backend"`
+	BackendURL string `db:"backend_url"`
+}
+
+// Reachable reports whether users can connect to the server.
+func (s *Server) Reachable() bool {
+	return s.Health != ServerDown
+}
+
+// HasConfig reports whether the bot can edit the server's Xray config,
+// locally or through its agent.
+func (s *Server) HasConfig() bool {
+	return s.Backend == BackendXray && (s.ConfigPath != "" || s.AgentURL != "")
+}
+
+// Label is how users see the server.
+func (s *Server) Label() string {
+	if s.Flag == "" {
+		return s.Name
+	}
+	return s.Flag + " " + s.Name
+}
+
+// Full reports whether the server has no room for another user given its
+// current number of users.
+func (s *Server) Full(users int) bool {
+	return s.Capacity > 0 && users >= s.Capacity
+}
+
+// Placement puts a user on a server. A placement with DrainUntil is left
+// over from a migration: the user stays in Xray there until then so that
+// old configs keep working, but the server is no longer offered to them.
+type Placement struct {
+	UserID     int64      `db:"user_id"`
+	ServerID   int64      `db:"server_id"`
+	DrainUntil *time.Time `db:"drain_until"`
+}
+
+// HealthChange is an entry of a server's up/down history.
+type HealthChange struct {
+	ID        int64     `db:"id"`
+	ServerID  int64     `db:"server_id"`
+	Health    string    `db:"health"`
+	Detail    string    `db:"detail"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// ServerCertificate is a TLS certificate an inbound of a server serves.
+// Mismatch is set when the server's inbound has no certificate for its
+// domain. Warned is the smallest number of days before expiry admins were
+// warned at, zero when they were not warned yet.
+type ServerCertificate struct {
+	ServerID    int64     `db:"server_id"`
+	Fingerprint string    `db:"fingerprint"`
+	InboundTag  string    `db:"inbound_tag"`
+	Source      string    `db:"source"`
+	Subject     string    `db:"subject"`
+	NotAfter    time.Time `db:"not_after"`
+	Mismatch    bool      `db:"mismatch"`
+	Warned      int       `db:"warned"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+const (
+	SharingActionWarn    = "warn"
+	SharingActionRotate  = "rotate"
+	SharingActionSuspend = "suspend"
+)
+
+// SharingBreach records one detection of more simultaneous IPs than the
+// user's limit and the escalation step taken.
+type SharingBreach struct {
+	ID        int64     `db:"id"`
+	UserID    int64     `db:"user_id"`
+	IPCount   int       `db:"ip_count"`
+	Action    string    `db:"action"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// SharingOffender aggregates breaches of one user for the admin report.
+type SharingOffender struct {
+	UserID   int64
+	Breaches int
+	MaxIPs   int
+	LastAt   time.Time
+}
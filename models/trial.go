@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Trial is the one trial a Telegram user can ever get. The row is kept after
+// the trial ends so it cannot be repeated.
+type Trial struct {
+	UserID       int64     `db:"user_id"`
+	StartedAt    time.Time `db:"started_at"`
+	ExpiresAt    time.Time `db:"expires_at"`
+	TrafficLimit int64     `db:"traffic_limit"`
+	TrafficUsed  int64     `db:"traffic_used"`
+	// TrafficCounter is the last raw Xray counter value, used to keep
+	// TrafficUsed growing across Xray restarts that reset the counter.
+	TrafficCounter int64      `db:"traffic_counter"`
+	EndedAt        *time.Time `db:"ended_at"`
+	EndReason      string     `db:"end_reason"`
+	ConvertedAt    *time.Time `db:"converted_at"`
+}
+
+func (t *Trial) Active() bool {
+	return t.EndedAt == nil
+}
+
+// TrialStats summarises trials for the admin report.
+type TrialStats struct {
+	Started   int
+	Active    int
+	Ended     int
+	Converted int
+}
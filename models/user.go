@@ -2,11 +2,55 @@ package models
 
 import "time"
 
+const (
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+	// UserStatusExpired users had their access end and are kept out of Xray
+	// until they renew.
+	UserStatusExpired = "expired"
+)
+
 type User struct {
-	ID        int64     `db:"user_id"`
-	Username  string    `db:"username"`
-	UUID      string    `db:"uuid"`
-	CreatedAt time.Time `db:"created_at"`
+	ID           int64     `db:"user_id"`
+	Username     string    `db:"username"`
+	UUID         string    `db:"uuid"`
+	CreatedAt    time.Time `db:"created_at"`
+	LanguageCode string    `db:"language_code"`
+	Status       string    `db:"status"`
+	// RoutingProfile is empty for users on the default profile.
+	RoutingProfile string `db:"routing_profile"`
+	// IPLimit overrides the default concurrent IP limit when positive.
+	IPLimit int `db:"ip_limit"`
+	// IsTrial marks users provisioned through a trial rather than a
+	// channel subscription.
+	IsTrial bool `db:"is_trial"`
+	// Plan and ExpiresAt describe time-limited access, bought or granted
+	// by an admin; users entitled only by the channel have no ExpiresAt.
+	// TrafficLimit is the plan's traffic quota in bytes, zero meaning
+	// unlimited.
+	Plan         string     `db:"plan"`
+	ExpiresAt    *time.Time `db:"expires_at"`
+	TrafficLimit int64      `db:"traffic_limit"`
+	// ExpiryReminder is the smallest number of days before ExpiresAt the
+	// user was already reminded at, zero if none.
+	ExpiryReminder int `db:"expiry_reminder"`
+	// TrafficUsed is the traffic used of TrafficLimit since the plan was
+	// last paid; TrafficCounter is the servers' counter at the last check.
+	TrafficUsed    int64 `db:"traffic_used"`
+	TrafficCounter int64 `db:"traffic_counter"`
+	// ServerID is the location the user picked. The user may be placed on
+	// further servers, see Database.GetUserServers.
+	ServerID int64 `db:"server_id"`
+}
+
+// HasPlanAccess reports whether the user's time-limited access runs at now.
+func (u *User) HasPlanAccess(now time.Time) bool {
+	return u.ExpiresAt != nil && u.ExpiresAt.After(now)
+}
+
+// QuotaExhausted reports whether the user used up the plan's traffic quota.
+func (u *User) QuotaExhausted() bool {
+	return u.TrafficLimit > 0 && u.TrafficUsed >= u.TrafficLimit
 }
 
 type XrayUser struct {
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// WireGuardPeer is a user's peer on a WireGuard server. Its address is
+// allocated from the server's subnet once and kept; its keys belong to the
+// user's UUID and are replaced when the UUID is rotated.
+type WireGuardPeer struct {
+	ServerID   int64     `db:"server_id"`
+	UserID     int64     `db:"user_id"`
+	UUID       string    `db:"uuid"`
+	PrivateKey string    `db:"private_key"`
+	PublicKey  string    `db:"public_key"`
+	Address    string    `db:"address"`
+	CreatedAt  time.Time `db:"created_at"`
+}
@@ -0,0 +1,130 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"xray-telegram-bot/config"
+)
+
+// HMACProvider talks to the common kind of card and crypto gateway that
+// creates invoices through a bearer-authenticated JSON API and signs its
+// callbacks with a hex HMAC-SHA256 of the body in the X-Signature header.
+type HMACProvider struct {
+	config config.PaymentProvider
+	client *http.Client
+}
+
+func NewHMACProvider(cfg config.PaymentProvider) *HMACProvider {
+	return &HMACProvider{
+		config: cfg,
+		client: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (p *HMACProvider) Name() string {
+	return p.config.Name
+}
+
+func (p *HMACProvider) Title() string {
+	return p.config.Title
+}
+
+type hmacInvoiceRequest struct {
+	OrderID     string `json:"order_id"`
+	Amount      int64  `json:"amount"`
+	Currency    string `json:"currency"`
+	Description string `json:"description"`
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+type hmacInvoiceResponse struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+type hmacWebhook struct {
+	InvoiceID string `json:"invoice_id"`
+	OrderID   string `json:"order_id"`
+	Status    string `json:"status"`
+	Amount    int64  `json:"amount"`
+	Currency  string `json:"currency"`
+}
+
+func (p *HMACProvider) CreateInvoice(ctx context.Context, order Order) (*Invoice, error) {
+	body, err := json.Marshal(hmacInvoiceRequest{
+		OrderID:     order.ID,
+		Amount:      order.Amount,
+		Currency:    order.Currency,
+		Description: order.Description,
+		CallbackURL: order.CallbackURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(p.config.BaseURL, "/")+"/invoices", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.config.APIKey)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create invoice: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("failed to create invoice: status %d, body: %s", resp.StatusCode, string(data))
+	}
+
+	var invoice hmacInvoiceResponse
+	if err := json.Unmarshal(data, &invoice); err != nil {
+		return nil, fmt.Errorf("failed to parse invoice: %v", err)
+	}
+	if invoice.ID == "" || invoice.URL == "" {
+		return nil, fmt.Errorf("invoice response misses id or url: %s", string(data))
+	}
+
+	return &Invoice{ID: invoice.ID, URL: invoice.URL}, nil
+}
+
+func (p *HMACProvider) ParseWebhook(r *http.Request, body []byte) (*Notification, error) {
+	signature, err := hex.DecodeString(r.Header.Get("X-Signature"))
+	if err != nil || !hmac.Equal(signature, p.sign(body)) {
+		return nil, ErrInvalidSignature
+	}
+
+	var webhook hmacWebhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		return nil, fmt.Errorf("failed to parse webhook: %v", err)
+	}
+
+	return &Notification{
+		InvoiceID: webhook.InvoiceID,
+		OrderID:   webhook.OrderID,
+		Paid:      webhook.Status == "paid",
+		Amount:    webhook.Amount,
+		Currency:  webhook.Currency,
+	}, nil
+}
+
+func (p *HMACProvider) sign(body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(p.config.Secret))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
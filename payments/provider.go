@@ -0,0 +1,61 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"xray-telegram-bot/config"
+)
+
+// ErrInvalidSignature is returned by ParseWebhook for callbacks that were not
+// signed by the provider.
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Order is what the bot asks a provider to charge for. Amount is in minor
+// currency units.
+type Order struct {
+	ID          string
+	Amount      int64
+	Currency    string
+	Description string
+	CallbackURL string
+}
+
+// Invoice is a created invoice the user pays by following URL.
+type Invoice struct {
+	ID  string
+	URL string
+}
+
+// Notification is a verified webhook callback.
+type Notification struct {
+	InvoiceID string
+	OrderID   string
+	Paid      bool
+	Amount    int64
+	Currency  string
+}
+
+// Provider is an external payment service that creates invoices and reports
+// their payment through signed webhooks.
+type Provider interface {
+	Name() string
+	Title() string
+	CreateInvoice(ctx context.Context, order Order) (*Invoice, error)
+	ParseWebhook(r *http.Request, body []byte) (*Notification, error)
+}
+
+// New builds the provider described by the bot config. Providers without a
+// webhook secret are refused, anyone could sign their callbacks.
+func New(cfg config.PaymentProvider) (Provider, error) {
+	switch cfg.Type {
+	case "hmac":
+		if cfg.Secret == "" {
+			return nil, fmt.Errorf("no webhook secret for payment provider %q", cfg.Name)
+		}
+		return NewHMACProvider(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider type %q", cfg.Type)
+	}
+}
@@ -0,0 +1,18 @@
+package payments
+
+import (
+	"testing"
+	"xray-telegram-bot/config"
+)
+
+func TestNewRequiresSecret(t *testing.T) {
+	cfg := config.PaymentProvider{Name: "stub", Type: "hmac", BaseURL: "https://pay.example.com"}
+	if _, err := New(cfg); err == nil {
+		t.Fatal("provider without a webhook secret accepted")
+	}
+
+	cfg.Secret = "secret"
+	if _, err := New(cfg); err != nil {
+		t.Fatal(err)
+	}
+}
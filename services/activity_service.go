@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/database"
+	"xray-telegram-bot/models"
+	"xray-telegram-bot/xray"
+)
+
+const (
+	activityFlushInterval = time.Minute
+	// topDestinations and activityRetention limit how much browsing history
+	// is kept per user.
+	topDestinations   = 10
+	activityRetention = 30 * 24 * time.Hour
+)
+
+// ActivityService follows the Xray access log and keeps per-user last-seen
+// times, distinct source IP counts and top destinations.
+type ActivityService struct {
+	db         *database.Database
+	xrayClient *xray.Client
+	config     *config.Config
+
+	mu    sync.Mutex
+	users map[int64]*userActivity
+}
+
+type userActivity struct {
+	day          string
+	lastSeen     time.Time
+	ips          map[string]bool
+	recent       map[string]time.Time
+	destinations map[string]int
+	dirty        bool
+}
+
+func NewActivityService(db *database.Database, xrayClient *xray.Client, cfg *config.Config) *ActivityService {
+	return &ActivityService{
+		db:         db,
+		xrayClient: xrayClient,
+		config:     cfg,
+		users:      make(map[int64]*userActivity),
+	}
+}
+
+// Start begins following the access log in the background.
+func (s *ActivityService) Start(ctx context.Context) {
+	path := s.config.AccessLogPath
+	if path == "" {
+		var err error
+		path, err = s.xrayClient.AccessLogPath()
+		if err != nil {
+			log.Printf("Warning: access log ingestion disabled: %v", err)
+			return
+		}
+	}
+
+	go func() {
+		for {
+			log.Printf("Following Xray access log %s", path)
+			if err := xray.FollowAccessLog(ctx, path, s.record); err != nil {
+				log.Printf("Error following access log: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Minute):
+			}
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(activityFlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				s.flush()
+				return
+			case <-ticker.C:
+				s.flush()
+			}
+		}
+	}()
+}
+
+func (s *ActivityService) record(record xray.AccessRecord) {
+	userID, ok := userIDFromEmail(record.Email)
+	if !ok {
+		return
+	}
+
+	day := record.Time.Format(models.DayLayout)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	activity, ok := s.users[userID]
+	if !ok {
+		activity = &userActivity{recent: make(map[string]time.Time)}
+		s.users[userID] = activity
+	}
+	if activity.day != day {
+		activity.day = day
+		activity.ips = make(map[string]bool)
+		activity.destinations = make(map[string]int)
+	}
+
+	if record.Time.After(activity.lastSeen) {
+		activity.lastSeen = record.Time
+	}
+	activity.ips[record.SourceIP] = true
+	if record.Time.After(activity.recent[record.SourceIP]) {
+		activity.recent[record.SourceIP] = record.Time
+	}
+	activity.destinations[normalizeDestination(record.Destination)]++
+	activity.dirty = true
+}
+
+func (s *ActivityService) flush() {
+	s.mu.Lock()
+	var deltas []database.ActivityDelta
+	for userID, activity := range s.users {
+		if !activity.dirty {
+			continue
+		}
+		deltas = append(deltas, database.ActivityDelta{
+			UserID:       userID,
+			Day:          activity.day,
+			LastSeen:     activity.lastSeen,
+			IPCount:      len(activity.ips),
+			Destinations: activity.destinations,
+		})
+		activity.destinations = make(map[string]int)
+		activity.dirty = false
+	}
+	s.mu.Unlock()
+
+	if err := s.db.SaveActivity(deltas, topDestinations, activityRetention); err != nil {
+		log.Printf("Error saving access log activity: %v", err)
+	}
+}
+
+// RecentIPs returns the source IPs the user connected from within window.
+// Older entries are forgotten.
+func (s *ActivityService) RecentIPs(userID int64, window time.Duration) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	activity, ok := s.users[userID]
+	if !ok {
+		return nil
+	}
+
+	cutoff := time.Now().Add(-window)
+	var ips []string
+	for ip, seen := range activity.recent {
+		if seen.Before(cutoff) {
+			delete(activity.recent, ip)
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	return ips
+}
+
+// GetActivity returns what is known about the user's connections over the
+// last week.
+func (s *ActivityService) GetActivity(userID int64) (*models.Activity, error) {
+	since := time.Now().AddDate(0, 0, -7).Format(models.DayLayout)
+	return s.db.GetActivity(userID, since, 5)
+}
+
+// normalizeDestination reduces a destination to what is needed for usage
+// statistics: the registrable part of a domain, and no literal IPs at all.
+func normalizeDestination(destination string) string {
+	if net.ParseIP(destination) != nil {
+		return "ip"
+	}
+
+	labels := strings.Split(strings.TrimSuffix(destination, "."), ".")
+	if len(labels) > 2 {
+		labels = labels[len(labels)-2:]
+	}
+	return strings.ToLower(strings.Join(labels, "."))
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"xray-telegram-bot/models"
+	"xray-telegram-bot/xray"
+)
+
+// Backend provisions users on a server. Xray servers are managed through
+// their xray.Client, panel servers through the panel's API and WireGuard
+// servers through their interface's config file.
+type Backend interface {
+	// AddUser gives the user access under email, replacing an earlier
+	// entry of the email.
+	AddUser(user *models.User, email string) (xray.Status, error)
+	RemoveUser(email string) (xray.Status, error)
+	// UpdateUser pushes the user's expiry and limits to backends that
+	// enforce them themselves.
+	UpdateUser(user *models.User, email string) error
+	UserTraffic(email string) (int64, error)
+	TestAPI() error
+}
+
+// LinkProvider is implemented by backends that hand out links of their
+// own, such as panels serving their own subscriptions. Backends without a
+// subscription of their own return an empty URL.
+type LinkProvider interface {
+	SubscriptionURL(email string) (string, error)
+	Links(email string) ([]string, error)
+}
+
+// UserLister is implemented by backends that can list the emails of their
+// users, so that drift from the database can be corrected.
+type UserLister interface {
+	UserEmails() ([]string, error)
+}
+
+// OnlineIPLister is implemented by backends that report the IPs a user is
+// connected from.
+type OnlineIPLister interface {
+	OnlineIPs(email string) ([]string, error)
+}
+
+// ConfigFileProvider is implemented by backends whose users import a config
+// file instead of a link.
+type ConfigFileProvider interface {
+	ConfigFile(user *models.User, email string) (name string, data []byte, err error)
+}
+
+// xrayBackend adapts an xray.Client. Xray knows no expiry or limits, the
+// bot enforces them.
+type xrayBackend struct {
+	*xray.Client
+}
+
+func (b xrayBackend) AddUser(user *models.User, email string) (xray.Status, error) {
+	return b.Client.AddUser(user.UUID, email)
+}
+
+func (b xrayBackend) UpdateUser(*models.User, string) error {
+	return nil
+}
+
+// brokenBackend fails every call with the error that kept the backend from
+// being set up.
+type brokenBackend struct {
+	err error
+}
+
+func (b brokenBackend) AddUser(*models.User, string) (xray.Status, error) { return "", b.err }
+func (b brokenBackend) RemoveUser(string) (xray.Status, error)            { return "", b.err }
+func (b brokenBackend) UpdateUser(*models.User, string) error             { return b.err }
+func (b brokenBackend) UserTraffic(string) (int64, error)                 { return 0, b.err }
+func (b brokenBackend) TestAPI() error                                    { return b.err }
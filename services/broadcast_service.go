@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/database"
+	"xray-telegram-bot/messages"
+	"xray-telegram-bot/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// progressEvery controls how often the admin's progress message is refreshed.
+const progressEvery = 25
+
+type BroadcastService struct {
+	bot  *tgbotapi.BotAPI
+	db   *database.Database
+	rate int
+
+	mu      sync.Mutex
+	running map[int64]context.CancelFunc
+}
+
+func NewBroadcastService(bot *tgbotapi.BotAPI, db *database.Database, cfg *config.Config) *BroadcastService {
+	return &BroadcastService{
+		bot:     bot,
+		db:      db,
+		rate:    cfg.BroadcastRate,
+		running: make(map[int64]context.CancelFunc),
+	}
+}
+
+func (s *BroadcastService) CreateDraft(adminID int64, text string) (*models.Broadcast, error) {
+	broadcast := &models.Broadcast{
+		AdminID: adminID,
+		Text:    text,
+		Segment: string(models.SegmentAll),
+		Status:  models.BroadcastDraft,
+	}
+
+	if err := s.db.CreateBroadcast(broadcast); err != nil {
+		return nil, err
+	}
+
+	return broadcast, nil
+}
+
+func (s *BroadcastService) Get(id int64) (*models.Broadcast, error) {
+	return s.db.GetBroadcast(id)
+}
+
+func (s *BroadcastService) SetSegment(id int64, segment string) error {
+	return s.db.UpdateBroadcastSegment(id, segment)
+}
+
+func (s *BroadcastService) Languages() ([]string, error) {
+	return s.db.GetUserLanguages()
+}
+
+// Recipients returns the IDs of the users the segment currently selects.
+func (s *BroadcastService) Recipients(segment string) ([]int64, error) {
+	users, err := s.db.GetAllUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	var userIDs []int64
+	for _, user := range users {
+		if models.Segment(segment).Matches(user) {
+			userIDs = append(userIDs, user.ID)
+		}
+	}
+
+	return userIDs, nil
+}
+
+// Start freezes the recipient list of a draft and begins sending it.
+func (s *BroadcastService) Start(id int64, progressMessageID int) error {
+	broadcast, err := s.db.GetBroadcast(id)
+	if err != nil {
+		return err
+	}
+	if broadcast == nil || broadcast.Status != models.BroadcastDraft {
+		return fmt.Errorf("broadcast %d is not a draft", id)
+	}
+
+	recipients, err := s.Recipients(broadcast.Segment)
+	if err != nil {
+		return err
+	}
+
+	if err := s.db.SetBroadcastProgressMessage(id, progressMessageID); err != nil {
+		return err
+	}
+	if err := s.db.StartBroadcast(id, recipients); err != nil {
+		return err
+	}
+
+	broadcast, err = s.db.GetBroadcast(id)
+	if err != nil {
+		return err
+	}
+
+	s.launch(broadcast)
+	return nil
+}
+
+// Cancel stops a draft or a broadcast that is being sent. Recipients that
+// were not reached yet stay pending.
+func (s *BroadcastService) Cancel(id int64) error {
+	broadcast, err := s.db.GetBroadcast(id)
+	if err != nil {
+		return err
+	}
+	if broadcast == nil || (broadcast.Status != models.BroadcastDraft && broadcast.Status != models.BroadcastSending) {
+		return fmt.Errorf("broadcast %d is not active", id)
+	}
+
+	if err := s.db.UpdateBroadcastStatus(id, models.BroadcastCancelled); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	cancel, ok := s.running[id]
+	s.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+
+	return nil
+}
+
+// Resume restarts broadcasts that were interrupted by a shutdown.
+func (s *BroadcastService) Resume() {
+	broadcasts, err := s.db.GetBroadcastsByStatus(models.BroadcastSending)
+	if err != nil {
+		log.Printf("Error loading unfinished broadcasts: %v", err)
+		return
+	}
+
+	for _, broadcast := range broadcasts {
+		log.Printf("Resuming broadcast %d", broadcast.ID)
+		s.launch(broadcast)
+	}
+}
+
+func (s *BroadcastService) launch(broadcast *models.Broadcast) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.mu.Lock()
+	if _, ok := s.running[broadcast.ID]; ok {
+		s.mu.Unlock()
+		cancel()
+		return
+	}
+	s.running[broadcast.ID] = cancel
+	s.mu.Unlock()
+
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			delete(s.running, broadcast.ID)
+			s.mu.Unlock()
+			cancel()
+		}()
+		s.run(ctx, broadcast)
+	}()
+}
+
+func (s *BroadcastService) run(ctx context.Context, broadcast *models.Broadcast) {
+	recipients, err := s.db.GetPendingRecipients(broadcast.ID)
+	if err != nil {
+		log.Printf("Error loading recipients for broadcast %d: %v", broadcast.ID, err)
+		return
+	}
+
+	ticker := time.NewTicker(time.Second / time.Duration(s.rate))
+	defer ticker.Stop()
+
+	for i, userID := range recipients {
+		select {
+		case <-ctx.Done():
+			log.Printf("Broadcast %d cancelled", broadcast.ID)
+			s.reportProgress(broadcast.ID)
+			return
+		case <-ticker.C:
+		}
+
+		state := s.deliver(ctx, userID, broadcast.Text)
+		if err := s.db.RecordDelivery(broadcast.ID, userID, state); err != nil {
+			log.Printf("Error recording delivery of broadcast %d to user %d: %v", broadcast.ID, userID, err)
+		}
+
+		if (i+1)%progressEvery == 0 {
+			s.reportProgress(broadcast.ID)
+		}
+	}
+
+	// Cancel may have run after the last delivery, its status stands.
+	finished := false
+	if ctx.Err() == nil {
+		if finished, err = s.db.FinishBroadcast(broadcast.ID); err != nil {
+			log.Printf("Error finishing broadcast %d: %v", broadcast.ID, err)
+		}
+	}
+
+	if finished {
+		log.Printf("Broadcast %d completed", broadcast.ID)
+	} else {
+		log.Printf("Broadcast %d cancelled", broadcast.ID)
+	}
+	s.reportProgress(broadcast.ID)
+}
+
+// deliver sends one message, honouring a single flood-wait retry, and maps
+// the result to a recipient state.
+func (s *BroadcastService) deliver(ctx context.Context, userID int64, text string) string {
+	for attempt := 0; attempt < 2; attempt++ {
+		_, err := s.bot.Send(tgbotapi.NewMessage(userID, text))
+		if err == nil {
+			return models.RecipientDelivered
+		}
+
+		var apiErr *tgbotapi.Error
+		if !errors.As(err, &apiErr) {
+			log.Printf("Error sending broadcast to user %d: %v", userID, err)
+			return models.RecipientFailed
+		}
+
+		switch {
+		case apiErr.Code == 403:
+			return models.RecipientBlocked
+		case apiErr.Code == 429 && apiErr.RetryAfter > 0:
+			select {
+			case <-ctx.Done():
+				return models.RecipientFailed
+			case <-time.After(time.Duration(apiErr.RetryAfter) * time.Second):
+			}
+		default:
+			log.Printf("Error sending broadcast to user %d: %v", userID, err)
+			return models.RecipientFailed
+		}
+	}
+
+	return models.RecipientFailed
+}
+
+func (s *BroadcastService) reportProgress(id int64) {
+	broadcast, err := s.db.GetBroadcast(id)
+	if err != nil || broadcast == nil {
+		log.Printf("Error loading broadcast %d: %v", id, err)
+		return
+	}
+	if broadcast.ProgressMessageID == 0 {
+		return
+	}
+
+	text := messages.FormatBroadcastProgress(broadcast)
+	edit := tgbotapi.NewEditMessageText(broadcast.AdminID, broadcast.ProgressMessageID, text)
+	if broadcast.Status == models.BroadcastSending {
+		markup := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(messages.BroadcastStopButton, fmt.Sprintf("bc:cancel:%d", id)),
+		))
+		edit.ReplyMarkup = &markup
+	}
+
+	if _, err := s.bot.Send(edit); err != nil {
+		log.Printf("Error updating progress of broadcast %d: %v", id, err)
+	}
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"xray-telegram-bot/models"
+)
+
+func TestBroadcastKeepsCancel(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.BroadcastRate = 10
+	db := newTestDB(t)
+	bot, _ := newFakeBot(t)
+	broadcasts := NewBroadcastService(bot, db, cfg)
+
+	finish := func(cancelled bool) string {
+		broadcast, err := broadcasts.CreateDraft(1, "text")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.StartBroadcast(broadcast.ID, []int64{2}); err != nil {
+			t.Fatal(err)
+		}
+		if cancelled {
+			// Cancel stored its status after the last delivery, before
+			// the context was cancelled.
+			if err := db.UpdateBroadcastStatus(broadcast.ID, models.BroadcastCancelled); err != nil {
+				t.Fatal(err)
+			}
+		}
+		broadcasts.run(context.Background(), broadcast)
+
+		broadcast, err = db.GetBroadcast(broadcast.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return broadcast.Status
+	}
+
+	if status := finish(false); status != models.BroadcastDone {
+		t.Fatalf("finished broadcast is %s", status)
+	}
+	if status := finish(true); status != models.BroadcastCancelled {
+		t.Fatalf("cancelled broadcast is %s", status)
+	}
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"log"
+	"time"
+	"xray-telegram-bot/models"
+)
+
+// Entitlement is the reason a user may have VPN access.
+type Entitlement string
+
+const (
+	EntitlementNone    Entitlement = ""
+	EntitlementPlan    Entitlement = "plan"
+	EntitlementChannel Entitlement = "channel"
+	EntitlementTrial   Entitlement = "trial"
+)
+
+// entitlement finds the first reason for the user to have access: running
+// time-limited access with traffic left, channel membership or an active trial. user may be
+// nil for users who were never provisioned. The trial is returned for
+// EntitlementTrial.
+func (s *TelegramService) entitlement(userID int64, user *models.User) (Entitlement, *models.Trial, error) {
+	if user != nil && user.HasPlanAccess(time.Now()) && !user.QuotaExhausted() {
+		return EntitlementPlan, nil, nil
+	}
+
+	isSubscribed, err := s.checkSubscription(userID)
+	if err != nil {
+		return EntitlementNone, nil, err
+	}
+	if isSubscribed {
+		return EntitlementChannel, nil, nil
+	}
+
+	trial, err := s.trialService.ActiveTrial(userID)
+	if err != nil {
+		log.Printf("Error checking trial of user %d: %v", userID, err)
+	}
+	if trial != nil {
+		return EntitlementTrial, trial, nil
+	}
+
+	return EntitlementNone, nil, nil
+}
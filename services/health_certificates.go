@@ -0,0 +1,142 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"time"
+	"xray-telegram-bot/messages"
+	"xray-telegram-bot/models"
+	"xray-telegram-bot/xray"
+)
+
+const certificateCheckInterval = 6 * time.Hour
+
+// certificateWarningDays are the days before a certificate expires at which
+// admins are warned, in ascending order.
+var certificateWarningDays = []int{1, 7, 14}
+
+func (s *HealthService) StartCertificateChecker() {
+	go func() {
+		for {
+			s.checkCertificates()
+			time.Sleep(certificateCheckInterval)
+		}
+	}()
+}
+
+func (s *HealthService) checkCertificates() {
+	servers, err := s.servers.Servers()
+	if err != nil {
+		log.Printf("Error querying servers: %v", err)
+		return
+	}
+
+	for _, server := range servers {
+		if !server.Enabled || !server.HasConfig() {
+			continue
+		}
+		if err := s.checkServerCertificates(server); err != nil {
+			log.Printf("Error checking certificates of server %s: %v", server.Name, err)
+		}
+	}
+}
+
+// checkServerCertificates reads the certificates the server's inbounds
+// serve, warns admins about the ones expiring soon and alerts them right
+// away when the server's own inbound has none for its domain.
+func (s *HealthService) checkServerCertificates(server *models.Server) error {
+	found, err := s.servers.Client(server).Certificates()
+	if err != nil {
+		return err
+	}
+
+	known, err := s.db.GetServerCertificates(server.ID)
+	if err != nil {
+		return err
+	}
+	previous := make(map[string]*models.ServerCertificate, len(known))
+	for _, certificate := range known {
+		previous[certificate.Fingerprint] = certificate
+	}
+
+	var certificates []*models.ServerCertificate
+	covered := make(map[string]bool)
+	for _, entry := range found {
+		leaf, err := parseLeaf(entry)
+		if err != nil {
+			log.Printf("Error parsing certificate %s of server %s: %v", entry.Source, server.Name, err)
+			continue
+		}
+
+		sum := sha256.Sum256(leaf.Raw)
+		certificate := &models.ServerCertificate{
+			ServerID:    server.ID,
+			Fingerprint: hex.EncodeToString(sum[:]),
+			InboundTag:  entry.InboundTag,
+			Source:      entry.Source,
+			Subject:     leaf.Subject.CommonName,
+			NotAfter:    leaf.NotAfter,
+		}
+		if certificate.Subject == "" && len(leaf.DNSNames) > 0 {
+			certificate.Subject = leaf.DNSNames[0]
+		}
+		if old, ok := previous[certificate.Fingerprint]; ok {
+			certificate.Warned = old.Warned
+		}
+		if leaf.VerifyHostname(server.Domain) == nil {
+			covered[entry.InboundTag] = true
+		}
+		certificates = append(certificates, certificate)
+	}
+
+	now := time.Now()
+	for _, certificate := range certificates {
+		certificate.Mismatch = certificate.InboundTag == server.InboundTag && !covered[certificate.InboundTag]
+		if _, ok := previous[certificate.Fingerprint]; certificate.Mismatch && !ok {
+			s.alertAdmins(messages.FormatCertificateMismatch(server, certificate))
+		}
+		s.warnExpiry(server, certificate, certificate.NotAfter.Sub(now))
+	}
+
+	return s.db.ReplaceServerCertificates(server.ID, certificates)
+}
+
+// warnExpiry warns admins once per threshold of certificateWarningDays.
+func (s *HealthService) warnExpiry(server *models.Server, certificate *models.ServerCertificate, remaining time.Duration) {
+	for _, days := range certificateWarningDays {
+		if remaining > time.Duration(days)*24*time.Hour {
+			continue
+		}
+		if certificate.Warned != 0 && certificate.Warned <= days {
+			return
+		}
+
+		s.alertAdmins(messages.FormatCertificateExpiry(server, certificate, remaining))
+		certificate.Warned = days
+		return
+	}
+}
+
+// Certificates returns the certificates last found on the server.
+func (s *HealthService) Certificates(server *models.Server) ([]*models.ServerCertificate, error) {
+	return s.db.GetServerCertificates(server.ID)
+}
+
+// parseLeaf returns the first certificate of a PEM chain.
+func parseLeaf(certificate xray.Certificate) (*x509.Certificate, error) {
+	rest := certificate.PEM
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, fmt.Errorf("no certificate in PEM data")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/database"
+	"xray-telegram-bot/messages"
+	"xray-telegram-bot/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	probeTimeout = 10 * time.Second
+	// healthConfirmations is how many probes in a row must agree before a
+	// server changes state, so that a single lost probe does not flap it.
+	healthConfirmations = 2
+)
+
+// HealthService probes every server's public endpoint and management API,
+// keeps their up/down history and alerts admins on changes.
+type HealthService struct {
+	bot     *tgbotapi.BotAPI
+	db      *database.Database
+	config  *config.Config
+	servers *ServerService
+
+	mu      sync.Mutex
+	pending map[int64]pendingHealth
+}
+
+// pendingHealth is a state a server's probes agree on but that is not
+// confirmed yet.
+type pendingHealth struct {
+	health string
+	count  int
+}
+
+func NewHealthService(bot *tgbotapi.BotAPI, db *database.Database, cfg *config.Config, servers *ServerService) *HealthService {
+	return &HealthService{
+		bot:     bot,
+		db:      db,
+		config:  cfg,
+		servers: servers,
+		pending: make(map[int64]pendingHealth),
+	}
+}
+
+func (s *HealthService) Start() {
+	go func() {
+		for {
+			s.probeAll()
+			time.Sleep(s.config.HealthCheckInterval)
+		}
+	}()
+}
+
+func (s *HealthService) probeAll() {
+	servers, err := s.servers.Servers()
+	if err != nil {
+		log.Printf("Error querying servers: %v", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		if !server.Enabled {
+			continue
+		}
+
+		wg.Add(1)
+		go func(server *models.Server) {
+			defer wg.Done()
+			health, detail := s.probe(server)
+			s.record(server, health, detail)
+		}(server)
+	}
+	wg.Wait()
+}
+
+// probe connects to the server's endpoint the way clients do, a TCP connect
+// and a TLS handshake with the server's domain as SNI, and then checks its
+// management API. WireGuard, Hysteria2 and TUIC run over UDP and do not
+// answer unauthenticated packets, so only the backend is checked there.
+func (s *HealthService) probe(server *models.Server) (string, string) {
+	if server.Backend == models.BackendWireGuard || server.Backend == models.BackendSingBox {
+		if err := s.servers.Backend(server).TestAPI(); err != nil {
+			return models.ServerDown, err.Error()
+		}
+		return models.ServerUp, ""
+	}
+
+	address := net.JoinHostPort(server.Domain, strconv.Itoa(server.Port))
+
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return models.ServerDown, err.Error()
+	}
+	defer conn.Close()
+
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: server.Domain})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return models.ServerDown, fmt.Sprintf("handshake: %v", err)
+	}
+
+	if err := s.servers.Backend(server).TestAPI(); err != nil {
+		return models.ServerDegraded, err.Error()
+	}
+	return models.ServerUp, ""
+}
+
+// record moves the server to the probed state once enough probes agree.
+func (s *HealthService) record(server *models.Server, health, detail string) {
+	s.mu.Lock()
+	if health == server.Health {
+		delete(s.pending, server.ID)
+		s.mu.Unlock()
+		return
+	}
+
+	pending := s.pending[server.ID]
+	if pending.health != health {
+		pending = pendingHealth{health: health}
+	}
+	pending.count++
+	confirmed := pending.count >= healthConfirmations
+	if confirmed {
+		delete(s.pending, server.ID)
+	} else {
+		s.pending[server.ID] = pending
+	}
+	s.mu.Unlock()
+
+	if !confirmed {
+		return
+	}
+
+	change := &models.HealthChange{ServerID: server.ID, Health: health, Detail: detail, CreatedAt: time.Now()}
+	if err := s.db.UpdateServerHealth(change); err != nil {
+		log.Printf("Error recording health of server %s: %v", server.Name, err)
+		return
+	}
+
+	log.Printf("Server %s is %s: %s", server.Name, health, detail)
+	s.alertAdmins(messages.FormatHealthChange(server, change))
+}
+
+// History returns the latest health changes of the server.
+func (s *HealthService) History(server *models.Server, limit int) ([]*models.HealthChange, error) {
+	return s.db.GetServerHealth(server.ID, limit)
+}
+
+func (s *HealthService) alertAdmins(text string) {
+	for _, adminID := range s.config.AdminIDs {
+		if _, err := s.bot.Send(tgbotapi.NewMessage(adminID, text)); err != nil {
+			log.Printf("Error alerting admin %d: %v", adminID, err)
+		}
+	}
+}
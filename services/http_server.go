@@ -0,0 +1,33 @@
+package services
+
+import (
+	"log"
+	"net/http"
+	"time"
+	"xray-telegram-bot/config"
+)
+
+// StartHTTPServer serves payment provider webhooks and subscriptions on
+// WEBHOOK_LISTEN.
+func StartHTTPServer(cfg *config.Config, paymentService *PaymentService, userService *UserService) {
+	if cfg.WebhookListen == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(webhookPath, paymentService.handleWebhook)
+	mux.HandleFunc(subscriptionPath, userService.handleSubscription)
+
+	server := &http.Server{
+		Addr:              cfg.WebhookListen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		log.Printf("HTTP server listening on %s", cfg.WebhookListen)
+		if err := server.ListenAndServe(); err != nil {
+			log.Printf("HTTP server stopped: %v", err)
+		}
+	}()
+}
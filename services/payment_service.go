@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/database"
+	"xray-telegram-bot/messages"
+	"xray-telegram-bot/models"
+	"xray-telegram-bot/payments"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// starsCurrency is the Telegram Stars currency code. Stars invoices are sent
+// without a provider token.
+const starsCurrency = "XTR"
+
+// PaymentService sells plans and applies completed payments to users.
+type PaymentService struct {
+	bot         *tgbotapi.BotAPI
+	db          *database.Database
+	config      *config.Config
+	userService *UserService
+	providers   map[string]payments.Provider
+
+	// mu serialises payment application so that paid-until dates of
+	// concurrent purchases add up.
+	mu sync.Mutex
+}
+
+func NewPaymentService(bot *tgbotapi.BotAPI, db *database.Database, cfg *config.Config, userService *UserService) *PaymentService {
+	providers := make(map[string]payments.Provider)
+	for _, providerConfig := range cfg.PaymentProviders {
+		provider, err := payments.New(providerConfig)
+		if err != nil {
+			log.Printf("Payment provider %q disabled: %v", providerConfig.Name, err)
+			continue
+		}
+		providers[provider.Name()] = provider
+	}
+
+	return &PaymentService{
+		bot:         bot,
+		db:          db,
+		config:      cfg,
+		userService: userService,
+		providers:   providers,
+	}
+}
+
+// Providers lists the external payment providers in config order. They are
+// only usable when the webhook server is configured.
+func (s *PaymentService) Providers() []payments.Provider {
+	if s.config.WebhookListen == "" {
+		return nil
+	}
+
+	var providers []payments.Provider
+	for _, providerConfig := range s.config.PaymentProviders {
+		if provider, ok := s.providers[providerConfig.Name]; ok {
+			providers = append(providers, provider)
+		}
+	}
+	return providers
+}
+
+// planPayload ties an invoice to the plan and the buyer.
+func planPayload(planName string, userID int64) string {
+	return fmt.Sprintf("plan:%s:%d", planName, userID)
+}
+
+func parsePlanPayload(payload string) (string, int64, bool) {
+	parts := strings.Split(payload, ":")
+	if len(parts) != 3 || parts[0] != "plan" {
+		return "", 0, false
+	}
+
+	userID, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return parts[1], userID, true
+}
+
+// orderID is planPayload made unique per invoice, since external providers
+// reject repeated order IDs.
+func orderID(planName string, userID int64) string {
+	return planPayload(planName, userID) + ":" + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+func parseOrderID(orderID string) (string, int64, bool) {
+	i := strings.LastIndex(orderID, ":")
+	if i < 0 {
+		return "", 0, false
+	}
+	return parsePlanPayload(orderID[:i])
+}
+
+// SendStarsInvoice sends a Telegram Stars invoice for the plan. The request
+// is built by hand because the library always sends a provider token and tip
+// amounts, which Stars invoices must not have.
+func (s *PaymentService) SendStarsInvoice(chatID, userID int64, plan config.Plan) error {
+	prices, err := json.Marshal([]tgbotapi.LabeledPrice{{Label: plan.Title, Amount: plan.Stars}})
+	if err != nil {
+		return err
+	}
+
+	params := tgbotapi.Params{
+		"chat_id":     strconv.FormatInt(chatID, 10),
+		"title":       plan.Title,
+		"description": messages.FormatPlan(plan),
+		"payload":     planPayload(plan.Name, userID),
+		"currency":    starsCurrency,
+		"prices":      string(prices),
+	}
+
+	_, err = s.bot.MakeRequest("sendInvoice", params)
+	return err
+}
+
+// CreateInvoice asks an external provider for a payment link for the plan.
+func (s *PaymentService) CreateInvoice(ctx context.Context, providerName string, userID int64, plan config.Plan) (*payments.Invoice, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return nil, fmt.Errorf("unknown payment provider %q", providerName)
+	}
+	if plan.Price <= 0 {
+		return nil, fmt.Errorf("plan %s has no price", plan.Name)
+	}
+
+	return provider.CreateInvoice(ctx, payments.Order{
+		ID:          orderID(plan.Name, userID),
+		Amount:      plan.Price,
+		Currency:    plan.Currency,
+		Description: plan.Title + ": " + messages.FormatPlan(plan),
+		CallbackURL: strings.TrimSuffix(s.config.WebhookPublicURL, "/") + webhookPath + provider.Name(),
+	})
+}
+
+// ValidateCheckout decides whether Telegram may charge the user. The returned
+// error text is shown to the user.
+func (s *PaymentService) ValidateCheckout(userID int64, payload, currency string, amount int) error {
+	planName, payloadUserID, ok := parsePlanPayload(payload)
+	if !ok || payloadUserID != userID {
+		return errors.New(messages.PaymentInvalidInvoice)
+	}
+
+	plan, ok := s.config.Plan(planName)
+	if !ok || currency != starsCurrency || amount != plan.Stars {
+		return errors.New(messages.PaymentInvalidInvoice)
+	}
+
+	ban, err := s.db.GetBan(userID)
+	if err != nil {
+		log.Printf("Error checking ban of user %d: %v", userID, err)
+		return errors.New(messages.PaymentUnavailable)
+	}
+	if ban != nil {
+		return errors.New(messages.PaymentUnavailable)
+	}
+
+	return nil
+}
+
+// Complete applies a payment reported by a provider. Repeated reports of the
+// same charge return the stored payment and false.
+func (s *PaymentService) Complete(userID int64, username, provider, planName string, amount int64, currency, chargeID, providerChargeID string) (*models.Payment, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.db.GetPaymentByCharge(provider, chargeID)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing != nil {
+		return existing, false, nil
+	}
+
+	plan, ok := s.config.Plan(planName)
+	if !ok {
+		return nil, false, fmt.Errorf("unknown plan %q", planName)
+	}
+
+	user, err := s.db.GetUser(userID)
+	if err != nil {
+		return nil, false, err
+	}
+	if user == nil {
+		if _, _, err := s.userService.createUser(&models.User{ID: userID, Username: username}, "paid plan "+plan.Name); err != nil {
+			return nil, false, err
+		}
+		if user, err = s.db.GetUser(userID); err != nil {
+			return nil, false, err
+		}
+	}
+
+	now := time.Now()
+	base := now
+	if user.HasPlanAccess(now) {
+		base = *user.ExpiresAt
+	}
+
+	payment := &models.Payment{
+		UserID:           userID,
+		Provider:         provider,
+		Plan:             plan.Name,
+		Days:             plan.Days,
+		Amount:           amount,
+		Currency:         currency,
+		ChargeID:         chargeID,
+		ProviderChargeID: providerChargeID,
+		PaidUntil:        base.AddDate(0, 0, plan.Days),
+		CreatedAt:        now,
+	}
+
+	ipLimit := user.IPLimit
+	if plan.Devices > 0 {
+		ipLimit = plan.Devices
+	}
+
+	applied, err := s.db.ApplyPayment(payment, ipLimit, int64(plan.TrafficGB)<<30)
+	if err != nil || !applied {
+		return payment, false, err
+	}
+
+	s.userService.recordEvent(userID, models.UserActor(userID), models.EventPaid,
+		fmt.Sprintf("%s via %s until %s", plan.Name, provider, payment.PaidUntil.Format(time.DateOnly)), "")
+	s.userService.syncLimits(userID)
+
+	if user.Status == models.UserStatusExpired {
+		if err := s.userService.RenewUser(userID, models.UserActor(userID), "paid "+plan.Name); err != nil {
+			log.Printf("Error restoring expired user %d after payment: %v", userID, err)
+		}
+	}
+
+	return payment, true, nil
+}
+
+// Refund returns a Stars payment to the user and takes back the days it
+// added.
+func (s *PaymentService) Refund(adminID int64, chargeID string) (*models.Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payment, err := s.db.GetPaymentByCharge(models.PaymentProviderStars, chargeID)
+	if err != nil {
+		return nil, err
+	}
+	if payment == nil {
+		return nil, fmt.Errorf("payment %s not found", chargeID)
+	}
+	if payment.RefundedAt != nil {
+		return nil, fmt.Errorf("payment %s was already refunded", chargeID)
+	}
+
+	if _, err := s.bot.MakeRequest("refundStarPayment", tgbotapi.Params{
+		"user_id":                    strconv.FormatInt(payment.UserID, 10),
+		"telegram_payment_charge_id": chargeID,
+	}); err != nil {
+		return nil, err
+	}
+
+	user, err := s.db.GetUser(payment.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now()
+	if user != nil && user.ExpiresAt != nil {
+		expiresAt = user.ExpiresAt.AddDate(0, 0, -payment.Days)
+	}
+
+	if err := s.db.RefundPayment(payment.ID, payment.UserID, expiresAt); err != nil {
+		return nil, err
+	}
+
+	s.userService.recordEvent(payment.UserID, models.AdminActor(adminID), models.EventRefunded, chargeID, "")
+	return payment, nil
+}
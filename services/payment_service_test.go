@@ -0,0 +1,135 @@
+package services
+
+import (
+	"strconv"
+	"testing"
+	"time"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func newTestPaymentBot(t *testing.T) (*TelegramService, *fakeBotAPI) {
+	cfg := testConfig(t)
+	cfg.Plans = []config.Plan{{Name: "month", Title: "Month", Days: 30, TrafficGB: 100, Devices: 3, Stars: 250}}
+
+	db := newTestDB(t)
+	bot, fake := newFakeBot(t)
+	userService := newTestUserService(t, db, cfg)
+	return &TelegramService{
+		bot:            bot,
+		config:         cfg,
+		userService:    userService,
+		paymentService: NewPaymentService(bot, db, cfg, userService),
+	}, fake
+}
+
+func TestStarsPayment(t *testing.T) {
+	s, fake := newTestPaymentBot(t)
+	buyer := &tgbotapi.User{ID: 42, UserName: "buyer"}
+	chat := &tgbotapi.Chat{ID: 42}
+
+	s.HandleCallback(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      "1",
+		From:    buyer,
+		Message: &tgbotapi.Message{Chat: chat},
+		Data:    "buy:month",
+	}})
+	invoices := fake.calls("sendInvoice")
+	if len(invoices) != 1 {
+		t.Fatalf("sent %d invoices, want 1", len(invoices))
+	}
+	invoice := invoices[0].params
+	if invoice["currency"] != starsCurrency || invoice["prices"] != `[{"label":"Month","amount":250}]` {
+		t.Fatalf("unexpected invoice %v", invoice)
+	}
+	if _, ok := invoice["provider_token"]; ok {
+		t.Fatal("Stars invoice has a provider token")
+	}
+	payload := invoice["payload"]
+
+	checkout := func(amount int) map[string]string {
+		s.HandlePreCheckout(tgbotapi.Update{PreCheckoutQuery: &tgbotapi.PreCheckoutQuery{
+			ID:             strconv.Itoa(amount),
+			From:           buyer,
+			Currency:       starsCurrency,
+			TotalAmount:    amount,
+			InvoicePayload: payload,
+		}})
+		answers := fake.calls("answerPreCheckoutQuery")
+		return answers[len(answers)-1].params
+	}
+	if answer := checkout(1); answer["ok"] == "true" || answer["error_message"] == "" {
+		t.Fatalf("pre-checkout of a wrong amount answered %v", answer)
+	}
+	if answer := checkout(250); answer["ok"] != "true" {
+		t.Fatalf("pre-checkout answered %v", answer)
+	}
+
+	pay := func() {
+		s.HandleMessage(tgbotapi.Update{Message: &tgbotapi.Message{
+			From: buyer,
+			Chat: chat,
+			SuccessfulPayment: &tgbotapi.SuccessfulPayment{
+				Currency:                starsCurrency,
+				TotalAmount:             250,
+				InvoicePayload:          payload,
+				TelegramPaymentChargeID: "charge-1",
+			},
+		}})
+	}
+	pay()
+
+	user, err := s.userService.GetUser(buyer.ID)
+	if err != nil || user == nil {
+		t.Fatalf("buyer not provisioned: %v", err)
+	}
+	if user.Plan != "month" || user.IPLimit != 3 || user.TrafficLimit != 100<<30 {
+		t.Fatalf("buyer not on the plan: %+v", user)
+	}
+	if expiresAt := time.Now().AddDate(0, 0, 30); user.ExpiresAt == nil || user.ExpiresAt.Sub(expiresAt).Abs() > time.Minute {
+		t.Fatalf("buyer's access ends at %v, want about %v", user.ExpiresAt, expiresAt)
+	}
+	paidUntil := *user.ExpiresAt
+
+	// Telegram may deliver the update again.
+	pay()
+
+	payments, err := s.paymentService.db.GetUserPayments(buyer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 1 || payments[0].ChargeID != "charge-1" || payments[0].Provider != models.PaymentProviderStars {
+		t.Fatalf("unexpected payments %+v", payments)
+	}
+	if user, _ = s.userService.GetUser(buyer.ID); !user.ExpiresAt.Equal(paidUntil) {
+		t.Fatalf("repeated charge moved the access end from %v to %v", paidUntil, user.ExpiresAt)
+	}
+}
+
+func TestPaymentConvertsTrial(t *testing.T) {
+	s, _ := newTestPaymentBot(t)
+	db := s.paymentService.db
+	trials := NewTrialService(s.bot, db, s.config, s.userService)
+	s.config.TrialDuration = time.Hour
+
+	trial, _, _, err := trials.Start(42, "buyer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.paymentService.Complete(42, "buyer", models.PaymentProviderStars, "month", 250, starsCurrency, "charge-1", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if trial, err = db.GetTrial(42); err != nil || trial.Active() || trial.ConvertedAt == nil {
+		t.Fatalf("trial not converted: %+v, %v", trial, err)
+	}
+
+	// The checker ends a trial that ran out before it saw the conversion.
+	trial.ExpiresAt = time.Now().Add(-time.Minute)
+	trials.end(trial, "expired")
+	if user, err := db.GetUser(42); err != nil || user == nil || user.IsTrial {
+		t.Fatalf("paid user removed with the trial: %+v, %v", user, err)
+	}
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"xray-telegram-bot/messages"
+	"xray-telegram-bot/payments"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// webhookPath is where providers post their callbacks, followed by the
+// provider name.
+const webhookPath = "/payments/"
+
+// handleWebhook verifies and applies a provider callback. Providers retry
+// until they get a 2xx, so only failures worth retrying answer 5xx; repeated
+// callbacks for an applied invoice are acknowledged without side effects.
+func (s *PaymentService) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	providerName := strings.TrimPrefix(r.URL.Path, webhookPath)
+	provider, ok := s.providers[providerName]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	notification, err := provider.ParseWebhook(r, body)
+	if errors.Is(err, payments.ErrInvalidSignature) {
+		log.Printf("Rejected %s webhook from %s: %v", providerName, r.RemoteAddr, err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Printf("Error parsing %s webhook: %v", providerName, err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.applyNotification(provider, notification); err != nil {
+		log.Printf("Error applying %s invoice %s: %v", providerName, notification.InvoiceID, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// applyNotification completes the order behind a paid invoice and tells the
+// buyer. Notifications that cannot ever be applied are logged and dropped.
+func (s *PaymentService) applyNotification(provider payments.Provider, notification *payments.Notification) error {
+	if !notification.Paid {
+		return nil
+	}
+
+	planName, userID, ok := parseOrderID(notification.OrderID)
+	if !ok {
+		log.Printf("%s invoice %s has unknown order %q", provider.Name(), notification.InvoiceID, notification.OrderID)
+		return nil
+	}
+
+	plan, ok := s.config.Plan(planName)
+	if !ok || notification.Amount != plan.Price || !strings.EqualFold(notification.Currency, plan.Currency) {
+		log.Printf("%s invoice %s of user %d does not match plan %q: %d %s", provider.Name(), notification.InvoiceID,
+			userID, planName, notification.Amount, notification.Currency)
+		return nil
+	}
+
+	payment, applied, err := s.Complete(userID, "", provider.Name(), plan.Name, notification.Amount, notification.Currency,
+		notification.InvoiceID, notification.OrderID)
+	if err != nil {
+		return err
+	}
+	if !applied {
+		log.Printf("%s invoice %s of user %d already applied", provider.Name(), notification.InvoiceID, userID)
+		return nil
+	}
+
+	log.Printf("Payment %s of user %d applied: plan %s until %s", payment.ChargeID, userID, payment.Plan, payment.PaidUntil)
+	msg := tgbotapi.NewMessage(userID, fmt.Sprintf(messages.PaymentSuccess, plan.Title, payment.PaidUntil.Format(messages.TimeLayout)))
+	if _, err := s.bot.Send(msg); err != nil {
+		log.Printf("Error notifying user %d about payment: %v", userID, err)
+	}
+	return nil
+}
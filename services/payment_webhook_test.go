@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"xray-telegram-bot/config"
+)
+
+const testProviderSecret = "webhook-secret"
+
+// stubProvider is a payment gateway of the hmac type. It hands out one
+// invoice per order and remembers the order for its callbacks.
+type stubProvider struct {
+	orders map[string]string
+}
+
+func (p *stubProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/invoices" || r.Header.Get("Authorization") != "Bearer api-key" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	var order struct {
+		OrderID string `json:"order_id"`
+	}
+	json.NewDecoder(r.Body).Decode(&order)
+
+	invoiceID := "inv-" + order.OrderID[strings.LastIndex(order.OrderID, ":")+1:]
+	p.orders[invoiceID] = order.OrderID
+	json.NewEncoder(w).Encode(map[string]string{"id": invoiceID, "url": "https://pay.example.com/" + invoiceID})
+}
+
+// callback is the body the provider posts when the invoice is paid.
+func (p *stubProvider) callback(invoiceID string, amount int64) []byte {
+	body, _ := json.Marshal(map[string]interface{}{
+		"invoice_id": invoiceID,
+		"order_id":   p.orders[invoiceID],
+		"status":     "paid",
+		"amount":     amount,
+		"currency":   "RUB",
+	})
+	return body
+}
+
+func signWebhook(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(testProviderSecret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestProviderWebhook(t *testing.T) {
+	stub := &stubProvider{orders: make(map[string]string)}
+	gateway := httptest.NewServer(stub)
+	defer gateway.Close()
+
+	cfg := testConfig(t)
+	cfg.Plans = []config.Plan{{Name: "month", Title: "Month", Days: 30, Price: 19900, Currency: "RUB"}}
+	cfg.PaymentProviders = []config.PaymentProvider{{
+		Name: "stub", Title: "Stub", Type: "hmac", BaseURL: gateway.URL, APIKey: "api-key", Secret: testProviderSecret,
+	}}
+	cfg.WebhookListen = "127.0.0.1:0"
+
+	db := newTestDB(t)
+	bot, fake := newFakeBot(t)
+	paymentService := NewPaymentService(bot, db, cfg, newTestUserService(t, db, cfg))
+
+	plan, _ := cfg.Plan("month")
+	invoice, err := paymentService.CreateInvoice(context.Background(), "stub", 42, plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(body []byte, signature string) int {
+		req := httptest.NewRequest(http.MethodPost, webhookPath+"stub", strings.NewReader(string(body)))
+		req.Header.Set("X-Signature", signature)
+		w := httptest.NewRecorder()
+		paymentService.handleWebhook(w, req)
+		return w.Code
+	}
+	paid := stub.callback(invoice.ID, plan.Price)
+
+	if code := post(paid, hex.EncodeToString(make([]byte, sha256.Size))); code != http.StatusUnauthorized {
+		t.Fatalf("invalid signature answered %d", code)
+	}
+	// A signature seen before does not sign a different callback.
+	underpaid := stub.callback(invoice.ID, 1)
+	if code := post(underpaid, signWebhook(paid)); code != http.StatusUnauthorized {
+		t.Fatalf("replayed signature answered %d", code)
+	}
+	if user, _ := db.GetUser(42); user != nil {
+		t.Fatalf("unsigned callback provisioned %+v", user)
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := post(paid, signWebhook(paid)); code != http.StatusOK {
+			t.Fatalf("callback %d answered %d", i+1, code)
+		}
+	}
+
+	payments, err := db.GetUserPayments(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 1 || payments[0].ChargeID != invoice.ID || payments[0].Amount != plan.Price {
+		t.Fatalf("unexpected payments %+v", payments)
+	}
+	if user, _ := db.GetUser(42); user == nil || user.Plan != "month" {
+		t.Fatalf("buyer not on the plan: %+v", user)
+	}
+	if sent := fake.calls("sendMessage"); len(sent) != 1 {
+		t.Fatalf("buyer notified %d times", len(sent))
+	}
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"fmt"
+	"math/rand"
+	"xray-telegram-bot/models"
+)
+
+// PlacementPolicy picks the server for a new user among the locations that
+// have room. There is always at least one candidate.
+type PlacementPolicy interface {
+	Pick(candidates []ServerLoad) *models.Server
+}
+
+// NewPlacementPolicy returns the policy configured by name.
+func NewPlacementPolicy(name string) (PlacementPolicy, error) {
+	switch name {
+	case "users":
+		return leastLoaded(func(load ServerLoad) int64 { return int64(load.Active) }), nil
+	case "traffic":
+		return leastLoaded(func(load ServerLoad) int64 { return load.Traffic }), nil
+	case "weight":
+		return weightedRandom{}, nil
+	default:
+		return nil, fmt.Errorf("unknown placement policy %q", name)
+	}
+}
+
+// leastLoaded picks the candidate with the least load per unit of weight,
+// the first of the registry on a tie.
+type leastLoaded func(load ServerLoad) int64
+
+func (metric leastLoaded) Pick(candidates []ServerLoad) *models.Server {
+	best := candidates[0]
+	for _, candidate := range candidates[1:] {
+		// Compares load/weight without dividing.
+		if metric(candidate)*serverWeight(best.Server) < metric(best)*serverWeight(candidate.Server) {
+			best = candidate
+		}
+	}
+	return best.Server
+}
+
+// weightedRandom picks a candidate at random, in proportion to its weight.
+type weightedRandom struct{}
+
+func (weightedRandom) Pick(candidates []ServerLoad) *models.Server {
+	var total int64
+	for _, candidate := range candidates {
+		total += serverWeight(candidate.Server)
+	}
+
+	n := rand.Int63n(total)
+	for _, candidate := range candidates {
+		if n -= serverWeight(candidate.Server); n < 0 {
+			return candidate.Server
+		}
+	}
+	return candidates[len(candidates)-1].Server
+}
+
+func serverWeight(server *models.Server) int64 {
+	if server.Weight < 1 {
+		return 1
+	}
+	return int64(server.Weight)
+}
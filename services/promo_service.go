@@ -0,0 +1,275 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/database"
+	"xray-telegram-bot/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// promoAlphabet leaves out characters that are easy to confuse.
+	promoAlphabet   = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	promoCodeLength = 8
+	// PromoBatchLimit bounds the number of codes created at once.
+	PromoBatchLimit = 1000
+)
+
+var (
+	ErrPromoNotFound      = errors.New("promo code not found")
+	ErrPromoExpired       = errors.New("promo code expired")
+	ErrPromoUsedUp        = errors.New("promo code is used up")
+	ErrPromoRedeemed      = errors.New("promo code was already redeemed by the user")
+	ErrPromoNotApplicable = errors.New("promo code does not apply to the user")
+)
+
+// PromoService creates batches of gift codes and applies them to users who
+// redeem them with /redeem or /start gift_<code>.
+type PromoService struct {
+	bot            *tgbotapi.BotAPI
+	db             *database.Database
+	config         *config.Config
+	userService    *UserService
+	paymentService *PaymentService
+
+	// mu serialises redemptions, so that checks and benefits of concurrent
+	// redemptions by the same user do not interleave.
+	mu sync.Mutex
+}
+
+func NewPromoService(bot *tgbotapi.BotAPI, db *database.Database, cfg *config.Config, userService *UserService, paymentService *PaymentService) *PromoService {
+	return &PromoService{
+		bot:            bot,
+		db:             db,
+		config:         cfg,
+		userService:    userService,
+		paymentService: paymentService,
+	}
+}
+
+// parsePromoBenefit splits "<kind>:<value>". Days and GB must be positive
+// numbers and plans must exist.
+func (s *PromoService) parsePromoBenefit(benefit string) (string, int, error) {
+	kind, value, _ := strings.Cut(benefit, ":")
+	switch kind {
+	case models.PromoBenefitDays, models.PromoBenefitGB:
+		amount, err := strconv.Atoi(value)
+		if err != nil || amount <= 0 {
+			return "", 0, fmt.Errorf("invalid amount in benefit %q", benefit)
+		}
+		return kind, amount, nil
+	case models.PromoBenefitPlan:
+		if _, ok := s.config.Plan(value); !ok {
+			return "", 0, fmt.Errorf("unknown plan in benefit %q", benefit)
+		}
+		return kind, 0, nil
+	default:
+		return "", 0, fmt.Errorf("unknown benefit %q", benefit)
+	}
+}
+
+func (s *PromoService) Link(code string) string {
+	return fmt.Sprintf("https://t.me/%s?start=gift_%s", s.bot.Self.UserName, code)
+}
+
+// CreateBatch generates count codes with the benefit, each redeemable by up
+// to maxUses users until expiresAt, if set.
+func (s *PromoService) CreateBatch(adminID int64, batch string, count int, benefit string, maxUses int, expiresAt *time.Time) ([]*models.PromoCode, error) {
+	if count <= 0 || count > PromoBatchLimit {
+		return nil, fmt.Errorf("batch size must be between 1 and %d", PromoBatchLimit)
+	}
+	if maxUses <= 0 {
+		return nil, fmt.Errorf("usage limit must be positive")
+	}
+	if _, _, err := s.parsePromoBenefit(benefit); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool, count)
+	codes := make([]*models.PromoCode, 0, count)
+	for len(codes) < count {
+		code, err := generatePromoCode()
+		if err != nil {
+			return nil, err
+		}
+		if seen[code] {
+			continue
+		}
+		seen[code] = true
+
+		codes = append(codes, &models.PromoCode{
+			Code:      code,
+			Batch:     batch,
+			Benefit:   benefit,
+			MaxUses:   maxUses,
+			ExpiresAt: expiresAt,
+			CreatedBy: adminID,
+			CreatedAt: now,
+		})
+	}
+
+	if err := s.db.CreatePromoCodes(codes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func generatePromoCode() (string, error) {
+	code := make([]byte, promoCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(promoAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = promoAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// Redeem applies the code to the user. The use is recorded before the
+// benefit is applied, so that a failure never grants a benefit twice, and
+// taken back when the benefit cannot be applied.
+func (s *PromoService) Redeem(userID int64, username, code string) (*models.PromoCode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	code = strings.ToUpper(strings.TrimSpace(code))
+	promo, err := s.db.GetPromoCode(code)
+	if err != nil {
+		return nil, err
+	}
+	if promo == nil {
+		return nil, ErrPromoNotFound
+	}
+
+	now := time.Now()
+	if promo.ExpiresAt != nil && !promo.ExpiresAt.After(now) {
+		return nil, ErrPromoExpired
+	}
+
+	redeemed, err := s.db.HasRedeemedPromoCode(code, userID)
+	if err != nil {
+		return nil, err
+	}
+	if redeemed {
+		return nil, ErrPromoRedeemed
+	}
+	if promo.Uses >= promo.MaxUses {
+		return nil, ErrPromoUsedUp
+	}
+
+	kind, amount, err := s.parsePromoBenefit(promo.Benefit)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.db.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if kind == models.PromoBenefitGB && (user == nil || !user.HasPlanAccess(now) || user.TrafficLimit == 0) {
+		return nil, ErrPromoNotApplicable
+	}
+
+	ok, err := s.db.RedeemPromoCode(code, userID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrPromoUsedUp
+	}
+
+	if err := s.apply(user, userID, username, promo, kind, amount); err != nil {
+		if undoErr := s.db.UnredeemPromoCode(code, userID); undoErr != nil {
+			return nil, fmt.Errorf("promo code %s redeemed but not applied: %v, and the redemption stays: %v", code, err, undoErr)
+		}
+		return nil, fmt.Errorf("failed to apply promo code %s: %v", code, err)
+	}
+
+	s.userService.recordEvent(userID, models.UserActor(userID), models.EventPromoRedeemed, code+" "+promo.Benefit, "")
+	return promo, nil
+}
+
+func (s *PromoService) apply(user *models.User, userID int64, username string, promo *models.PromoCode, kind string, amount int) error {
+	switch kind {
+	case models.PromoBenefitDays:
+		days := time.Duration(amount) * 24 * time.Hour
+		if user == nil {
+			expiresAt := time.Now().Add(days)
+			_, _, err := s.userService.createUser(&models.User{ID: userID, Username: username, ExpiresAt: &expiresAt}, "promo "+promo.Code)
+			return err
+		}
+		_, err := s.userService.AdjustExpiry(userID, models.UserActor(userID), days)
+		return err
+
+	case models.PromoBenefitGB:
+		return s.userService.AddTraffic(userID, int64(amount)<<30)
+
+	case models.PromoBenefitPlan:
+		planName := strings.TrimPrefix(promo.Benefit, models.PromoBenefitPlan+":")
+		_, _, err := s.paymentService.Complete(userID, username, models.PaymentProviderPromo, planName, 0, "",
+			fmt.Sprintf("%s:%d", promo.Code, userID), "")
+		return err
+	}
+
+	return fmt.Errorf("unknown benefit %q", promo.Benefit)
+}
+
+// ExportCSV lists the codes of a batch with their share links and the users
+// who redeemed them.
+func (s *PromoService) ExportCSV(batch string) ([]byte, error) {
+	codes, err := s.db.GetPromoBatch(batch)
+	if err != nil {
+		return nil, err
+	}
+	if len(codes) == 0 {
+		return nil, fmt.Errorf("batch %q not found", batch)
+	}
+
+	redemptions, err := s.db.GetPromoBatchRedemptions(batch)
+	if err != nil {
+		return nil, err
+	}
+	redeemedBy := make(map[string][]string)
+	for _, redemption := range redemptions {
+		redeemedBy[redemption.Code] = append(redeemedBy[redemption.Code], strconv.FormatInt(redemption.UserID, 10))
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"code", "benefit", "max_uses", "uses", "expires_at", "link", "redeemed_by"})
+	for _, promo := range codes {
+		expiresAt := ""
+		if promo.ExpiresAt != nil {
+			expiresAt = promo.ExpiresAt.Format(time.DateTime)
+		}
+		w.Write([]string{
+			promo.Code,
+			promo.Benefit,
+			strconv.Itoa(promo.MaxUses),
+			strconv.Itoa(promo.Uses),
+			expiresAt,
+			s.Link(promo.Code),
+			strings.Join(redeemedBy[promo.Code], " "),
+		})
+	}
+	w.Flush()
+
+	return buf.Bytes(), w.Error()
+}
+
+func (s *PromoService) Stats() ([]*models.PromoBatchStats, error) {
+	return s.db.GetPromoStats()
+}
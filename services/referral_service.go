@@ -0,0 +1,209 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/database"
+	"xray-telegram-bot/messages"
+	"xray-telegram-bot/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// referralChainLimit bounds the walk up the inviter chain in loop detection.
+const referralChainLimit = 100
+
+var (
+	ErrInvalidReferral  = errors.New("invalid referral code")
+	ErrSelfReferral     = errors.New("users cannot refer themselves")
+	ErrReferralLoop     = errors.New("referral would create a loop")
+	ErrAlreadyReferred  = errors.New("user was already referred")
+	ErrExistingReferral = errors.New("only new users can be referred")
+)
+
+// ReferralService records who invited whom through /start ref_<code> links
+// and rewards inviters when their invitees activate.
+type ReferralService struct {
+	bot         *tgbotapi.BotAPI
+	db          *database.Database
+	config      *config.Config
+	userService *UserService
+
+	// mu serialises activations, so that rewards granted before the
+	// referral is marked are granted once.
+	mu sync.Mutex
+}
+
+func NewReferralService(bot *tgbotapi.BotAPI, db *database.Database, cfg *config.Config, userService *UserService) *ReferralService {
+	return &ReferralService{
+		bot:         bot,
+		db:          db,
+		config:      cfg,
+		userService: userService,
+	}
+}
+
+// ReferralCode is the user's personal code, the user ID in base 36.
+func ReferralCode(userID int64) string {
+	return strconv.FormatInt(userID, 36)
+}
+
+func (s *ReferralService) Link(userID int64) string {
+	return fmt.Sprintf("https://t.me/%s?start=ref_%s", s.bot.Self.UserName, ReferralCode(userID))
+}
+
+// Register records that inviteeID followed the link with the given code.
+func (s *ReferralService) Register(inviteeID int64, code string) error {
+	inviterID, err := strconv.ParseInt(code, 36, 64)
+	if err != nil || inviterID <= 0 {
+		return ErrInvalidReferral
+	}
+	if inviterID == inviteeID {
+		return ErrSelfReferral
+	}
+
+	inviter, err := s.db.GetUser(inviterID)
+	if err != nil {
+		return err
+	}
+	if inviter == nil {
+		return ErrInvalidReferral
+	}
+
+	existing, err := s.db.GetReferral(inviteeID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrAlreadyReferred
+	}
+
+	invitee, err := s.db.GetUser(inviteeID)
+	if err != nil {
+		return err
+	}
+	if invitee != nil {
+		return ErrExistingReferral
+	}
+
+	// The invitee must not already be somewhere up the inviter's chain.
+	ancestor := inviterID
+	for i := 0; i < referralChainLimit; i++ {
+		referral, err := s.db.GetReferral(ancestor)
+		if err != nil {
+			return err
+		}
+		if referral == nil {
+			break
+		}
+		if referral.InviterID == inviteeID {
+			return ErrReferralLoop
+		}
+		ancestor = referral.InviterID
+	}
+
+	return s.db.CreateReferral(&models.Referral{
+		InviteeID: inviteeID,
+		InviterID: inviterID,
+		CreatedAt: time.Now(),
+	})
+}
+
+// Activate rewards the inviter the first time a referred user gets access
+// as a channel subscriber. Rewards are granted before the referral is marked
+// rewarded; while the inviter is not provisioned, or no reward could be
+// granted, the referral stays unrewarded and the next activation tries
+// again.
+func (s *ReferralService) Activate(inviteeID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	referral, err := s.db.GetReferral(inviteeID)
+	if err != nil {
+		log.Printf("Error loading referral of user %d: %v", inviteeID, err)
+		return
+	}
+	if referral == nil || referral.RewardedAt != nil {
+		return
+	}
+
+	inviter, err := s.db.GetUser(referral.InviterID)
+	if err != nil {
+		log.Printf("Error loading inviter %d: %v", referral.InviterID, err)
+		return
+	}
+	if inviter == nil {
+		log.Printf("Referral reward for user %d waits until inviter %d is provisioned", inviteeID, referral.InviterID)
+		return
+	}
+
+	var granted, failed []string
+	for _, reward := range s.config.ReferralRewards {
+		err := s.applyReward(referral.InviterID, reward)
+		switch {
+		case errors.Is(err, ErrNoTrafficQuota):
+			log.Printf("Referral reward %q skipped, user %d has no traffic quota", reward, referral.InviterID)
+		case err != nil:
+			log.Printf("Error granting referral reward %q to user %d: %v", reward, referral.InviterID, err)
+			failed = append(failed, reward)
+		default:
+			granted = append(granted, reward)
+		}
+	}
+	if len(granted) == 0 && len(failed) > 0 {
+		return
+	}
+
+	marked, err := s.db.MarkReferralRewarded(inviteeID, strings.Join(granted, ","))
+	if err != nil {
+		log.Printf("Error marking referral of user %d: %v", inviteeID, err)
+		return
+	}
+	if !marked {
+		return
+	}
+
+	log.Printf("User %d rewarded for inviting user %d", referral.InviterID, inviteeID)
+
+	if len(granted) == 0 {
+		return
+	}
+	names := make([]string, len(granted))
+	for i, reward := range granted {
+		names[i] = messages.FormatReward(reward)
+	}
+	msg := tgbotapi.NewMessage(referral.InviterID, fmt.Sprintf(messages.ReferralRewarded, strings.Join(names, ", ")))
+	if _, err := s.bot.Send(msg); err != nil {
+		log.Printf("Error notifying user %d about referral reward: %v", referral.InviterID, err)
+	}
+}
+
+func (s *ReferralService) applyReward(userID int64, reward string) error {
+	kind, amountText, _ := strings.Cut(reward, ":")
+	amount, err := strconv.Atoi(amountText)
+	if err != nil || amount <= 0 {
+		return fmt.Errorf("invalid reward %q", reward)
+	}
+
+	switch kind {
+	case "ips":
+		return s.userService.AddIPLimit(userID, amount)
+	case "days":
+		_, err := s.userService.AdjustExpiry(userID, models.SystemActor, time.Duration(amount)*24*time.Hour)
+		return err
+	case "gb":
+		return s.userService.AddTraffic(userID, int64(amount)<<30)
+	default:
+		return fmt.Errorf("unknown reward kind %q", kind)
+	}
+}
+
+func (s *ReferralService) Stats(inviterID int64) (*models.ReferralStats, error) {
+	return s.db.GetReferralStats(inviterID)
+}
@@ -0,0 +1,370 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"xray-telegram-bot/agent"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/database"
+	"xray-telegram-bot/marzban"
+	"xray-telegram-bot/models"
+	"xray-telegram-bot/singbox"
+	"xray-telegram-bot/xray"
+	"xray-telegram-bot/xui"
+)
+
+// trafficSampleInterval is how often inbound traffic counters are read for
+// the traffic placement policy.
+const trafficSampleInterval = 10 * time.Minute
+
+// ServerService keeps the registry of VPN servers and an Xray client per
+// server.
+type ServerService struct {
+	db     *database.Database
+	config *config.Config
+	local  *xray.Client
+
+	mu       sync.Mutex
+	clients  map[int64]*xray.Client
+	backends map[int64]Backend
+	traffic  map[int64]trafficSample
+}
+
+// trafficSample is the last inbound counter read from a server and the
+// traffic since the sample before.
+type trafficSample struct {
+	total  int64
+	recent int64
+}
+
+func NewServerService(db *database.Database, local *xray.Client, cfg *config.Config) *ServerService {
+	return &ServerService{
+		db:       db,
+		config:   cfg,
+		local:    local,
+		clients:  make(map[int64]*xray.Client),
+		backends: make(map[int64]Backend),
+		traffic:  make(map[int64]trafficSample),
+	}
+}
+
+// Init seeds the registry with the server configured in the environment on
+// first start and places users from before the registry on the first
+// server.
+func (s *ServerService) Init() error {
+	servers, err := s.db.GetServers()
+	if err != nil {
+		return err
+	}
+
+	if len(servers) == 0 {
+		server := &models.Server{
+			Name:       s.config.ServerName,
+			Flag:       s.config.ServerFlag,
+			Domain:     s.config.ServerDomain,
+			Port:       s.config.ServerPort,
+			APIAddress: s.config.XrayAPIAddress,
+			InboundTag: s.config.XrayTag,
+			ConfigPath: s.config.ConfigPath,
+			Enabled:    true,
+			CreatedAt:  time.Now(),
+			Weight:     1,
+			Backend:    s.config.ServerBackend,
+			BackendURL: s.config.ServerBackendURL,
+		}
+		if err := s.db.CreateServer(server); err != nil {
+			return err
+		}
+		log.Printf("Server registry seeded with %s (%s)", server.Name, server.Domain)
+		servers = append(servers, server)
+	}
+
+	return s.db.AssignUnplacedUsers(servers[0].ID)
+}
+
+func (s *ServerService) Servers() ([]*models.Server, error) {
+	return s.db.GetServers()
+}
+
+func (s *ServerService) Server(id int64) (*models.Server, error) {
+	return s.db.GetServer(id)
+}
+
+func (s *ServerService) ServerByName(name string) (*models.Server, error) {
+	return s.db.GetServerByName(name)
+}
+
+// Client returns the Xray client of the server, talking to its agent when
+// it has one. The server whose config file is the bot's own shares the
+// local client, so that one config file has one writer.
+func (s *ServerService) Client(server *models.Server) *xray.Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	client, ok := s.clients[server.ID]
+	if !ok {
+		switch {
+		case server.AgentURL != "":
+			client = s.local.ForServer(server).WithRemote(agent.NewClient(server.AgentURL, server.AgentSecret))
+		case server.ConfigPath != "" && server.ConfigPath == s.config.ConfigPath:
+			client = s.local
+		default:
+			client = s.local.ForServer(server)
+		}
+		s.clients[server.ID] = client
+	}
+	return client
+}
+
+// Local reports whether the server is the Xray the bot runs next to, whose
+// access log the activity service follows.
+func (s *ServerService) Local(server *models.Server) bool {
+	return s.Client(server) == s.local && (server.Backend == models.BackendXray || server.Backend == "")
+}
+
+// Backend returns what provisions users on the server: its panel, its
+// WireGuard interface, or its Xray client. A backend with an invalid URL
+// fails every call.
+func (s *ServerService) Backend(server *models.Server) Backend {
+	if server.Backend == models.BackendXray || server.Backend == "" {
+		return xrayBackend{s.Client(server)}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	backend, ok := s.backends[server.ID]
+	if !ok {
+		var err error
+		if backend, err = s.newBackend(server); err != nil {
+			return brokenBackend{fmt.Errorf("server %s: %v", server.Name, err)}
+		}
+		s.backends[server.ID] = backend
+	}
+	return backend
+}
+
+// newBackend returns the backend of a server not provisioned through Xray
+// itself.
+func (s *ServerService) newBackend(server *models.Server) (Backend, error) {
+	switch server.Backend {
+	case models.Backend3XUI:
+		return xui.NewClient(server.BackendURL, server.InboundTag)
+	case models.BackendMarzban:
+		return marzban.NewClient(server.BackendURL, server.InboundTag)
+	case models.BackendWireGuard:
+		return newWireGuardBackend(s.db, server)
+	case models.BackendSingBox:
+		return singbox.NewClient(server.BackendURL, server.Domain, server.Label(), strings.Fields(s.config.SingBoxReload))
+	default:
+		return nil, fmt.Errorf("unknown backend %q", server.Backend)
+	}
+}
+
+// Flush applies the config file changes queued by the local clients.
+func (s *ServerService) Flush() error {
+	s.mu.Lock()
+	clients := []*xray.Client{s.local}
+	for _, client := range s.clients {
+		if client != s.local {
+			clients = append(clients, client)
+		}
+	}
+	s.mu.Unlock()
+
+	var errs []error
+	for _, client := range clients {
+		if err := client.Flush(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Allowed reports whether the user may use the server. Users on a plan with
+// a server list are limited to it.
+func (s *ServerService) Allowed(user *models.User, server *models.Server) bool {
+	if user == nil || !user.HasPlanAccess(time.Now()) {
+		return true
+	}
+	plan, ok := s.config.Plan(user.Plan)
+	return !ok || len(plan.Servers) == 0 || slices.Contains(plan.Servers, server.Name)
+}
+
+// ServerLoad is a server with the number of users who picked it, how many
+// of them are active and the traffic between the last two samples.
+type ServerLoad struct {
+	Server  *models.Server
+	Users   int
+	Active  int
+	Traffic int64
+}
+
+// Locations lists the enabled servers the user may use with their load.
+func (s *ServerService) Locations(user *models.User) ([]ServerLoad, error) {
+	loads, err := s.Loads()
+	if err != nil {
+		return nil, err
+	}
+
+	var locations []ServerLoad
+	for _, load := range loads {
+		if load.Server.Enabled && s.Allowed(user, load.Server) {
+			locations = append(locations, load)
+		}
+	}
+	return locations, nil
+}
+
+// Loads lists every server with its load, for admins.
+func (s *ServerService) Loads() ([]ServerLoad, error) {
+	servers, err := s.db.GetServers()
+	if err != nil {
+		return nil, err
+	}
+	counts, err := s.db.CountServerUsers()
+	if err != nil {
+		return nil, err
+	}
+	active, err := s.db.CountActiveServerUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	loads := make([]ServerLoad, 0, len(servers))
+	for _, server := range servers {
+		loads = append(loads, ServerLoad{
+			Server:  server,
+			Users:   counts[server.ID],
+			Active:  active[server.ID],
+			Traffic: s.traffic[server.ID].recent,
+		})
+	}
+	return loads, nil
+}
+
+// StartTrafficSampler reads the inbound traffic of every enabled server
+// periodically when new users are placed by traffic.
+func (s *ServerService) StartTrafficSampler() {
+	if s.config.PlacementPolicy != "traffic" {
+		return
+	}
+
+	go func() {
+		for {
+			s.sampleTraffic()
+			time.Sleep(trafficSampleInterval)
+		}
+	}()
+}
+
+func (s *ServerService) sampleTraffic() {
+	servers, err := s.db.GetServers()
+	if err != nil {
+		log.Printf("Error querying servers: %v", err)
+		return
+	}
+
+	for _, server := range servers {
+		// Panels own their Xray, whose API the bot cannot reach.
+		if !server.Enabled || server.Backend != models.BackendXray {
+			continue
+		}
+
+		total, err := s.Client(server).InboundTraffic()
+		if err != nil {
+			log.Printf("Error reading traffic of server %s: %v", server.Name, err)
+			continue
+		}
+
+		s.mu.Lock()
+		previous, ok := s.traffic[server.ID]
+		sample := trafficSample{total: total}
+		// Counters start over when Xray restarts.
+		if ok && total >= previous.total {
+			sample.recent = total - previous.total
+		} else if ok {
+			sample.recent = total
+		}
+		s.traffic[server.ID] = sample
+		s.mu.Unlock()
+	}
+}
+
+func (s *ServerService) AddServer(server *models.Server) error {
+	existing, err := s.db.GetServerByName(server.Name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("server %s already exists", server.Name)
+	}
+
+	server.Enabled = true
+	server.CreatedAt = time.Now()
+	if server.Weight == 0 {
+		server.Weight = 1
+	}
+	if server.Backend == "" {
+		server.Backend = models.BackendXray
+	}
+	return s.db.CreateServer(server)
+}
+
+func (s *ServerService) SetEnabled(server *models.Server, enabled bool) error {
+	return s.db.UpdateServerEnabled(server.ID, enabled)
+}
+
+// SetAgent makes the server managed through the agent at agentURL, or
+// locally again when agentURL is empty.
+func (s *ServerService) SetAgent(server *models.Server, agentURL, agentSecret string) error {
+	if err := s.db.UpdateServerAgent(server.ID, agentURL, agentSecret); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.clients, server.ID)
+	s.mu.Unlock()
+	return nil
+}
+
+// SetBackend switches how users are provisioned on the server. Users
+// already placed there are not moved between backends.
+func (s *ServerService) SetBackend(server *models.Server, backend, backendURL string) error {
+	if backend == models.BackendXray {
+		backendURL = ""
+	} else {
+		candidate := *server
+		candidate.Backend, candidate.BackendURL = backend, backendURL
+		if _, err := s.newBackend(&candidate); err != nil {
+			return err
+		}
+	}
+
+	if err := s.db.UpdateServerBackend(server.ID, backend, backendURL); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	delete(s.backends, server.ID)
+	s.mu.Unlock()
+
+	server.Backend, server.BackendURL = backend, backendURL
+	return nil
+}
+
+func (s *ServerService) SetCapacity(server *models.Server, capacity int) error {
+	return s.db.UpdateServerCapacity(server.ID, capacity)
+}
+
+func (s *ServerService) SetWeight(server *models.Server, weight int) error {
+	return s.db.UpdateServerWeight(server.ID, weight)
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"xray-telegram-bot/models"
+	"xray-telegram-bot/xray"
+)
+
+func TestServerClients(t *testing.T) {
+	cfg := testConfig(t)
+	db := newTestDB(t)
+	fakeXray(t)
+
+	local := xray.NewClient(cfg)
+	servers := NewServerService(db, local, cfg)
+	if err := servers.Init(); err != nil {
+		t.Fatal(err)
+	}
+	seeded, err := servers.ServerByName(cfg.ServerName)
+	if err != nil || seeded == nil {
+		t.Fatalf("server not seeded: %v", err)
+	}
+	if servers.Client(seeded) != local {
+		t.Fatal("the seeded server got a second client of the local config file")
+	}
+
+	remote := &models.Server{Name: "remote", Domain: "remote.example.com", Port: 443, APIAddress: "203.0.113.1:10085", InboundTag: "vless-in"}
+	if err := servers.AddServer(remote); err != nil {
+		t.Fatal(err)
+	}
+	client := servers.Client(remote)
+	if client == local {
+		t.Fatal("a remote server shares the local client")
+	}
+	if err := client.Restart(); err == nil || !strings.Contains(err.Error(), "without an agent") {
+		t.Fatalf("restarting a remote server without an agent returned %v", err)
+	}
+}
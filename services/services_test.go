@@ -0,0 +1,142 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/database"
+	"xray-telegram-bot/xray"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// testConfig describes a single local Xray server.
+func testConfig(t *testing.T) *config.Config {
+	return &config.Config{
+		XrayAPIAddress:  "127.0.0.1:10085",
+		XrayTag:         "vless-in",
+		ServerName:      "test",
+		ServerDomain:    "vpn.example.com",
+		ServerPort:      443,
+		ConfigPath:      filepath.Join(t.TempDir(), "config.json"),
+		PlacementPolicy: "users",
+	}
+}
+
+func newTestDB(t *testing.T) *database.Database {
+	db, err := database.New(filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newTestUserService provisions users on the server of cfg through a fake
+// xray binary.
+func newTestUserService(t *testing.T, db *database.Database, cfg *config.Config) *UserService {
+	fakeXray(t)
+
+	xrayClient := xray.NewClient(cfg)
+	servers := NewServerService(db, xrayClient, cfg)
+	if err := servers.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return NewUserService(db, servers, cfg)
+}
+
+// fakeXray puts an xray binary on PATH that accepts every API call and
+// logs its arguments to the returned file.
+func fakeXray(t *testing.T) string {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho \"$*\" >> " + calls + "\nexit 0\n"
+	if err := os.WriteFile(filepath.Join(dir, "xray"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return calls
+}
+
+// xrayCalls returns the logged calls of the fake xray binary and starts a
+// new log.
+func xrayCalls(t *testing.T, calls string) string {
+	data, err := os.ReadFile(calls)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	os.Remove(calls)
+	return string(data)
+}
+
+// botRequest is a call the bot made to the fake Bot API.
+type botRequest struct {
+	method string
+	params map[string]string
+}
+
+// fakeBotAPI records the bot's calls and answers them successfully.
+type fakeBotAPI struct {
+	mu       sync.Mutex
+	requests []botRequest
+}
+
+// newFakeBot returns a bot talking to a fake Bot API.
+func newFakeBot(t *testing.T) (*tgbotapi.BotAPI, *fakeBotAPI) {
+	fake := &fakeBotAPI{}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint("token", server.URL+"/bot%s/%s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bot, fake
+}
+
+func (f *fakeBotAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		r.ParseForm()
+	}
+	params := make(map[string]string)
+	for key, values := range r.Form {
+		params[key] = values[0]
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, botRequest{method: method, params: params})
+	f.mu.Unlock()
+
+	var result interface{} = true
+	switch {
+	case method == "getMe":
+		result = map[string]interface{}{"id": 1, "is_bot": true, "username": "test_bot"}
+	case method == "getChatMember":
+		result = map[string]interface{}{"status": "left", "user": map[string]interface{}{"id": json.Number(params["user_id"])}}
+	case strings.HasPrefix(method, "send"):
+		chatID := json.Number(params["chat_id"])
+		result = map[string]interface{}{"message_id": len(f.requests), "chat": map[string]interface{}{"id": chatID}}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+// calls returns the recorded calls of the method.
+func (f *fakeBotAPI) calls(method string) []botRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var calls []botRequest
+	for _, request := range f.requests {
+		if request.method == method {
+			calls = append(calls, request)
+		}
+	}
+	return calls
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/database"
+	"xray-telegram-bot/messages"
+	"xray-telegram-bot/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	sharingCheckInterval = time.Minute
+	// sharingWindow is how recently an IP must have been seen to count as
+	// simultaneous.
+	sharingWindow = 5 * time.Minute
+	// sharingCooldown keeps one long session from escalating every minute.
+	sharingCooldown = time.Hour
+	// sharingMemory is how long a breach counts towards the escalation level.
+	sharingMemory = 7 * 24 * time.Hour
+)
+
+// SharingService detects users connecting from more IPs at once than they
+// are allowed and escalates according to the configured steps.
+type SharingService struct {
+	bot             *tgbotapi.BotAPI
+	db              *database.Database
+	config          *config.Config
+	userService     *UserService
+	activityService *ActivityService
+}
+
+func NewSharingService(bot *tgbotapi.BotAPI, db *database.Database, cfg *config.Config, userService *UserService,
+	activityService *ActivityService) *SharingService {
+	return &SharingService{
+		bot:             bot,
+		db:              db,
+		config:          cfg,
+		userService:     userService,
+		activityService: activityService,
+	}
+}
+
+func (s *SharingService) Start() {
+	go func() {
+		for {
+			time.Sleep(sharingCheckInterval)
+			s.checkAll()
+		}
+	}()
+}
+
+func (s *SharingService) checkAll() {
+	users, err := s.userService.GetAllUsers()
+	if err != nil {
+		log.Printf("Error querying users: %v", err)
+		return
+	}
+
+	for _, user := range users {
+		if user.Status != models.UserStatusActive {
+			continue
+		}
+
+		limit := user.IPLimit
+		if limit <= 0 {
+			limit = s.config.MaxConcurrentIPs
+		}
+
+		ipCount := len(s.concurrentIPs(user.ID))
+		if ipCount > limit {
+			s.handleBreach(user, ipCount, limit)
+		}
+	}
+}
+
+// concurrentIPs merges IPs from the access log with the StatsService view
+// of every server the user is placed on. The access log covers only the
+// bot's own Xray, so other servers are always asked for their view; the
+// bot's own only when SharingOnlineStats is enabled.
+func (s *SharingService) concurrentIPs(userID int64) map[string]bool {
+	ips := make(map[string]bool)
+	for _, ip := range s.activityService.RecentIPs(userID, sharingWindow) {
+		ips[ip] = true
+	}
+
+	servers, err := s.userService.userServers(userID)
+	if err != nil {
+		log.Printf("Error querying servers of user %d: %v", userID, err)
+	}
+	for _, server := range servers {
+		if s.userService.servers.Local(server) && !s.config.SharingOnlineStats {
+			continue
+		}
+		lister, ok := s.userService.servers.Backend(server).(OnlineIPLister)
+		if !ok {
+			continue
+		}
+		online, err := lister.OnlineIPs(userEmail(userID))
+		if err != nil {
+			log.Printf("Error querying online IPs of user %d on %s: %v", userID, server.Name, err)
+		}
+		for _, ip := range online {
+			ips[ip] = true
+		}
+	}
+
+	return ips
+}
+
+func (s *SharingService) handleBreach(user *models.User, ipCount, limit int) {
+	now := time.Now()
+
+	breaches, err := s.db.GetSharingBreaches(user.ID, now.Add(-sharingMemory))
+	if err != nil {
+		log.Printf("Error querying sharing breaches of user %d: %v", user.ID, err)
+		return
+	}
+	if n := len(breaches); n > 0 && now.Sub(breaches[n-1].CreatedAt) < sharingCooldown {
+		return
+	}
+
+	steps := s.config.SharingEscalation
+	if len(steps) == 0 {
+		return
+	}
+	level := len(breaches)
+	if level >= len(steps) {
+		level = len(steps) - 1
+	}
+	action := steps[level]
+
+	log.Printf("User %d uses %d IPs (limit %d), escalation step %q", user.ID, ipCount, limit, action)
+
+	reason := fmt.Sprintf("%d simultaneous IPs, limit %d", ipCount, limit)
+	var text, link string
+
+	switch action {
+	case models.SharingActionWarn:
+		text = fmt.Sprintf(messages.SharingWarning, ipCount, limit)
+
+	case models.SharingActionRotate:
+		userUUID, vlessURL, err := s.userService.RotateUUID(user.ID, models.SystemActor, reason)
+		if err != nil {
+			log.Printf("Error rotating UUID of user %d: %v", user.ID, err)
+			return
+		}
+		text = fmt.Sprintf(messages.SharingRotated, ipCount, limit, userUUID, vlessURL)
+		link = vlessURL
+
+	case models.SharingActionSuspend:
+		if err := s.userService.SuspendUser(user.ID, models.SystemActor, reason); err != nil {
+			log.Printf("Error suspending user %d: %v", user.ID, err)
+			return
+		}
+		text = fmt.Sprintf(messages.SharingSuspended, ipCount, limit)
+
+	default:
+		log.Printf("Unknown sharing escalation step %q", action)
+		return
+	}
+
+	if err := s.db.CreateSharingBreach(&models.SharingBreach{
+		UserID:    user.ID,
+		IPCount:   ipCount,
+		Action:    action,
+		CreatedAt: now,
+	}); err != nil {
+		log.Printf("Error recording sharing breach of user %d: %v", user.ID, err)
+	}
+
+	msg := tgbotapi.NewMessage(user.ID, text)
+	msg.ParseMode = "Markdown"
+	if _, err := s.bot.Send(msg); err != nil {
+		log.Printf("Error notifying user %d about sharing: %v", user.ID, err)
+		return
+	}
+
+	if link != "" {
+		photo, err := qrPhoto(user.ID, link, messages.QRConfig)
+		if err == nil {
+			_, err = s.bot.Send(photo)
+		}
+		if err != nil {
+			log.Printf("Error sending QR code to user %d: %v", user.ID, err)
+		}
+	}
+}
+
+// Offenders returns the users with the most breaches over the last month.
+func (s *SharingService) Offenders(limit int) ([]*models.SharingOffender, error) {
+	return s.db.GetSharingOffenders(time.Now().AddDate(0, -1, 0), limit)
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"xray-telegram-bot/models"
+)
+
+func TestConcurrentIPsCoverEveryServer(t *testing.T) {
+	cfg := testConfig(t)
+	db := newTestDB(t)
+	users := newTestUserService(t, db, cfg)
+	if _, _, err := users.GetOrCreateVlessConfig(1, ""); err != nil {
+		t.Fatal(err)
+	}
+	remote := &models.Server{Name: "remote", Domain: "remote.example.com", Port: 443, APIAddress: "203.0.113.1:10085", InboundTag: "vless-in"}
+	if err := users.servers.AddServer(remote); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddUserServer(1, remote.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The local Xray sees one IP, the remote one two others.
+	dir := t.TempDir()
+	script := `#!/bin/sh
+case "$2 $3" in
+"statsonlineiplist --server=127.0.0.1:10085") echo '{"ips":{"198.51.100.1":1}}' ;;
+statsonlineiplist*) echo '{"ips":{"203.0.113.7":1,"203.0.113.8":1}}' ;;
+esac
+`
+	if err := os.WriteFile(filepath.Join(dir, "xray"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	sharing := NewSharingService(nil, db, cfg, users, NewActivityService(db, users.servers.local, cfg))
+	if ips := sharing.concurrentIPs(1); len(ips) != 2 || !ips["203.0.113.7"] {
+		t.Fatalf("remote server not asked without online stats: %v", ips)
+	}
+	cfg.SharingOnlineStats = true
+	if ips := sharing.concurrentIPs(1); len(ips) != 3 || !ips["198.51.100.1"] {
+		t.Fatalf("IPs not merged over the servers: %v", ips)
+	}
+}
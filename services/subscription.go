@@ -0,0 +1,139 @@
+package services
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"xray-telegram-bot/models"
+	"xray-telegram-bot/xray"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// subscriptionPath is where clients fetch subscriptions, followed by the
+// user's UUID.
+const subscriptionPath = "/sub/"
+
+// subscriptionQRSuffix turns a subscription URL into the URL of its QR code.
+const subscriptionQRSuffix = "/qr"
+
+// SubscriptionURL returns the user's subscription link, or an empty string
+// when the HTTP server is not public.
+func (s *UserService) SubscriptionURL(user *models.User) string {
+	if s.config.WebhookListen == "" || s.config.WebhookPublicURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(s.config.WebhookPublicURL, "/") + subscriptionPath + user.UUID
+}
+
+// SubscriptionLinks returns a VLESS link per server the user may use, or
+// the links of panels that build their own, their location first. Servers
+// the user is being migrated away from are left out, so that clients
+// switch over on their next update. Unreachable servers are left out as
+// well while any other server is reachable, and go last otherwise.
+func (s *UserService) SubscriptionLinks(user *models.User) ([]string, error) {
+	servers, err := s.userServers(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	draining, err := s.db.GetDrainingServers(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var reachable, unreachable []*models.Server
+	for _, server := range servers {
+		switch {
+		case draining[server.ID]:
+		case !server.Reachable():
+			unreachable = append(unreachable, server)
+		case server.ID == user.ServerID:
+			reachable = append([]*models.Server{server}, reachable...)
+		default:
+			reachable = append(reachable, server)
+		}
+	}
+	if len(reachable) == 0 {
+		reachable = unreachable
+	}
+
+	links := make([]string, 0, len(reachable))
+	for _, server := range reachable {
+		backend := s.servers.Backend(server)
+		// WireGuard peers are imported from a file, there is no link.
+		if _, ok := backend.(ConfigFileProvider); ok {
+			continue
+		}
+		if provider, ok := backend.(LinkProvider); ok {
+			panelLinks, err := provider.Links(userEmail(user.ID))
+			if err == nil {
+				links = append(links, panelLinks...)
+				continue
+			}
+			log.Printf("Error getting links of user %d from %s: %v", user.ID, server.Name, err)
+		}
+		links = append(links, xray.VlessURL(server.Domain, server.Port, user.UUID, url.PathEscape(server.Label())))
+	}
+	return links, nil
+}
+
+// handleSubscription serves the base64 link list clients expect from a
+// subscription URL, and under the URL followed by /qr a PNG QR code to scan
+// it from another device.
+func (s *UserService) handleSubscription(w http.ResponseWriter, r *http.Request) {
+	userUUID, qr := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, subscriptionPath), subscriptionQRSuffix)
+	if userUUID == "" || strings.Contains(userUUID, "/") || r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+
+	user, err := s.db.GetUserByUUID(userUUID)
+	if err != nil {
+		log.Printf("Error loading subscription %s: %v", userUUID, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if user == nil || user.Status != models.UserStatusActive {
+		http.NotFound(w, r)
+		return
+	}
+	if qr {
+		s.serveSubscriptionQR(w, user)
+		return
+	}
+
+	links, err := s.SubscriptionLinks(user)
+	if err != nil {
+		log.Printf("Error building subscription of user %d: %v", user.ID, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	userInfo := fmt.Sprintf("upload=0; download=%d; total=%d", user.TrafficUsed, user.TrafficLimit)
+	if user.ExpiresAt != nil {
+		userInfo += fmt.Sprintf("; expire=%d", user.ExpiresAt.Unix())
+	}
+	w.Header().Set("Subscription-Userinfo", userInfo)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n")))))
+}
+
+// serveSubscriptionQR writes the QR code of the user's subscription URL,
+// or of their config link when the subscription is not public.
+func (s *UserService) serveSubscriptionQR(w http.ResponseWriter, user *models.User) {
+	content := s.SubscriptionURL(user)
+	if content == "" {
+		content = s.ConfigLink(user)
+	}
+	png, err := qrcode.Encode(content, qrcode.Medium, qrSize)
+	if err != nil {
+		log.Printf("Error encoding QR code of user %d: %v", user.ID, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
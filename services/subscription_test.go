@@ -0,0 +1,44 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSubscription(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.WebhookListen = "127.0.0.1:0"
+	cfg.WebhookPublicURL = "https://bot.example.com"
+	s := newTestUserService(t, newTestDB(t), cfg)
+	userUUID, _, err := s.GetOrCreateVlessConfig(1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handleSubscription(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	w := get(subscriptionPath + userUUID)
+	links, err := base64.StdEncoding.DecodeString(w.Body.String())
+	if w.Code != http.StatusOK || err != nil || !strings.HasPrefix(string(links), "vless://"+userUUID+"@") {
+		t.Fatalf("subscription answered %d: %q", w.Code, links)
+	}
+
+	w = get(subscriptionPath + userUUID + subscriptionQRSuffix)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" || !bytes.HasPrefix(w.Body.Bytes(), []byte("\x89PNG")) {
+		t.Fatalf("QR code answered %d with %s", w.Code, w.Header().Get("Content-Type"))
+	}
+
+	for _, path := range []string{subscriptionPath + "unknown" + subscriptionQRSuffix, subscriptionPath + userUUID + "/other"} {
+		if w := get(path); w.Code != http.StatusNotFound {
+			t.Fatalf("%s answered %d", path, w.Code)
+		}
+	}
+}
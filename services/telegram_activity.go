@@ -0,0 +1,65 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"xray-telegram-bot/messages"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func (s *TelegramService) handleUsageCommand(chatID, userID int64) {
+	activity, err := s.activityService.GetActivity(userID)
+	if err != nil {
+		log.Printf("Error loading activity of user %d: %v", userID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ActivityError))
+		return
+	}
+
+	s.bot.Send(tgbotapi.NewMessage(chatID, messages.FormatActivity(activity)))
+}
+
+// handleUserCommand shows an admin everything known about one user.
+func (s *TelegramService) handleUserCommand(chatID int64, args string) {
+	userID, err := strconv.ParseInt(strings.TrimSpace(args), 10, 64)
+	if err != nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.UserDetailsUsage))
+		return
+	}
+
+	user, err := s.userService.GetUser(userID)
+	if err != nil {
+		log.Printf("Error loading user %d: %v", userID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ActivityError))
+		return
+	}
+
+	ban, err := s.userService.GetBan(userID)
+	if err != nil {
+		log.Printf("Error loading ban of user %d: %v", userID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ActivityError))
+		return
+	}
+
+	activity, err := s.activityService.GetActivity(userID)
+	if err != nil {
+		log.Printf("Error loading activity of user %d: %v", userID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ActivityError))
+		return
+	}
+
+	var sections []string
+	if user == nil {
+		sections = append(sections, fmt.Sprintf(messages.UserDetailsMissing, userID))
+	} else {
+		sections = append(sections, messages.FormatUserDetails(user, s.userService.RoutingProfile(user).Title))
+	}
+	if ban != nil {
+		sections = append(sections, messages.FormatBan(ban))
+	}
+	sections = append(sections, messages.FormatActivity(activity))
+
+	s.bot.Send(tgbotapi.NewMessage(chatID, strings.Join(sections, "\n\n")))
+}
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"xray-telegram-bot/messages"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// parseDuration accepts Go durations as well as day and week suffixes,
+// e.g. "12h", "7d" or "2w".
+func parseDuration(value string) (time.Duration, bool) {
+	if n := len(value); n > 1 {
+		count, err := strconv.Atoi(value[:n-1])
+		if err == nil && count > 0 {
+			switch value[n-1] {
+			case 'd':
+				return time.Duration(count) * 24 * time.Hour, true
+			case 'w':
+				return time.Duration(count) * 7 * 24 * time.Hour, true
+			}
+		}
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, false
+	}
+	return duration, true
+}
+
+// handleBanCommand parses "/ban <user_id> [duration] <reason>".
+func (s *TelegramService) handleBanCommand(chatID, adminID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.BanUsage))
+		return
+	}
+
+	userID, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.BanUsage))
+		return
+	}
+
+	// A duration needs a reason after it.
+	var expiresAt *time.Time
+	if duration, ok := parseDuration(fields[1]); ok {
+		if len(fields) < 3 {
+			s.bot.Send(tgbotapi.NewMessage(chatID, messages.BanUsage))
+			return
+		}
+		expiry := time.Now().Add(duration)
+		expiresAt = &expiry
+		fields = fields[1:]
+	}
+	reason := strings.Join(fields[1:], " ")
+
+	if err := s.userService.BanUser(adminID, userID, reason, expiresAt); err != nil {
+		log.Printf("Error banning user %d: %v", userID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.BanError))
+		return
+	}
+
+	log.Printf("User %d banned by %d: %s", userID, adminID, reason)
+	s.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.BanDone, userID, messages.FormatExpiry(expiresAt))))
+}
+
+func (s *TelegramService) handleUnbanCommand(chatID, adminID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) < 1 {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.UnbanUsage))
+		return
+	}
+
+	userID, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.UnbanUsage))
+		return
+	}
+
+	if err := s.userService.UnbanUser(adminID, userID, strings.Join(fields[1:], " ")); err != nil {
+		log.Printf("Error unbanning user %d: %v", userID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.BanError))
+		return
+	}
+
+	log.Printf("User %d unbanned by %d", userID, adminID)
+	s.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.UnbanDone, userID)))
+}
+
+// handleBansCommand lists active bans, or the ban history of one user when
+// an ID is given.
+func (s *TelegramService) handleBansCommand(chatID int64, args string) {
+	var lines []string
+
+	if userID, err := strconv.ParseInt(strings.TrimSpace(args), 10, 64); err == nil {
+		records, err := s.userService.GetBanHistory(userID)
+		if err != nil {
+			log.Printf("Error querying ban history of user %d: %v", userID, err)
+			s.bot.Send(tgbotapi.NewMessage(chatID, messages.BanError))
+			return
+		}
+		for _, record := range records {
+			lines = append(lines, messages.FormatBanRecord(record))
+		}
+	} else {
+		bans, err := s.userService.GetBans()
+		if err != nil {
+			log.Printf("Error querying bans: %v", err)
+			s.bot.Send(tgbotapi.NewMessage(chatID, messages.BanError))
+			return
+		}
+		for _, ban := range bans {
+			lines = append(lines, messages.FormatBan(ban))
+		}
+	}
+
+	if len(lines) == 0 {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.BansEmpty))
+		return
+	}
+
+	s.bot.Send(tgbotapi.NewMessage(chatID, strings.Join(lines, "\n")))
+}
+
+func (s *TelegramService) StartBanExpiryChecker() {
+	go func() {
+		for {
+			time.Sleep(10 * time.Minute)
+			s.userService.LiftExpiredBans()
+		}
+	}()
+}
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"xray-telegram-bot/messages"
+	"xray-telegram-bot/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func (s *TelegramService) handleBroadcastCommand(chatID, adminID int64, text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.BroadcastUsage))
+		return
+	}
+
+	broadcast, err := s.broadcastService.CreateDraft(adminID, text)
+	if err != nil {
+		log.Printf("Error creating broadcast draft: %v", err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.BroadcastError))
+		return
+	}
+
+	previewText, markup, err := s.broadcastPreview(broadcast)
+	if err != nil {
+		log.Printf("Error rendering broadcast preview: %v", err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.BroadcastError))
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, previewText)
+	msg.ReplyMarkup = markup
+	s.bot.Send(msg)
+}
+
+// handleBroadcastCallback processes "bc:<action>:<id>[:<segment>]" buttons.
+func (s *TelegramService) handleBroadcastCallback(query *tgbotapi.CallbackQuery, args []string) {
+	if len(args) < 2 || query.Message == nil {
+		s.bot.Request(tgbotapi.NewCallback(query.ID, ""))
+		return
+	}
+
+	id, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		s.bot.Request(tgbotapi.NewCallback(query.ID, ""))
+		return
+	}
+
+	chatID := query.Message.Chat.ID
+	messageID := query.Message.MessageID
+
+	switch args[0] {
+	case "seg":
+		if len(args) < 3 {
+			break
+		}
+		if err := s.broadcastService.SetSegment(id, strings.Join(args[2:], ":")); err != nil {
+			log.Printf("Error updating broadcast %d segment: %v", id, err)
+			break
+		}
+
+		broadcast, err := s.broadcastService.Get(id)
+		if err != nil || broadcast == nil {
+			log.Printf("Error loading broadcast %d: %v", id, err)
+			break
+		}
+
+		previewText, markup, err := s.broadcastPreview(broadcast)
+		if err != nil {
+			log.Printf("Error rendering broadcast preview: %v", err)
+			break
+		}
+		s.bot.Send(tgbotapi.NewEditMessageTextAndMarkup(chatID, messageID, previewText, markup))
+
+	case "send":
+		if err := s.broadcastService.Start(id, messageID); err != nil {
+			log.Printf("Error starting broadcast %d: %v", id, err)
+			s.bot.Request(tgbotapi.NewCallback(query.ID, messages.BroadcastError))
+			return
+		}
+		s.bot.Send(tgbotapi.NewEditMessageText(chatID, messageID, fmt.Sprintf(messages.BroadcastStarted, id)))
+
+	case "cancel":
+		if err := s.broadcastService.Cancel(id); err != nil {
+			log.Printf("Error cancelling broadcast %d: %v", id, err)
+			s.bot.Request(tgbotapi.NewCallback(query.ID, messages.BroadcastError))
+			return
+		}
+		s.bot.Send(tgbotapi.NewEditMessageText(chatID, messageID, fmt.Sprintf(messages.BroadcastCancelled, id)))
+	}
+
+	s.bot.Request(tgbotapi.NewCallback(query.ID, ""))
+}
+
+func (s *TelegramService) broadcastPreview(broadcast *models.Broadcast) (string, tgbotapi.InlineKeyboardMarkup, error) {
+	recipients, err := s.broadcastService.Recipients(broadcast.Segment)
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+
+	languages, err := s.broadcastService.Languages()
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+
+	segmentButton := func(segment, label string) tgbotapi.InlineKeyboardButton {
+		if segment == broadcast.Segment {
+			label = "• " + label
+		}
+		return tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("bc:seg:%d:%s", broadcast.ID, segment))
+	}
+
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(
+			segmentButton(string(models.SegmentAll), messages.FormatSegment(string(models.SegmentAll))),
+			segmentButton(models.UserStatusActive, messages.FormatSegment(models.UserStatusActive)),
+			segmentButton(models.UserStatusSuspended, messages.FormatSegment(models.UserStatusSuspended)),
+		),
+	}
+
+	var languageRow []tgbotapi.InlineKeyboardButton
+	for _, language := range languages {
+		languageRow = append(languageRow, segmentButton("lang:"+language, messages.FormatSegment("lang:"+language)))
+		if len(languageRow) == 4 {
+			rows = append(rows, languageRow)
+			languageRow = nil
+		}
+	}
+	if len(languageRow) > 0 {
+		rows = append(rows, languageRow)
+	}
+
+	servers, err := s.serverService.Servers()
+	if err != nil {
+		return "", tgbotapi.InlineKeyboardMarkup{}, err
+	}
+	if len(servers) > 1 {
+		var serverRow []tgbotapi.InlineKeyboardButton
+		for _, server := range servers {
+			serverRow = append(serverRow, segmentButton(fmt.Sprintf("server:%d", server.ID), server.Label()))
+			if len(serverRow) == 3 {
+				rows = append(rows, serverRow)
+				serverRow = nil
+			}
+		}
+		if len(serverRow) > 0 {
+			rows = append(rows, serverRow)
+		}
+	}
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(messages.BroadcastSendButton, fmt.Sprintf("bc:send:%d", broadcast.ID)),
+		tgbotapi.NewInlineKeyboardButtonData(messages.BroadcastCancelButton, fmt.Sprintf("bc:cancel:%d", broadcast.ID)),
+	))
+
+	text := fmt.Sprintf(messages.BroadcastPreview, broadcast.ID, messages.FormatSegment(broadcast.Segment), len(recipients), broadcast.Text)
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...), nil
+}
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"xray-telegram-bot/messages"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// eventsLimit bounds the timeline so it fits into one Telegram message.
+const eventsLimit = 30
+
+func (s *TelegramService) handleEventsCommand(chatID int64, args string) {
+	userID, err := strconv.ParseInt(strings.TrimSpace(args), 10, 64)
+	if err != nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.EventsUsage))
+		return
+	}
+
+	events, err := s.userService.GetUserEvents(userID, eventsLimit)
+	if err != nil {
+		log.Printf("Error querying events of user %d: %v", userID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.EventsError))
+		return
+	}
+
+	if len(events) == 0 {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.EventsEmpty))
+		return
+	}
+
+	lines := make([]string, 0, len(events))
+	for _, event := range events {
+		lines = append(lines, messages.FormatEvent(event))
+	}
+
+	s.bot.Send(tgbotapi.NewMessage(chatID, strings.Join(lines, "\n")))
+}
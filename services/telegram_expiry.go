@@ -0,0 +1,200 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/messages"
+	"xray-telegram-bot/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// expiryReminderDays are the days before the access end at which users are
+// reminded to renew, in ascending order.
+var expiryReminderDays = []int{1, 3}
+
+func (s *TelegramService) StartExpiryChecker() {
+	go func() {
+		for {
+			s.checkExpiries()
+			time.Sleep(10 * time.Minute)
+		}
+	}()
+}
+
+// checkExpiries ends access that ran out or used up its traffic quota and
+// sends renewal reminders.
+func (s *TelegramService) checkExpiries() {
+	users, err := s.userService.GetAllUsers()
+	if err != nil {
+		log.Printf("Error querying users: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, user := range users {
+		if user.ExpiresAt == nil || user.Status != models.UserStatusActive {
+			continue
+		}
+
+		if user.ExpiresAt.After(now) {
+			if !s.checkQuota(user) {
+				s.remindExpiry(user, user.ExpiresAt.Sub(now))
+			}
+		} else {
+			s.expire(user)
+		}
+	}
+}
+
+// expire ends the time-limited access of a user. Channel members keep their
+// access and only lose the plan.
+func (s *TelegramService) expire(user *models.User) {
+	isSubscribed, err := s.checkSubscription(user.ID)
+	if err != nil {
+		log.Printf("Error checking subscription for user %d: %v", user.ID, err)
+		return
+	}
+
+	if isSubscribed {
+		if err := s.userService.EndPlan(user.ID, "expired, channel member"); err != nil {
+			log.Printf("Error ending plan of user %d: %v", user.ID, err)
+			return
+		}
+		log.Printf("Plan of user %d ended, access kept through the channel", user.ID)
+		s.notify(user.ID, tgbotapi.NewMessage(user.ID, fmt.Sprintf(messages.PlanEndedMessage, s.config.ChannelUsername)))
+		return
+	}
+
+	if err := s.userService.ExpireUser(user.ID, "access ended "+user.ExpiresAt.Format(time.DateTime)); err != nil {
+		log.Printf("Error expiring user %d: %v", user.ID, err)
+		return
+	}
+	log.Printf("Access of user %d expired", user.ID)
+	s.sendExpiredMessage(user.ID, user)
+}
+
+// checkQuota updates the traffic the user used of the plan's quota and
+// reports whether it took their access for using it up. Channel members
+// keep their access, as they do when the plan ends.
+func (s *TelegramService) checkQuota(user *models.User) bool {
+	if user.TrafficLimit == 0 {
+		return false
+	}
+	if err := s.userService.UpdateTrafficUsed(user); err != nil {
+		log.Printf("Error updating traffic of user %d: %v", user.ID, err)
+		return false
+	}
+	if !user.QuotaExhausted() {
+		return false
+	}
+
+	isSubscribed, err := s.checkSubscription(user.ID)
+	if err != nil {
+		log.Printf("Error checking subscription for user %d: %v", user.ID, err)
+		return false
+	}
+	if isSubscribed {
+		return false
+	}
+
+	if err := s.userService.SuspendForQuota(user); err != nil {
+		log.Printf("Error suspending user %d over quota: %v", user.ID, err)
+		return false
+	}
+	log.Printf("User %d used up the traffic quota", user.ID)
+	s.sendQuotaExhaustedMessage(user.ID, user)
+	return true
+}
+
+func (s *TelegramService) remindExpiry(user *models.User, remaining time.Duration) {
+	for _, days := range expiryReminderDays {
+		if remaining > time.Duration(days)*24*time.Hour {
+			continue
+		}
+		if user.ExpiryReminder != 0 && user.ExpiryReminder <= days {
+			return
+		}
+
+		msg := tgbotapi.NewMessage(user.ID, fmt.Sprintf(messages.ExpiryReminder,
+			user.ExpiresAt.Format(messages.TimeLayout), messages.FormatDuration(remaining.Round(time.Minute))))
+		msg.ReplyMarkup = s.renewKeyboard(user)
+		s.notify(user.ID, msg)
+
+		// Marked even when delivery failed, so that users who blocked the
+		// bot are not retried every round.
+		if err := s.userService.SetExpiryReminder(user.ID, days); err != nil {
+			log.Printf("Error recording expiry reminder of user %d: %v", user.ID, err)
+		}
+		return
+	}
+}
+
+func (s *TelegramService) sendExpiredMessage(chatID int64, user *models.User) {
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.ExpiredMessage, s.config.ChannelUsername))
+	if len(s.config.Plans) > 0 {
+		msg.ReplyMarkup = s.renewKeyboard(user)
+	}
+	s.notify(chatID, msg)
+}
+
+func (s *TelegramService) sendQuotaExhaustedMessage(chatID int64, user *models.User) {
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.QuotaExhaustedMessage, s.config.ChannelUsername))
+	if len(s.config.Plans) > 0 {
+		msg.ReplyMarkup = s.renewKeyboard(user)
+	}
+	s.notify(chatID, msg)
+}
+
+// renewKeyboard offers the user's current plan, or every plan when it is no
+// longer sold.
+func (s *TelegramService) renewKeyboard(user *models.User) tgbotapi.InlineKeyboardMarkup {
+	if plan, ok := s.config.Plan(user.Plan); ok {
+		return s.planKeyboard([]config.Plan{plan})
+	}
+	return s.planKeyboard(s.config.Plans)
+}
+
+func (s *TelegramService) notify(userID int64, msg tgbotapi.MessageConfig) {
+	if _, err := s.bot.Send(msg); err != nil {
+		log.Printf("Error notifying user %d: %v", userID, err)
+	}
+}
+
+// handleExpiryCommand parses "/extend <user_id> <duration>" and
+// "/shorten <user_id> <duration>"; sign is 1 or -1 respectively.
+func (s *TelegramService) handleExpiryCommand(chatID, adminID int64, args string, sign int, usage string) {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		s.bot.Send(tgbotapi.NewMessage(chatID, usage))
+		return
+	}
+
+	userID, err := strconv.ParseInt(fields[0], 10, 64)
+	duration, ok := parseDuration(fields[1])
+	if err != nil || !ok {
+		s.bot.Send(tgbotapi.NewMessage(chatID, usage))
+		return
+	}
+
+	expiresAt, err := s.userService.AdjustExpiry(userID, models.AdminActor(adminID), time.Duration(sign)*duration)
+	if err != nil {
+		log.Printf("Error changing expiry of user %d: %v", userID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ExpiryChangeError))
+		return
+	}
+
+	if !expiresAt.After(time.Now()) {
+		if user, err := s.userService.GetUser(userID); err != nil {
+			log.Printf("Error loading user %d: %v", userID, err)
+		} else if user != nil && user.Status == models.UserStatusActive {
+			s.expire(user)
+		}
+	}
+
+	s.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.ExpiryChanged, userID, expiresAt.Format(messages.TimeLayout))))
+}
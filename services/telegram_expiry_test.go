@@ -0,0 +1,81 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"xray-telegram-bot/messages"
+	"xray-telegram-bot/models"
+)
+
+// fakeTrafficXray puts an xray binary on PATH whose user counters report
+// the bytes passed to the returned function.
+func fakeTrafficXray(t *testing.T) func(bytes int64) {
+	dir := t.TempDir()
+	stats := filepath.Join(dir, "stats")
+	script := "#!/bin/sh\ncase \"$2\" in statsquery) cat " + stats + " ;; esac\nexit 0\n"
+	if err := os.WriteFile(filepath.Join(dir, "xray"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	return func(bytes int64) {
+		counter := fmt.Sprintf(`{"stat":[{"name":"user>>>user_42@myserver>>>traffic>>>downlink","value":"%d"}]}`, bytes)
+		if err := os.WriteFile(stats, []byte(counter), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTrafficQuota(t *testing.T) {
+	s, fake := newTestPaymentBot(t)
+	setCounter := fakeTrafficXray(t)
+	if _, _, err := s.paymentService.Complete(42, "buyer", models.PaymentProviderStars, "month", 250, starsCurrency, "charge-1", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(counter int64) (*models.User, bool) {
+		setCounter(counter)
+		user, err := s.userService.GetUser(42)
+		if err != nil {
+			t.Fatal(err)
+		}
+		suspended := s.checkQuota(user)
+		if user, err = s.userService.GetUser(42); err != nil {
+			t.Fatal(err)
+		}
+		return user, suspended
+	}
+
+	if user, suspended := check(60 << 30); suspended || user.TrafficUsed != 60<<30 {
+		t.Fatalf("user with 60 of 100 GB used suspended: %v, %+v", suspended, user)
+	}
+	// Xray restarted and counts from zero again.
+	if user, suspended := check(10 << 30); suspended || user.TrafficUsed != 70<<30 {
+		t.Fatalf("counter reset not added up: %v, %+v", suspended, user)
+	}
+	user, suspended := check(40 << 30)
+	if !suspended || user.Status != models.UserStatusExpired {
+		t.Fatalf("user over quota not suspended: %+v", user)
+	}
+	sent := fake.calls("sendMessage")
+	if len(sent) != 1 || sent[0].params["text"] != fmt.Sprintf(messages.QuotaExhaustedMessage, s.config.ChannelUsername) {
+		t.Fatalf("unexpected messages %v", sent)
+	}
+
+	if err := s.userService.AddTraffic(42, 10<<30); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ = s.userService.GetUser(42); user.Status != models.UserStatusActive {
+		t.Fatalf("user with traffic added not restored: %+v", user)
+	}
+
+	// Paying again starts a fresh quota.
+	if _, _, err := s.paymentService.Complete(42, "buyer", models.PaymentProviderStars, "month", 250, starsCurrency, "charge-2", ""); err != nil {
+		t.Fatal(err)
+	}
+	if user, _ = s.userService.GetUser(42); user.TrafficUsed != 0 || user.TrafficLimit != 100<<30 {
+		t.Fatalf("quota not renewed: %+v", user)
+	}
+}
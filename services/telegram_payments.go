@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/messages"
+	"xray-telegram-bot/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func (s *TelegramService) handleBuyCommand(chatID, userID int64) {
+	if len(s.config.Plans) == 0 {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.PlansEmpty))
+		return
+	}
+
+	text := messages.PlansHeader
+	if user, err := s.userService.GetUser(userID); err != nil {
+		log.Printf("Error loading user %d: %v", userID, err)
+	} else if user != nil && user.HasPlanAccess(time.Now()) {
+		text = messages.FormatAccessStatus(user) + "\n\n" + text
+	}
+
+	for _, plan := range s.config.Plans {
+		text += "\n• " + plan.Title + ": " + messages.FormatPlan(plan)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = s.planKeyboard(s.config.Plans)
+	s.bot.Send(msg)
+}
+
+// planKeyboard has a row of payment buttons per plan, one for Stars and one
+// per external provider.
+func (s *TelegramService) planKeyboard(plans []config.Plan) tgbotapi.InlineKeyboardMarkup {
+	providers := s.paymentService.Providers()
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, plan := range plans {
+		var row []tgbotapi.InlineKeyboardButton
+		if plan.Stars > 0 {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf(messages.PlanButton, plan.Title, plan.Stars), "buy:"+plan.Name))
+		}
+		if plan.Price > 0 {
+			for _, provider := range providers {
+				row = append(row, tgbotapi.NewInlineKeyboardButtonData(
+					fmt.Sprintf(messages.PlanProviderButton, provider.Title(), messages.FormatPrice(plan.Price, plan.Currency)),
+					"pay:"+provider.Name()+":"+plan.Name))
+			}
+		}
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+	}
+
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func (s *TelegramService) handleBuyCallback(query *tgbotapi.CallbackQuery, planName string) {
+	s.bot.Request(tgbotapi.NewCallback(query.ID, ""))
+
+	plan, ok := s.config.Plan(planName)
+	if !ok || query.Message == nil {
+		return
+	}
+
+	if err := s.paymentService.SendStarsInvoice(query.Message.Chat.ID, query.From.ID, plan); err != nil {
+		log.Printf("Error sending invoice to user %d: %v", query.From.ID, err)
+		s.bot.Send(tgbotapi.NewMessage(query.Message.Chat.ID, messages.PaymentUnavailable))
+	}
+}
+
+// handlePayCallback sends a payment link of an external provider for the
+// plan. Data is "<provider>:<plan>".
+func (s *TelegramService) handlePayCallback(query *tgbotapi.CallbackQuery, data string) {
+	s.bot.Request(tgbotapi.NewCallback(query.ID, ""))
+
+	providerName, planName, _ := strings.Cut(data, ":")
+	plan, ok := s.config.Plan(planName)
+	if !ok || query.Message == nil {
+		return
+	}
+	chatID := query.Message.Chat.ID
+
+	if ban, err := s.userService.GetBan(query.From.ID); err != nil || ban != nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.PaymentUnavailable))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	invoice, err := s.paymentService.CreateInvoice(ctx, providerName, query.From.ID, plan)
+	if err != nil {
+		log.Printf("Error creating %s invoice for user %d: %v", providerName, query.From.ID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.PaymentUnavailable))
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.PaymentLinkMessage, plan.Title, messages.FormatPrice(plan.Price, plan.Currency)))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonURL(messages.PaymentLinkButton, invoice.URL),
+	))
+	s.bot.Send(msg)
+}
+
+// HandlePreCheckout answers Telegram's last check before charging the user.
+func (s *TelegramService) HandlePreCheckout(update tgbotapi.Update) {
+	query := update.PreCheckoutQuery
+
+	answer := tgbotapi.PreCheckoutConfig{PreCheckoutQueryID: query.ID, OK: true}
+	if err := s.paymentService.ValidateCheckout(query.From.ID, query.InvoicePayload, query.Currency, query.TotalAmount); err != nil {
+		log.Printf("Pre-checkout of user %d rejected: %v", query.From.ID, err)
+		answer.OK = false
+		answer.ErrorMessage = err.Error()
+	}
+
+	if _, err := s.bot.Request(answer); err != nil {
+		log.Printf("Error answering pre-checkout query: %v", err)
+	}
+}
+
+func (s *TelegramService) handleSuccessfulPayment(message *tgbotapi.Message) {
+	payment := message.SuccessfulPayment
+	userID := message.From.ID
+
+	planName, _, ok := parsePlanPayload(payment.InvoicePayload)
+	if !ok {
+		log.Printf("Payment %s of user %d has unknown payload %q", payment.TelegramPaymentChargeID, userID, payment.InvoicePayload)
+		s.bot.Send(tgbotapi.NewMessage(message.Chat.ID, messages.PaymentError))
+		return
+	}
+
+	applied, _, err := s.paymentService.Complete(userID, message.From.UserName, models.PaymentProviderStars, planName,
+		int64(payment.TotalAmount), payment.Currency, payment.TelegramPaymentChargeID, payment.ProviderPaymentChargeID)
+	if err != nil {
+		log.Printf("Error applying payment %s of user %d: %v", payment.TelegramPaymentChargeID, userID, err)
+		s.bot.Send(tgbotapi.NewMessage(message.Chat.ID, messages.PaymentError))
+		return
+	}
+
+	log.Printf("Payment %s of user %d applied: plan %s until %s", applied.ChargeID, userID, applied.Plan, applied.PaidUntil)
+	s.bot.Send(tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf(messages.PaymentSuccess, planName, applied.PaidUntil.Format(messages.TimeLayout))))
+}
+
+func (s *TelegramService) handleRefundCommand(chatID, adminID int64, args string) {
+	chargeID := strings.TrimSpace(args)
+	if chargeID == "" {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.RefundUsage))
+		return
+	}
+
+	payment, err := s.paymentService.Refund(adminID, chargeID)
+	if err != nil {
+		log.Printf("Error refunding payment %s: %v", chargeID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.RefundError))
+		return
+	}
+
+	s.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.RefundDone, chargeID, payment.UserID)))
+}
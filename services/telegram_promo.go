@@ -0,0 +1,154 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"xray-telegram-bot/messages"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// handlePromoCommand parses "/promo <batch> <count> <benefit> [uses] [duration]".
+func (s *TelegramService) handlePromoCommand(chatID, adminID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) < 3 || len(fields) > 5 {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.PromoUsage))
+		return
+	}
+
+	batch, benefit := fields[0], fields[2]
+	count, err := strconv.Atoi(fields[1])
+	if err != nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.PromoUsage))
+		return
+	}
+
+	maxUses := 1
+	if len(fields) > 3 {
+		if maxUses, err = strconv.Atoi(fields[3]); err != nil {
+			s.bot.Send(tgbotapi.NewMessage(chatID, messages.PromoUsage))
+			return
+		}
+	}
+
+	var expiresAt *time.Time
+	if len(fields) > 4 {
+		duration, ok := parseDuration(fields[4])
+		if !ok {
+			s.bot.Send(tgbotapi.NewMessage(chatID, messages.PromoUsage))
+			return
+		}
+		expiry := time.Now().Add(duration)
+		expiresAt = &expiry
+	}
+
+	codes, err := s.promoService.CreateBatch(adminID, batch, count, benefit, maxUses, expiresAt)
+	if err != nil {
+		log.Printf("Error creating promo batch %s: %v", batch, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.PromoCreateError, err)))
+		return
+	}
+
+	log.Printf("Admin %d created promo batch %s: %d codes of %s", adminID, batch, len(codes), benefit)
+	s.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.PromoCreated, len(codes), batch, messages.FormatPromoBenefit(benefit))))
+	s.sendPromoExport(chatID, batch)
+}
+
+func (s *TelegramService) handlePromoExportCommand(chatID int64, args string) {
+	batch := strings.TrimSpace(args)
+	if batch == "" {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.PromoExportUsage))
+		return
+	}
+
+	s.sendPromoExport(chatID, batch)
+}
+
+func (s *TelegramService) sendPromoExport(chatID int64, batch string) {
+	data, err := s.promoService.ExportCSV(batch)
+	if err != nil {
+		log.Printf("Error exporting promo batch %s: %v", batch, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.PromoExportError))
+		return
+	}
+
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: "promo-" + batch + ".csv", Bytes: data})
+	if _, err := s.bot.Send(doc); err != nil {
+		log.Printf("Error sending promo export: %v", err)
+	}
+}
+
+func (s *TelegramService) handlePromoStatsCommand(chatID int64) {
+	stats, err := s.promoService.Stats()
+	if err != nil {
+		log.Printf("Error querying promo stats: %v", err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.PromoExportError))
+		return
+	}
+	if len(stats) == 0 {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.PromoStatsEmpty))
+		return
+	}
+
+	lines := []string{messages.PromoStatsHeader}
+	for _, batch := range stats {
+		lines = append(lines, messages.FormatPromoBatchStats(batch))
+	}
+	s.bot.Send(tgbotapi.NewMessage(chatID, strings.Join(lines, "\n")))
+}
+
+func (s *TelegramService) handleRedeemCommand(chatID, userID int64, username, code string) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.RedeemUsage))
+		return
+	}
+
+	ban, err := s.userService.GetBan(userID)
+	if err != nil {
+		log.Printf("Error checking ban of user %d: %v", userID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.RedeemError))
+		return
+	}
+	if ban != nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.BannedMessage, ban.Reason, messages.FormatExpiry(ban.ExpiresAt))))
+		return
+	}
+
+	promo, err := s.promoService.Redeem(userID, username, code)
+	if err != nil {
+		log.Printf("Promo code %q of user %d not redeemed: %v", code, userID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, redeemErrorMessage(err)))
+		return
+	}
+
+	log.Printf("User %d redeemed promo code %s (%s)", userID, promo.Code, promo.Benefit)
+	text := fmt.Sprintf(messages.PromoRedeemed, messages.FormatPromoBenefit(promo.Benefit))
+	if user, err := s.userService.GetUser(userID); err != nil {
+		log.Printf("Error loading user %d: %v", userID, err)
+	} else if user != nil && user.HasPlanAccess(time.Now()) {
+		text += "\n" + messages.FormatAccessStatus(user)
+	}
+	s.bot.Send(tgbotapi.NewMessage(chatID, text+"\n\n"+messages.PromoRedeemedHint))
+}
+
+func redeemErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, ErrPromoNotFound):
+		return messages.RedeemNotFound
+	case errors.Is(err, ErrPromoExpired):
+		return messages.RedeemExpired
+	case errors.Is(err, ErrPromoUsedUp):
+		return messages.RedeemUsedUp
+	case errors.Is(err, ErrPromoRedeemed):
+		return messages.RedeemAlready
+	case errors.Is(err, ErrPromoNotApplicable):
+		return messages.RedeemNotApplicable
+	default:
+		return messages.RedeemError
+	}
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"log"
+	"xray-telegram-bot/messages"
+	"xray-telegram-bot/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/skip2/go-qrcode"
+)
+
+// qrSize is the width and height of QR code images in pixels.
+const qrSize = 512
+
+// qrPhoto returns a photo message with the QR code of content, so that a
+// config can be scanned by a device other than the one running Telegram.
+func qrPhoto(chatID int64, content, caption string) (tgbotapi.PhotoConfig, error) {
+	png, err := qrcode.Encode(content, qrcode.Medium, qrSize)
+	if err != nil {
+		return tgbotapi.PhotoConfig{}, err
+	}
+	photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{Name: "qr.png", Bytes: png})
+	photo.Caption = caption
+	return photo, nil
+}
+
+// sendQR sends the QR code of content. Failures are only logged, the text
+// of the content has been sent already.
+func (s *TelegramService) sendQR(chatID int64, content, caption string) {
+	photo, err := qrPhoto(chatID, content, caption)
+	if err != nil {
+		log.Printf("Error encoding QR code for chat %d: %v", chatID, err)
+		return
+	}
+	if _, err := s.bot.Send(photo); err != nil {
+		log.Printf("Error sending QR code to chat %d: %v", chatID, err)
+	}
+}
+
+// handleQRCommand sends the QR codes of the user's config and subscription
+// link. Configs are only handed out by /check, /qr shows the current one.
+func (s *TelegramService) handleQRCommand(chatID, userID int64) {
+	user, err := s.userService.GetUser(userID)
+	if err != nil {
+		log.Printf("Error loading user %d: %v", userID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ConfigGenerationError))
+		return
+	}
+	if user == nil || user.Status != models.UserStatusActive {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.QRNoConfig))
+		return
+	}
+
+	if s.sendConfigFile(chatID, userID) {
+		return
+	}
+
+	s.sendQR(chatID, s.userService.ConfigLink(user), messages.QRConfig)
+	if subscriptionURL := s.userService.SubscriptionURL(user); subscriptionURL != "" {
+		s.sendQR(chatID, subscriptionURL, messages.QRSubscription)
+	}
+}
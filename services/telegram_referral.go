@@ -0,0 +1,27 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"xray-telegram-bot/messages"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func (s *TelegramService) handleInviteCommand(chatID, userID int64) {
+	stats, err := s.referralService.Stats(userID)
+	if err != nil {
+		log.Printf("Error loading referral stats of user %d: %v", userID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.InviteError))
+		return
+	}
+
+	rewards := make([]string, 0, len(s.config.ReferralRewards))
+	for _, reward := range s.config.ReferralRewards {
+		rewards = append(rewards, messages.FormatReward(reward))
+	}
+
+	text := fmt.Sprintf(messages.InviteMessage, s.referralService.Link(userID), stats.Invited, stats.Activated, strings.Join(rewards, ", "))
+	s.bot.Send(tgbotapi.NewMessage(chatID, text))
+}
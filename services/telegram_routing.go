@@ -0,0 +1,59 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"xray-telegram-bot/messages"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func (s *TelegramService) handleProfileCommand(chatID, userID int64) {
+	user, err := s.userService.GetUser(userID)
+	if err != nil {
+		log.Printf("Error loading user %d: %v", userID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.RoutingProfileError))
+		return
+	}
+	if user == nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.RoutingProfileNoUser))
+		return
+	}
+
+	current := s.userService.RoutingProfile(user)
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, profile := range s.config.RoutingProfiles {
+		label := profile.Title
+		if profile.Name == current.Name {
+			label = "• " + label
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, "rp:"+profile.Name),
+		))
+	}
+
+	msg := tgbotapi.NewMessage(chatID, messages.RoutingProfilePrompt)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	s.bot.Send(msg)
+}
+
+func (s *TelegramService) handleProfileCallback(query *tgbotapi.CallbackQuery, name string) {
+	err := s.userService.SetRoutingProfile(query.From.ID, name)
+	if errors.Is(err, ErrProfileChangeTooSoon) {
+		s.bot.Request(tgbotapi.NewCallbackWithAlert(query.ID, messages.RoutingProfileTooSoon))
+		return
+	}
+	if err != nil {
+		log.Printf("Error setting routing profile of user %d: %v", query.From.ID, err)
+		s.bot.Request(tgbotapi.NewCallbackWithAlert(query.ID, messages.RoutingProfileError))
+		return
+	}
+
+	profile, _ := s.config.RoutingProfile(name)
+	s.bot.Request(tgbotapi.NewCallback(query.ID, ""))
+	if query.Message != nil {
+		s.bot.Send(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID,
+			messages.RoutingProfileChanged+profile.Title))
+	}
+}
@@ -0,0 +1,483 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"xray-telegram-bot/messages"
+	"xray-telegram-bot/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func (s *TelegramService) handleLocationCommand(chatID, userID int64) {
+	user, err := s.userService.GetUser(userID)
+	if err != nil {
+		log.Printf("Error loading user %d: %v", userID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.LocationError))
+		return
+	}
+	if user == nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.RoutingProfileNoUser))
+		return
+	}
+
+	locations, err := s.serverService.Locations(user)
+	if err != nil {
+		log.Printf("Error listing locations for user %d: %v", userID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.LocationError))
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, location := range locations {
+		label := location.Server.Label()
+		switch {
+		case location.Server.ID == user.ServerID:
+			label = "• " + label
+		case location.Server.Full(location.Users):
+			label += messages.LocationFull
+		case !location.Server.Reachable():
+			label += messages.LocationDown
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, fmt.Sprintf("loc:%d", location.Server.ID)),
+		))
+	}
+	if len(rows) == 0 {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.LocationError))
+		return
+	}
+
+	msg := tgbotapi.NewMessage(chatID, messages.LocationPrompt)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	s.bot.Send(msg)
+}
+
+func (s *TelegramService) handleLocationCallback(query *tgbotapi.CallbackQuery, data string) {
+	serverID, err := strconv.ParseInt(data, 10, 64)
+	if err != nil {
+		s.bot.Request(tgbotapi.NewCallback(query.ID, ""))
+		return
+	}
+
+	server, err := s.userService.SetLocation(query.From.ID, serverID)
+	if err != nil {
+		log.Printf("Error moving user %d to server %d: %v", query.From.ID, serverID, err)
+		s.bot.Request(tgbotapi.NewCallbackWithAlert(query.ID, messages.LocationError))
+		return
+	}
+
+	s.bot.Request(tgbotapi.NewCallback(query.ID, ""))
+	if query.Message != nil {
+		s.bot.Send(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID,
+			fmt.Sprintf(messages.LocationChanged, server.Label())))
+	}
+}
+
+// sendConfigFile answers /check with the config file of the user's
+// location and its QR code, for locations whose backend hands out files
+// instead of links. It reports whether it answered.
+func (s *TelegramService) sendConfigFile(chatID, userID int64) bool {
+	user, err := s.userService.GetUser(userID)
+	if err != nil || user == nil {
+		return false
+	}
+
+	name, data, err := s.userService.ConfigFile(user)
+	if err != nil {
+		log.Printf("Error generating config file of user %d: %v", userID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ConfigGenerationError))
+		return true
+	}
+	if name == "" {
+		return false
+	}
+
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: name, Bytes: data})
+	doc.Caption = messages.WireGuardConfig
+	if _, err := s.bot.Send(doc); err != nil {
+		log.Printf("Error sending config file of user %d: %v", userID, err)
+	}
+
+	s.sendQR(chatID, string(data), messages.WireGuardQR)
+	return true
+}
+
+// sendLocationInfo follows up a /check reply with the user's location and
+// subscription link when there is anything to choose from.
+func (s *TelegramService) sendLocationInfo(chatID, userID int64) {
+	user, err := s.userService.GetUser(userID)
+	if err != nil || user == nil {
+		return
+	}
+
+	var lines []string
+	if locations, err := s.serverService.Locations(user); err == nil && len(locations) > 1 {
+		if server, err := s.userService.Location(user); err == nil && server != nil {
+			lines = append(lines, fmt.Sprintf(messages.LocationCurrent, server.Label()))
+		}
+	}
+	if subscriptionURL := s.userService.SubscriptionURL(user); subscriptionURL != "" {
+		lines = append(lines, fmt.Sprintf(messages.SubscriptionLink, subscriptionURL))
+	}
+
+	if len(lines) > 0 {
+		s.bot.Send(tgbotapi.NewMessage(chatID, strings.Join(lines, "\n")))
+	}
+}
+
+func (s *TelegramService) handleServersCommand(chatID int64) {
+	loads, err := s.serverService.Loads()
+	if err != nil {
+		log.Printf("Error listing servers: %v", err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerError))
+		return
+	}
+
+	lines := []string{messages.ServersHeader}
+	for _, load := range loads {
+		lines = append(lines, messages.FormatServerLoad(load.Server, load.Users, load.Active))
+	}
+	s.bot.Send(tgbotapi.NewMessage(chatID, strings.Join(lines, "\n")))
+}
+
+// handleAddServerCommand parses
+// "/addserver <name> <flag> <domain> <port> <api> <tag> [capacity] [config_path]".
+func (s *TelegramService) handleAddServerCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) < 6 || len(fields) > 8 {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.AddServerUsage))
+		return
+	}
+
+	port, err := strconv.Atoi(fields[3])
+	if err != nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.AddServerUsage))
+		return
+	}
+
+	server := &models.Server{
+		Name:       fields[0],
+		Flag:       fields[1],
+		Domain:     fields[2],
+		Port:       port,
+		APIAddress: fields[4],
+		InboundTag: fields[5],
+	}
+	if len(fields) > 6 {
+		if server.Capacity, err = strconv.Atoi(fields[6]); err != nil {
+			s.bot.Send(tgbotapi.NewMessage(chatID, messages.AddServerUsage))
+			return
+		}
+	}
+	if len(fields) > 7 {
+		server.ConfigPath = fields[7]
+	}
+
+	if err := s.serverService.AddServer(server); err != nil {
+		log.Printf("Error adding server %s: %v", server.Name, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerError))
+		return
+	}
+
+	s.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.ServerAdded, server.Label())))
+}
+
+// handleServerSetCommand parses "/serverset <name> on|off|capacity <n>|weight <n>".
+func (s *TelegramService) handleServerSetCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) < 2 {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerSetUsage))
+		return
+	}
+
+	server, err := s.serverService.ServerByName(fields[0])
+	if err != nil || server == nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerNotFound))
+		return
+	}
+
+	switch {
+	case fields[1] == "on" || fields[1] == "off":
+		err = s.serverService.SetEnabled(server, fields[1] == "on")
+	case fields[1] == "capacity" && len(fields) == 3:
+		capacity, convErr := strconv.Atoi(fields[2])
+		if convErr != nil || capacity < 0 {
+			s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerSetUsage))
+			return
+		}
+		err = s.serverService.SetCapacity(server, capacity)
+	case fields[1] == "weight" && len(fields) == 3:
+		weight, convErr := strconv.Atoi(fields[2])
+		if convErr != nil || weight < 1 {
+			s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerSetUsage))
+			return
+		}
+		err = s.serverService.SetWeight(server, weight)
+	default:
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerSetUsage))
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error updating server %s: %v", server.Name, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerError))
+		return
+	}
+	s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerUpdated))
+}
+
+// defaultMigrationDrain is how long migrated users keep their old server
+// when /migrate gets no drain period.
+const defaultMigrationDrain = 24 * time.Hour
+
+// handleMigrateCommand parses "/migrate <from> <to> <all|count> [drain]"; a
+// drain of 0 removes users from the old server right away.
+func (s *TelegramService) handleMigrateCommand(chatID, adminID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) != 3 && len(fields) != 4 {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.MigrateUsage))
+		return
+	}
+
+	limit := 0
+	if fields[2] != "all" {
+		var err error
+		if limit, err = strconv.Atoi(fields[2]); err != nil || limit < 1 {
+			s.bot.Send(tgbotapi.NewMessage(chatID, messages.MigrateUsage))
+			return
+		}
+	}
+
+	drain := defaultMigrationDrain
+	if len(fields) == 4 {
+		if fields[3] == "0" {
+			drain = 0
+		} else if duration, ok := parseDuration(fields[3]); ok {
+			drain = duration
+		} else {
+			s.bot.Send(tgbotapi.NewMessage(chatID, messages.MigrateUsage))
+			return
+		}
+	}
+
+	from, err := s.serverService.ServerByName(fields[0])
+	if err != nil || from == nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerNotFound))
+		return
+	}
+	to, err := s.serverService.ServerByName(fields[1])
+	if err != nil || to == nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerNotFound))
+		return
+	}
+
+	migration, err := s.userService.MigrateUsers(from, to, limit, drain, models.AdminActor(adminID))
+	if err != nil {
+		log.Printf("Error migrating users from %s to %s: %v", from.Name, to.Name, err)
+		if migration == nil {
+			s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerError))
+			return
+		}
+	}
+
+	s.bot.Send(tgbotapi.NewMessage(chatID, messages.FormatMigration(from, to, len(migration.Moved), migration.Silent, migration.Skipped)))
+	go s.notifyMigrated(migration, drain)
+}
+
+// notifyMigrated asks migrated users to fetch their new config, at the
+// broadcast rate.
+func (s *TelegramService) notifyMigrated(migration *Migration, drain time.Duration) {
+	for _, userID := range migration.Moved {
+		text := fmt.Sprintf(messages.MigratedMessage, migration.To.Label())
+		if drain > 0 {
+			text += fmt.Sprintf(messages.MigratedDrain, migration.DrainUntil.Local().Format(messages.TimeLayout))
+		}
+		s.notify(userID, tgbotapi.NewMessage(userID, text))
+		time.Sleep(time.Second / time.Duration(s.config.BroadcastRate))
+	}
+}
+
+// handleServerAgentCommand parses "/serveragent <name> <url> <secret>" and
+// "/serveragent <name> off".
+func (s *TelegramService) handleServerAgentCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) != 2 && len(fields) != 3 || len(fields) == 2 && fields[1] != "off" {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerAgentUsage))
+		return
+	}
+
+	server, err := s.serverService.ServerByName(fields[0])
+	if err != nil || server == nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerNotFound))
+		return
+	}
+
+	var agentURL, agentSecret string
+	if len(fields) == 3 {
+		agentURL, agentSecret = strings.TrimSuffix(fields[1], "/"), fields[2]
+	}
+	if err := s.serverService.SetAgent(server, agentURL, agentSecret); err != nil {
+		log.Printf("Error updating agent of server %s: %v", server.Name, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerError))
+		return
+	}
+
+	if agentURL == "" {
+		s.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.ServerAgentRemoved, server.Label())))
+		return
+	}
+
+	server.AgentURL, server.AgentSecret = agentURL, agentSecret
+	if err := s.serverService.Client(server).TestAPI(); err != nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.ServerAgentDown, server.Label(), err)))
+		return
+	}
+	s.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.ServerAgentSet, server.Label())))
+}
+
+// handleServerBackendCommand parses "/serverbackend <name> <panel> <url>"
+// and "/serverbackend <name> xray".
+func (s *TelegramService) handleServerBackendCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) < 2 || fields[1] == models.BackendXray && len(fields) != 2 || fields[1] != models.BackendXray && len(fields) != 3 {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerBackendUsage))
+		return
+	}
+
+	server, err := s.serverService.ServerByName(fields[0])
+	if err != nil || server == nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerNotFound))
+		return
+	}
+
+	var backendURL string
+	if len(fields) == 3 {
+		backendURL = fields[2]
+	}
+	if err := s.serverService.SetBackend(server, fields[1], backendURL); err != nil {
+		log.Printf("Error updating backend of server %s: %v", server.Name, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.ServerBackendError, err)))
+		return
+	}
+
+	if err := s.serverService.Backend(server).TestAPI(); err != nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.ServerBackendDown, server.Label(), server.Backend, err)))
+		return
+	}
+	s.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.ServerBackendSet, server.Label(), server.Backend)))
+}
+
+// handleServerConfigCommand sends the current Xray config of a server as a
+// document, read locally or through the server's agent.
+func (s *TelegramService) handleServerConfigCommand(chatID int64, args string) {
+	name := strings.TrimSpace(args)
+	if name == "" {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerConfigUsage))
+		return
+	}
+
+	server, err := s.serverService.ServerByName(name)
+	if err != nil || server == nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerNotFound))
+		return
+	}
+	if !server.HasConfig() {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerConfigMissing))
+		return
+	}
+
+	data, err := s.serverService.Client(server).ConfigSnapshot()
+	if err != nil {
+		log.Printf("Error reading config of server %s: %v", server.Name, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.ServerConfigError, err)))
+		return
+	}
+
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: server.Name + "-config.json", Bytes: data})
+	if _, err := s.bot.Send(doc); err != nil {
+		log.Printf("Error sending config of server %s: %v", server.Name, err)
+	}
+}
+
+// healthHistoryLimit is how many health changes /health shows.
+const healthHistoryLimit = 10
+
+func (s *TelegramService) handleHealthCommand(chatID int64, args string) {
+	name := strings.TrimSpace(args)
+	if name == "" {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.HealthUsage))
+		return
+	}
+
+	server, err := s.serverService.ServerByName(name)
+	if err != nil || server == nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerNotFound))
+		return
+	}
+
+	history, err := s.healthService.History(server, healthHistoryLimit)
+	if err != nil {
+		log.Printf("Error loading health of server %s: %v", server.Name, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerError))
+		return
+	}
+
+	lines := []string{fmt.Sprintf(messages.HealthHeader, server.Label(), messages.FormatHealth(server.Health))}
+	for _, change := range history {
+		lines = append(lines, messages.FormatHealthRecord(change))
+	}
+	if len(history) == 0 {
+		lines = append(lines, messages.HealthEmpty)
+	}
+	s.bot.Send(tgbotapi.NewMessage(chatID, strings.Join(lines, "\n")))
+}
+
+func (s *TelegramService) handleCertsCommand(chatID int64) {
+	servers, err := s.serverService.Servers()
+	if err != nil {
+		log.Printf("Error listing servers: %v", err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerError))
+		return
+	}
+
+	lines := []string{messages.CertificatesHeader}
+	for _, server := range servers {
+		certificates, err := s.healthService.Certificates(server)
+		if err != nil {
+			log.Printf("Error loading certificates of server %s: %v", server.Name, err)
+			s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerError))
+			return
+		}
+		for _, certificate := range certificates {
+			lines = append(lines, messages.FormatCertificate(server, certificate))
+		}
+	}
+	if len(lines) == 1 {
+		lines = append(lines, messages.CertificatesEmpty)
+	}
+	s.bot.Send(tgbotapi.NewMessage(chatID, strings.Join(lines, "\n")))
+}
+
+func (s *TelegramService) handleXrayStatusCommand(chatID int64) {
+	servers, err := s.serverService.Servers()
+	if err != nil {
+		log.Printf("Error listing servers: %v", err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ServerError))
+		return
+	}
+
+	lines := []string{messages.FallbackHeader}
+	for _, server := range servers {
+		if status, ok := s.serverService.Client(server).FallbackStatus(); ok {
+			lines = append(lines, messages.FormatFallbackStatus(server, status))
+		}
+	}
+	if len(lines) == 1 {
+		lines = append(lines, messages.FallbackEmpty)
+	}
+	s.bot.Send(tgbotapi.NewMessage(chatID, strings.Join(lines, "\n")))
+}
@@ -1,27 +1,51 @@
 package services
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
+	"strings"
 	"time"
 	"xray-telegram-bot/config"
 	"xray-telegram-bot/messages"
+	"xray-telegram-bot/models"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 type TelegramService struct {
-	bot         *tgbotapi.BotAPI
-	config      *config.Config
-	userService *UserService
+	bot              *tgbotapi.BotAPI
+	config           *config.Config
+	userService      *UserService
+	broadcastService *BroadcastService
+	activityService  *ActivityService
+	sharingService   *SharingService
+	trialService     *TrialService
+	referralService  *ReferralService
+	paymentService   *PaymentService
+	promoService     *PromoService
+	serverService    *ServerService
+	healthService    *HealthService
 }
 
-func NewTelegramService(bot *tgbotapi.BotAPI, cfg *config.Config, userService *UserService) *TelegramService {
+func NewTelegramService(bot *tgbotapi.BotAPI, cfg *config.Config, userService *UserService, broadcastService *BroadcastService,
+	activityService *ActivityService, sharingService *SharingService, trialService *TrialService,
+	referralService *ReferralService, paymentService *PaymentService, promoService *PromoService,
+	serverService *ServerService, healthService *HealthService) *TelegramService {
 	return &TelegramService{
-		bot:         bot,
-		config:      cfg,
-		userService: userService,
+		bot:              bot,
+		config:           cfg,
+		userService:      userService,
+		broadcastService: broadcastService,
+		activityService:  activityService,
+		sharingService:   sharingService,
+		trialService:     trialService,
+		referralService:  referralService,
+		paymentService:   paymentService,
+		promoService:     promoService,
+		serverService:    serverService,
+		healthService:    healthService,
 	}
 }
 
@@ -29,24 +53,296 @@ func (s *TelegramService) HandleMessage(update tgbotapi.Update) {
 	userID := update.Message.From.ID
 	username := update.Message.From.UserName
 
-	switch update.Message.Text {
-	case "/start":
-		msg := tgbotapi.NewMessage(update.Message.Chat.ID, messages.StartMessage)
-		s.bot.Send(msg)
+	if err := s.userService.UpdateLanguage(userID, update.Message.From.LanguageCode); err != nil {
+		log.Printf("Error updating language of user %d: %v", userID, err)
+	}
+
+	if update.Message.SuccessfulPayment != nil {
+		s.handleSuccessfulPayment(update.Message)
+		return
+	}
+
+	switch update.Message.Command() {
+	case "start":
+		s.handleStartCommand(update.Message.Chat.ID, userID, username, update.Message.CommandArguments())
+		return
+
+	case "invite":
+		s.handleInviteCommand(update.Message.Chat.ID, userID)
+		return
+
+	case "redeem":
+		s.handleRedeemCommand(update.Message.Chat.ID, userID, username, update.Message.CommandArguments())
+		return
+
+	case "promo":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handlePromoCommand(update.Message.Chat.ID, userID, update.Message.CommandArguments())
+		}
+		return
+
+	case "promoexport":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handlePromoExportCommand(update.Message.Chat.ID, update.Message.CommandArguments())
+		}
+		return
+
+	case "promostats":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handlePromoStatsCommand(update.Message.Chat.ID)
+		}
+		return
+
+	case "buy":
+		s.handleBuyCommand(update.Message.Chat.ID, userID)
+		return
+
+	case "refund":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleRefundCommand(update.Message.Chat.ID, userID, update.Message.CommandArguments())
+		}
+		return
+
+	case "extend":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleExpiryCommand(update.Message.Chat.ID, userID, update.Message.CommandArguments(), 1, messages.ExtendUsage)
+		}
 		return
 
-	case "/check":
+	case "shorten":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleExpiryCommand(update.Message.Chat.ID, userID, update.Message.CommandArguments(), -1, messages.ShortenUsage)
+		}
+		return
+
+	case "check":
 		s.handleCheckCommand(update.Message.Chat.ID, userID, username)
 		return
 
+	case "location":
+		s.handleLocationCommand(update.Message.Chat.ID, userID)
+		return
+
+	case "qr":
+		s.handleQRCommand(update.Message.Chat.ID, userID)
+		return
+
+	case "servers":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleServersCommand(update.Message.Chat.ID)
+		}
+		return
+
+	case "addserver":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleAddServerCommand(update.Message.Chat.ID, update.Message.CommandArguments())
+		}
+		return
+
+	case "serverset":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleServerSetCommand(update.Message.Chat.ID, update.Message.CommandArguments())
+		}
+		return
+
+	case "migrate":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleMigrateCommand(update.Message.Chat.ID, userID, update.Message.CommandArguments())
+		}
+		return
+
+	case "health":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleHealthCommand(update.Message.Chat.ID, update.Message.CommandArguments())
+		}
+		return
+
+	case "certs":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleCertsCommand(update.Message.Chat.ID)
+		}
+		return
+
+	case "xraystatus":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleXrayStatusCommand(update.Message.Chat.ID)
+		}
+		return
+
+	case "serverbackend":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleServerBackendCommand(update.Message.Chat.ID, update.Message.CommandArguments())
+		}
+		return
+
+	case "serveragent":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleServerAgentCommand(update.Message.Chat.ID, update.Message.CommandArguments())
+		}
+		return
+
+	case "serverconfig":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleServerConfigCommand(update.Message.Chat.ID, update.Message.CommandArguments())
+		}
+		return
+
+	case "profile":
+		s.handleProfileCommand(update.Message.Chat.ID, userID)
+		return
+
+	case "usage":
+		s.handleUsageCommand(update.Message.Chat.ID, userID)
+		return
+
+	case "user":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleUserCommand(update.Message.Chat.ID, update.Message.CommandArguments())
+		}
+		return
+
+	case "ban":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleBanCommand(update.Message.Chat.ID, userID, update.Message.CommandArguments())
+		}
+		return
+
+	case "unban":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleUnbanCommand(update.Message.Chat.ID, userID, update.Message.CommandArguments())
+		}
+		return
+
+	case "bans":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleBansCommand(update.Message.Chat.ID, update.Message.CommandArguments())
+		}
+		return
+
+	case "sharing":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleSharingCommand(update.Message.Chat.ID)
+		}
+		return
+
+	case "iplimit":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleIPLimitCommand(update.Message.Chat.ID, update.Message.CommandArguments())
+		}
+		return
+
+	case "unsuspend":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleUnsuspendCommand(update.Message.Chat.ID, userID, update.Message.CommandArguments())
+		}
+		return
+
+	case "trials":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleTrialsCommand(update.Message.Chat.ID)
+		}
+		return
+
+	case "events":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleEventsCommand(update.Message.Chat.ID, update.Message.CommandArguments())
+		}
+		return
+
+	case "broadcast":
+		if s.requireAdmin(update.Message.Chat.ID, userID) {
+			s.handleBroadcastCommand(update.Message.Chat.ID, userID, update.Message.CommandArguments())
+		}
+		return
+
 	default:
 		msg := tgbotapi.NewMessage(update.Message.Chat.ID, messages.HelpMessage)
 		s.bot.Send(msg)
 	}
 }
 
+func (s *TelegramService) HandleCallback(update tgbotapi.Update) {
+	query := update.CallbackQuery
+	parts := strings.Split(query.Data, ":")
+
+	switch parts[0] {
+	case "bc":
+		if !s.config.IsAdmin(query.From.ID) {
+			s.bot.Request(tgbotapi.NewCallback(query.ID, messages.AdminOnlyMessage))
+			return
+		}
+		s.handleBroadcastCallback(query, parts[1:])
+	case "trial":
+		s.handleTrialCallback(query)
+	case "buy":
+		s.handleBuyCallback(query, strings.Join(parts[1:], ":"))
+	case "pay":
+		s.handlePayCallback(query, strings.Join(parts[1:], ":"))
+	case "loc":
+		s.handleLocationCallback(query, strings.Join(parts[1:], ":"))
+	case "rp":
+		s.handleProfileCallback(query, strings.Join(parts[1:], ":"))
+	default:
+		s.bot.Request(tgbotapi.NewCallback(query.ID, ""))
+	}
+}
+
+// handleStartCommand greets the user and handles deep-link payloads such as
+// "ref_<code>" and "gift_<code>".
+func (s *TelegramService) handleStartCommand(chatID, userID int64, username, payload string) {
+	msg := tgbotapi.NewMessage(chatID, messages.StartMessage)
+
+	if code, ok := strings.CutPrefix(payload, "gift_"); ok {
+		s.bot.Send(msg)
+		s.handleRedeemCommand(chatID, userID, username, code)
+		return
+	}
+
+	if code, ok := strings.CutPrefix(payload, "ref_"); ok {
+		if err := s.referralService.Register(userID, code); err != nil {
+			log.Printf("Referral %q of user %d not recorded: %v", code, userID, err)
+		} else {
+			log.Printf("User %d referred with code %s", userID, code)
+			msg.Text += "\n\n" + messages.ReferralAccepted
+		}
+	}
+
+	s.bot.Send(msg)
+}
+
+// requireAdmin replies with a refusal and returns false for non-admins.
+func (s *TelegramService) requireAdmin(chatID, userID int64) bool {
+	if s.config.IsAdmin(userID) {
+		return true
+	}
+
+	s.bot.Send(tgbotapi.NewMessage(chatID, messages.AdminOnlyMessage))
+	return false
+}
+
 func (s *TelegramService) handleCheckCommand(chatID, userID int64, username string) {
-	isSubscribed, err := s.checkSubscription(userID)
+	ban, err := s.userService.GetBan(userID)
+	if err != nil {
+		log.Printf("Error checking ban of user %d: %v", userID, err)
+		msg := tgbotapi.NewMessage(chatID, messages.SubscriptionCheckError)
+		s.bot.Send(msg)
+		return
+	}
+	if ban != nil {
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.BannedMessage, ban.Reason, messages.FormatExpiry(ban.ExpiresAt)))
+		s.bot.Send(msg)
+		return
+	}
+
+	user, err := s.userService.GetUser(userID)
+	if err != nil {
+		log.Printf("Error loading user %d: %v", userID, err)
+		msg := tgbotapi.NewMessage(chatID, messages.SubscriptionCheckError)
+		s.bot.Send(msg)
+		return
+	}
+
+	entitlement, trial, err := s.entitlement(userID, user)
 	if err != nil {
 		log.Printf("Error checking subscription: %v", err)
 		msg := tgbotapi.NewMessage(chatID, messages.SubscriptionCheckError)
@@ -54,28 +350,90 @@ func (s *TelegramService) handleCheckCommand(chatID, userID int64, username stri
 		return
 	}
 
-	if isSubscribed {
-		userUUID, vlessURL, err := s.userService.GetOrCreateVlessConfig(userID, username)
-		if err != nil {
-			log.Printf("Error generating VLESS config: %v", err)
-			msg := tgbotapi.NewMessage(chatID, messages.ConfigGenerationError)
-			s.bot.Send(msg)
-			return
-		}
+	switch entitlement {
+	case EntitlementTrial:
+		s.sendTrialConfig(chatID, userID, username, trial)
+		return
+	case EntitlementNone:
+		s.handleNotEntitled(chatID, userID, user)
+		return
+	}
 
-		responseText := fmt.Sprintf(messages.SubscribedMessage, userUUID, vlessURL)
-		msg := tgbotapi.NewMessage(chatID, responseText)
-		msg.ParseMode = "Markdown"
+	userUUID, vlessURL, err := s.userService.GetOrCreateVlessConfig(userID, username)
+	if errors.Is(err, ErrUserSuspended) {
+		msg := tgbotapi.NewMessage(chatID, messages.SuspendedMessage)
+		s.bot.Send(msg)
+		return
+	}
+	if err != nil {
+		log.Printf("Error generating VLESS config: %v", err)
+		msg := tgbotapi.NewMessage(chatID, messages.ConfigGenerationError)
 		s.bot.Send(msg)
+		return
+	}
+
+	responseText := fmt.Sprintf(messages.SubscribedMessage, userUUID, vlessURL)
+	if entitlement == EntitlementPlan {
+		responseText = fmt.Sprintf(messages.PlanConfigMessage, user.ExpiresAt.Format(messages.TimeLayout), userUUID, vlessURL)
 	} else {
-		if err := s.userService.RemoveUser(userID); err != nil {
-			log.Printf("Error removing user %d: %v", userID, err)
+		if err := s.trialService.Convert(userID); err != nil {
+			log.Printf("Error recording trial conversion of user %d: %v", userID, err)
 		}
+		s.referralService.Activate(userID)
+	}
 
-		responseText := fmt.Sprintf(messages.NotSubscribedMessage, s.config.ChannelUsername)
+	if !s.sendConfigFile(chatID, userID) {
 		msg := tgbotapi.NewMessage(chatID, responseText)
+		msg.ParseMode = "Markdown"
 		s.bot.Send(msg)
+		s.sendQR(chatID, vlessURL, messages.QRConfig)
 	}
+
+	s.sendLocationInfo(chatID, userID)
+}
+
+// handleNotEntitled answers /check for a user without any entitlement.
+// Users whose time-limited access ended or used up its traffic keep their
+// row for renewal; others are revoked and offered a trial.
+func (s *TelegramService) handleNotEntitled(chatID, userID int64, user *models.User) {
+	if user != nil && user.HasPlanAccess(time.Now()) {
+		if user.Status == models.UserStatusActive {
+			if err := s.userService.SuspendForQuota(user); err != nil {
+				log.Printf("Error suspending user %d over quota: %v", userID, err)
+			}
+		}
+		s.sendQuotaExhaustedMessage(chatID, user)
+		return
+	}
+	if user != nil && user.ExpiresAt != nil {
+		if user.Status == models.UserStatusActive {
+			if err := s.userService.ExpireUser(userID, "expired on /check"); err != nil {
+				log.Printf("Error expiring user %d: %v", userID, err)
+			}
+		}
+		s.sendExpiredMessage(chatID, user)
+		return
+	}
+
+	if err := s.userService.RemoveUser(userID, models.UserActor(userID), "not subscribed on /check"); err != nil {
+		log.Printf("Error removing user %d: %v", userID, err)
+	}
+
+	responseText := fmt.Sprintf(messages.NotSubscribedMessage, s.config.ChannelUsername)
+	msg := tgbotapi.NewMessage(chatID, responseText)
+
+	eligible, err := s.trialService.Eligible(userID)
+	if err != nil {
+		log.Printf("Error checking trial eligibility of user %d: %v", userID, err)
+	}
+	if eligible {
+		msg.Text += "\n\n" + fmt.Sprintf(messages.TrialOffer, messages.FormatDuration(s.config.TrialDuration), s.config.TrialTrafficMB)
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(messages.TrialButton, "trial:start"),
+		))
+	}
+
+	s.bot.Send(msg)
 }
 
 func (s *TelegramService) checkSubscription(userID int64) (bool, error) {
@@ -107,6 +465,8 @@ func (s *TelegramService) StartSubscriptionChecker() {
 	}()
 }
 
+// checkAllSubscriptions revokes users who left the channel and then
+// corrects drift between the database and Xray.
 func (s *TelegramService) checkAllSubscriptions() {
 	users, err := s.userService.GetAllUsers()
 	if err != nil {
@@ -115,6 +475,13 @@ func (s *TelegramService) checkAllSubscriptions() {
 	}
 
 	for _, user := range users {
+		// Trial users are not subscribers by definition; the trial checker
+		// takes care of them, and the expiry checker of users with
+		// time-limited access. Suspended users have no access to revoke.
+		if user.IsTrial || user.ExpiresAt != nil || user.Status == models.UserStatusSuspended {
+			continue
+		}
+
 		isSubscribed, err := s.checkSubscription(user.ID)
 		if err != nil {
 			log.Printf("Error checking subscription for user %d: %v", user.ID, err)
@@ -122,7 +489,7 @@ func (s *TelegramService) checkAllSubscriptions() {
 		}
 
 		if !isSubscribed {
-			if err := s.userService.RemoveUser(user.ID); err != nil {
+			if err := s.userService.RemoveUser(user.ID, models.SystemActor, "left channel "+s.config.ChannelUsername); err != nil {
 				log.Printf("Error removing user %d: %v", user.ID, err)
 			} else {
 				log.Printf("User %d removed due to unsubscription", user.ID)
@@ -138,4 +505,8 @@ func (s *TelegramService) checkAllSubscriptions() {
 			}
 		}
 	}
+
+	if err := s.userService.Reconcile(); err != nil {
+		log.Printf("Error reconciling users with Xray: %v", err)
+	}
 }
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"xray-telegram-bot/messages"
+	"xray-telegram-bot/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func (s *TelegramService) handleSharingCommand(chatID int64) {
+	offenders, err := s.sharingService.Offenders(10)
+	if err != nil {
+		log.Printf("Error querying sharing offenders: %v", err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.SharingReportError))
+		return
+	}
+
+	if len(offenders) == 0 {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.SharingReportEmpty))
+		return
+	}
+
+	lines := []string{messages.SharingReportHeader}
+	for _, offender := range offenders {
+		lines = append(lines, messages.FormatSharingOffender(offender))
+	}
+
+	s.bot.Send(tgbotapi.NewMessage(chatID, strings.Join(lines, "\n")))
+}
+
+// handleIPLimitCommand parses "/iplimit <user_id> <limit>"; a limit of 0
+// returns the user to the default.
+func (s *TelegramService) handleIPLimitCommand(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.IPLimitUsage))
+		return
+	}
+
+	userID, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.IPLimitUsage))
+		return
+	}
+	limit, err := strconv.Atoi(fields[1])
+	if err != nil || limit < 0 {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.IPLimitUsage))
+		return
+	}
+
+	if err := s.userService.SetIPLimit(userID, limit); err != nil {
+		log.Printf("Error setting IP limit of user %d: %v", userID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.SharingReportError))
+		return
+	}
+
+	s.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.IPLimitDone, userID, limit)))
+}
+
+func (s *TelegramService) handleUnsuspendCommand(chatID, adminID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) < 1 {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.UnsuspendUsage))
+		return
+	}
+
+	userID, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.UnsuspendUsage))
+		return
+	}
+
+	err = s.userService.UnsuspendUser(userID, models.AdminActor(adminID), strings.Join(fields[1:], " "))
+	if errors.Is(err, ErrUserBanned) {
+		s.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.UnsuspendBanned, userID, userID)))
+		return
+	}
+	if err != nil {
+		log.Printf("Error unsuspending user %d: %v", userID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.SharingReportError))
+		return
+	}
+
+	s.bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(messages.UnsuspendDone, userID)))
+}
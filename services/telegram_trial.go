@@ -0,0 +1,80 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"xray-telegram-bot/messages"
+	"xray-telegram-bot/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func (s *TelegramService) handleTrialCallback(query *tgbotapi.CallbackQuery) {
+	userID := query.From.ID
+	s.bot.Request(tgbotapi.NewCallback(query.ID, ""))
+
+	if query.Message == nil {
+		return
+	}
+	chatID := query.Message.Chat.ID
+
+	if ban, err := s.userService.GetBan(userID); err != nil || ban != nil {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.TrialUnavailable))
+		return
+	}
+
+	eligible, err := s.trialService.Eligible(userID)
+	if err != nil {
+		log.Printf("Error checking trial eligibility of user %d: %v", userID, err)
+	}
+	if !eligible {
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.TrialUnavailable))
+		return
+	}
+
+	trial, userUUID, vlessURL, err := s.trialService.Start(userID, query.From.UserName)
+	if err != nil {
+		log.Printf("Error starting trial of user %d: %v", userID, err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ConfigGenerationError))
+		return
+	}
+
+	log.Printf("Trial started for user %d", userID)
+	if !s.sendConfigFile(chatID, userID) {
+		s.sendTrialMessage(chatID, trial, userUUID, vlessURL)
+	}
+}
+
+// sendTrialConfig answers /check for a user who is on a trial.
+func (s *TelegramService) sendTrialConfig(chatID, userID int64, username string, trial *models.Trial) {
+	userUUID, vlessURL, err := s.userService.GetOrCreateVlessConfig(userID, username)
+	if err != nil {
+		log.Printf("Error generating VLESS config: %v", err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.ConfigGenerationError))
+		return
+	}
+
+	s.sendTrialMessage(chatID, trial, userUUID, vlessURL)
+}
+
+func (s *TelegramService) sendTrialMessage(chatID int64, trial *models.Trial, userUUID, vlessURL string) {
+	remaining := time.Until(trial.ExpiresAt).Round(time.Minute)
+	text := fmt.Sprintf(messages.TrialMessage, messages.FormatDuration(remaining), s.config.ChannelUsername, userUUID, vlessURL)
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "Markdown"
+	s.bot.Send(msg)
+	s.sendQR(chatID, vlessURL, messages.QRConfig)
+}
+
+func (s *TelegramService) handleTrialsCommand(chatID int64) {
+	stats, err := s.trialService.Stats()
+	if err != nil {
+		log.Printf("Error querying trial stats: %v", err)
+		s.bot.Send(tgbotapi.NewMessage(chatID, messages.TrialStatsError))
+		return
+	}
+
+	s.bot.Send(tgbotapi.NewMessage(chatID, messages.FormatTrialStats(stats)))
+}
@@ -0,0 +1,189 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/database"
+	"xray-telegram-bot/messages"
+	"xray-telegram-bot/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const trialCheckInterval = 10 * time.Minute
+
+// TrialService gives non-subscribers one time- and traffic-limited trial
+// per Telegram ID.
+type TrialService struct {
+	bot         *tgbotapi.BotAPI
+	db          *database.Database
+	config      *config.Config
+	userService *UserService
+}
+
+func NewTrialService(bot *tgbotapi.BotAPI, db *database.Database, cfg *config.Config, userService *UserService) *TrialService {
+	return &TrialService{
+		bot:         bot,
+		db:          db,
+		config:      cfg,
+		userService: userService,
+	}
+}
+
+func (s *TrialService) Enabled() bool {
+	return s.config.TrialDuration > 0
+}
+
+// Eligible reports whether the user may still start a trial.
+func (s *TrialService) Eligible(userID int64) (bool, error) {
+	if !s.Enabled() {
+		return false, nil
+	}
+
+	trial, err := s.db.GetTrial(userID)
+	if err != nil {
+		return false, err
+	}
+	return trial == nil, nil
+}
+
+// ActiveTrial returns the user's running trial, or nil.
+func (s *TrialService) ActiveTrial(userID int64) (*models.Trial, error) {
+	trial, err := s.db.GetTrial(userID)
+	if err != nil || trial == nil || !trial.Active() {
+		return nil, err
+	}
+	return trial, nil
+}
+
+// Start provisions a trial user. The trial row is written first so that two
+// concurrent requests cannot both get a trial.
+func (s *TrialService) Start(userID int64, username string) (*models.Trial, string, string, error) {
+	if !s.Enabled() {
+		return nil, "", "", fmt.Errorf("trials are disabled")
+	}
+
+	now := time.Now()
+	trial := &models.Trial{
+		UserID:       userID,
+		StartedAt:    now,
+		ExpiresAt:    now.Add(s.config.TrialDuration),
+		TrafficLimit: int64(s.config.TrialTrafficMB) << 20,
+	}
+
+	if err := s.db.CreateTrial(trial); err != nil {
+		return nil, "", "", fmt.Errorf("user %d already had a trial: %v", userID, err)
+	}
+
+	userUUID, vlessURL, err := s.userService.CreateTrialUser(userID, username)
+	if err != nil {
+		if deleteErr := s.db.DeleteTrial(userID); deleteErr != nil {
+			log.Printf("Error rolling back trial of user %d: %v", userID, deleteErr)
+		}
+		return nil, "", "", err
+	}
+
+	return trial, userUUID, vlessURL, nil
+}
+
+// Convert records that a trial user has subscribed to the channel.
+func (s *TrialService) Convert(userID int64) error {
+	return s.db.ConvertTrial(userID)
+}
+
+func (s *TrialService) Stats() (*models.TrialStats, error) {
+	return s.db.GetTrialStats()
+}
+
+func (s *TrialService) StartChecker() {
+	if !s.Enabled() {
+		return
+	}
+
+	go func() {
+		for {
+			time.Sleep(trialCheckInterval)
+			s.checkAll()
+		}
+	}()
+}
+
+func (s *TrialService) checkAll() {
+	trials, err := s.db.GetActiveTrials()
+	if err != nil {
+		log.Printf("Error querying trials: %v", err)
+		return
+	}
+
+	for _, trial := range trials {
+		s.updateTraffic(trial)
+
+		var reason string
+		switch {
+		case time.Now().After(trial.ExpiresAt):
+			reason = "expired"
+		case trial.TrafficUsed >= trial.TrafficLimit:
+			reason = "traffic limit reached"
+		default:
+			continue
+		}
+
+		s.end(trial, reason)
+	}
+}
+
+// updateTraffic adds what the user's servers counted since the last check.
+// Xray resets its counters on restart, so a counter lower than before
+// starts a new segment.
+func (s *TrialService) updateTraffic(trial *models.Trial) {
+	counter, err := s.userService.Traffic(trial.UserID)
+	if err != nil {
+		log.Printf("Error querying traffic of trial user %d: %v", trial.UserID, err)
+		return
+	}
+
+	if counter >= trial.TrafficCounter {
+		trial.TrafficUsed += counter - trial.TrafficCounter
+	} else {
+		trial.TrafficUsed += counter
+	}
+	trial.TrafficCounter = counter
+
+	if err := s.db.UpdateTrialTraffic(trial.UserID, trial.TrafficUsed, trial.TrafficCounter); err != nil {
+		log.Printf("Error saving traffic of trial user %d: %v", trial.UserID, err)
+	}
+}
+
+// end removes the trial user. A user who got a plan meanwhile keeps their
+// access; their trial is converted instead.
+func (s *TrialService) end(trial *models.Trial, reason string) {
+	user, err := s.db.GetUser(trial.UserID)
+	if err != nil {
+		log.Printf("Error loading trial user %d: %v", trial.UserID, err)
+		return
+	}
+	if user != nil && user.HasPlanAccess(time.Now()) {
+		if err := s.db.ConvertTrial(trial.UserID); err != nil {
+			log.Printf("Error converting trial of user %d: %v", trial.UserID, err)
+		}
+		return
+	}
+
+	if err := s.userService.RemoveUser(trial.UserID, models.SystemActor, "trial "+reason); err != nil {
+		log.Printf("Error removing trial user %d: %v", trial.UserID, err)
+		return
+	}
+
+	if err := s.db.EndTrial(trial.UserID, reason); err != nil {
+		log.Printf("Error ending trial of user %d: %v", trial.UserID, err)
+	}
+
+	log.Printf("Trial of user %d ended: %s", trial.UserID, reason)
+
+	msg := tgbotapi.NewMessage(trial.UserID, fmt.Sprintf(messages.TrialEndedMessage, s.config.ChannelUsername))
+	if _, err := s.bot.Send(msg); err != nil {
+		log.Printf("Error notifying user %d about trial end: %v", trial.UserID, err)
+	}
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"xray-telegram-bot/models"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrUserSuspended is returned when a suspended user asks for a config.
+	ErrUserSuspended = errors.New("user is suspended")
+	// ErrUserBanned is returned when unsuspending a user who is banned.
+	ErrUserBanned = errors.New("user is banned")
+	// ErrNoTrafficQuota is returned when adding traffic to a user whose
+	// traffic is not limited.
+	ErrNoTrafficQuota = errors.New("user has no traffic quota")
+)
+
+// RotateUUID replaces the user's UUID, invalidating every link shared so far,
+// and returns the new UUID and VLESS link.
+func (s *UserService) RotateUUID(userID int64, actor models.Actor, reason string) (string, string, error) {
+	user, err := s.db.GetUser(userID)
+	if err != nil {
+		return "", "", err
+	}
+	if user == nil {
+		return "", "", fmt.Errorf("user %d is not provisioned", userID)
+	}
+
+	email := userEmail(userID)
+	newUUID := uuid.New().String()
+
+	if _, err := s.xrayRemove(userID); err != nil {
+		log.Printf("Error removing old UUID of user %d from Xray: %v", userID, err)
+	}
+
+	rotated := *user
+	rotated.UUID = newUUID
+	status, xrayErr := s.xrayAdd(&rotated)
+	s.recordEvent(userID, actor, models.EventRotated, reason, xrayResult(status, xrayErr))
+	if xrayErr != nil {
+		return "", "", fmt.Errorf("failed to add rotated user to Xray: %v", xrayErr)
+	}
+
+	if err := s.db.UpdateUserUUID(userID, newUUID); err != nil {
+		return "", "", err
+	}
+
+	return newUUID, s.configLink(user, newUUID, email), nil
+}
+
+// SuspendUser revokes Xray access but keeps the user row, so that
+// UnsuspendUser can restore the same UUID.
+func (s *UserService) SuspendUser(userID int64, actor models.Actor, reason string) error {
+	status, xrayErr := s.xrayRemove(userID)
+	if xrayErr != nil {
+		log.Printf("Error removing suspended user %d from Xray: %v", userID, xrayErr)
+	}
+	s.recordEvent(userID, actor, models.EventSuspended, reason, xrayResult(status, xrayErr))
+
+	return s.db.UpdateUserStatus(userID, models.UserStatusSuspended)
+}
+
+// UnsuspendUser restores a suspended user. Banned users are suspended too,
+// their ban is only lifted by UnbanUser.
+func (s *UserService) UnsuspendUser(userID int64, actor models.Actor, reason string) error {
+	user, err := s.db.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.Status != models.UserStatusSuspended {
+		return fmt.Errorf("user %d is not suspended", userID)
+	}
+
+	ban, err := s.db.GetBan(userID)
+	if err != nil {
+		return err
+	}
+	if ban != nil {
+		return ErrUserBanned
+	}
+
+	status, xrayErr := s.xrayAdd(user)
+	s.recordEvent(userID, actor, models.EventUnsuspended, reason, xrayResult(status, xrayErr))
+	if xrayErr != nil {
+		return fmt.Errorf("failed to restore user in Xray: %v", xrayErr)
+	}
+
+	return s.db.UpdateUserStatus(userID, models.UserStatusActive)
+}
+
+func (s *UserService) SetIPLimit(userID int64, limit int) error {
+	if err := s.db.UpdateUserIPLimit(userID, limit); err != nil {
+		return err
+	}
+	s.syncLimits(userID)
+	return nil
+}
+
+// CreateTrialUser provisions a user flagged as a trial user.
+func (s *UserService) CreateTrialUser(userID int64, username string) (string, string, error) {
+	user, err := s.db.GetUser(userID)
+	if err != nil {
+		return "", "", err
+	}
+	if user != nil {
+		return "", "", fmt.Errorf("user %d is already provisioned", userID)
+	}
+
+	return s.createUser(&models.User{ID: userID, Username: username, IsTrial: true}, "trial")
+}
+
+// AddTraffic raises the traffic quota of the user's plan by extra bytes and
+// restores a user who had used it up. Users without a quota have nothing to
+// raise.
+func (s *UserService) AddTraffic(userID int64, extra int64) error {
+	user, err := s.db.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil || !user.HasPlanAccess(time.Now()) || user.TrafficLimit == 0 {
+		return ErrNoTrafficQuota
+	}
+
+	if err := s.db.UpdateUserTrafficLimit(userID, user.TrafficLimit+extra); err != nil {
+		return err
+	}
+	s.syncLimits(userID)
+
+	user.TrafficLimit += extra
+	if user.Status == models.UserStatusExpired && !user.QuotaExhausted() {
+		return s.RenewUser(userID, models.SystemActor, "traffic added")
+	}
+	return nil
+}
+
+// AddIPLimit raises the user's concurrent IP limit on top of the default.
+func (s *UserService) AddIPLimit(userID int64, extra int) error {
+	user, err := s.db.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("user %d is not provisioned", userID)
+	}
+
+	limit := user.IPLimit
+	if limit <= 0 {
+		limit = s.config.MaxConcurrentIPs
+	}
+
+	if err := s.db.UpdateUserIPLimit(userID, limit+extra); err != nil {
+		return err
+	}
+	s.syncLimits(userID)
+	return nil
+}
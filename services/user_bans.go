@@ -0,0 +1,152 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"xray-telegram-bot/models"
+)
+
+func (s *UserService) GetBan(userID int64) (*models.Ban, error) {
+	return s.db.GetBan(userID)
+}
+
+func (s *UserService) GetBans() ([]*models.Ban, error) {
+	return s.db.GetBans()
+}
+
+func (s *UserService) GetBanHistory(userID int64) ([]*models.BanRecord, error) {
+	return s.db.GetBanHistory(userID)
+}
+
+// BanUser revokes the user's Xray access and records the ban. The user row
+// is kept, marked as suspended, so the UUID survives until the ban is lifted.
+func (s *UserService) BanUser(adminID, userID int64, reason string, expiresAt *time.Time) error {
+	ban := &models.Ban{
+		UserID:    userID,
+		Reason:    reason,
+		AdminID:   adminID,
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+
+	if existing, err := s.db.GetBan(userID); err != nil {
+		return err
+	} else if existing != nil {
+		ban.UUID = existing.UUID
+	}
+
+	user, err := s.db.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user != nil {
+		ban.UUID = user.UUID
+	}
+
+	if err := s.db.CreateBan(ban); err != nil {
+		return err
+	}
+
+	actor := models.AdminActor(adminID)
+	if user == nil {
+		s.recordEvent(userID, actor, models.EventBanned, reason, "")
+		return nil
+	}
+
+	status, xrayErr := s.xrayRemove(userID)
+	if xrayErr != nil {
+		log.Printf("Error removing banned user %d from Xray: %v", userID, xrayErr)
+	}
+	s.recordEvent(userID, actor, models.EventBanned, reason, xrayResult(status, xrayErr))
+
+	return s.db.UpdateUserStatus(userID, models.UserStatusSuspended)
+}
+
+// UnbanUser lifts the ban and gives the user back the UUID they had before.
+// The ban is only deleted once the user is back in Xray, so that a failed
+// restore is retried by the next unban.
+func (s *UserService) UnbanUser(adminID, userID int64, reason string) error {
+	ban, err := s.db.GetBan(userID)
+	if err != nil {
+		return err
+	}
+	if ban == nil {
+		return fmt.Errorf("user %d is not banned", userID)
+	}
+
+	actor := models.AdminActor(adminID)
+	if ban.UUID == "" {
+		if err := s.db.DeleteBan(userID, adminID, reason); err != nil {
+			return err
+		}
+		s.recordEvent(userID, actor, models.EventUnbanned, reason, "")
+		return nil
+	}
+
+	user, err := s.db.GetUser(userID)
+	if err != nil {
+		return err
+	}
+
+	// Users revoked before the ban lost their placements with their row.
+	restored := &models.User{ID: userID}
+	if user == nil {
+		if err := s.placeUser(restored); err != nil {
+			return err
+		}
+	} else {
+		*restored = *user
+	}
+	restored.UUID = ban.UUID
+
+	status, xrayErr := s.xrayAdd(restored)
+	if xrayErr != nil {
+		s.recordEvent(userID, actor, models.EventUnbanned, reason, xrayResult(status, xrayErr))
+		if user == nil {
+			if removeErr := s.db.RemoveUserServer(userID, restored.ServerID); removeErr != nil {
+				log.Printf("Error removing placement of user %d: %v", userID, removeErr)
+			}
+		}
+		return fmt.Errorf("failed to restore user in Xray: %v", xrayErr)
+	}
+
+	if err := s.db.DeleteBan(userID, adminID, reason); err != nil {
+		return err
+	}
+	s.recordEvent(userID, actor, models.EventUnbanned, reason, xrayResult(status, nil))
+
+	if user == nil {
+		return s.db.CreateUser(&models.User{
+			ID:        userID,
+			UUID:      ban.UUID,
+			CreatedAt: time.Now(),
+			Status:    models.UserStatusActive,
+			ServerID:  restored.ServerID,
+		})
+	}
+
+	return s.db.UpdateUserStatus(userID, models.UserStatusActive)
+}
+
+// LiftExpiredBans unbans every user whose ban has run out.
+func (s *UserService) LiftExpiredBans() {
+	bans, err := s.db.GetBans()
+	if err != nil {
+		log.Printf("Error querying bans: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, ban := range bans {
+		if ban.ExpiresAt == nil || ban.ExpiresAt.After(now) {
+			continue
+		}
+
+		if err := s.UnbanUser(0, ban.UserID, "expired"); err != nil {
+			log.Printf("Error lifting expired ban of user %d: %v", ban.UserID, err)
+		} else {
+			log.Printf("Expired ban of user %d lifted", ban.UserID)
+		}
+	}
+}
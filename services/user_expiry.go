@@ -0,0 +1,133 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+	"xray-telegram-bot/models"
+)
+
+// ExpireUser takes a user whose access ended out of Xray. The row and UUID
+// are kept, so that RenewUser restores the same config.
+func (s *UserService) ExpireUser(userID int64, reason string) error {
+	status, xrayErr := s.xrayRemove(userID)
+	if xrayErr != nil {
+		log.Printf("Error removing expired user %d from Xray: %v", userID, xrayErr)
+	}
+	s.recordEvent(userID, models.SystemActor, models.EventExpired, reason, xrayResult(status, xrayErr))
+
+	return s.db.UpdateUserStatus(userID, models.UserStatusExpired)
+}
+
+// UpdateTrafficUsed adds what the user's servers counted since the last
+// check to the traffic used of their plan. As for trials, a counter lower
+// than before starts a new segment.
+func (s *UserService) UpdateTrafficUsed(user *models.User) error {
+	counter, err := s.Traffic(user.ID)
+	if err != nil {
+		return err
+	}
+
+	if counter >= user.TrafficCounter {
+		user.TrafficUsed += counter - user.TrafficCounter
+	} else {
+		user.TrafficUsed += counter
+	}
+	user.TrafficCounter = counter
+
+	return s.db.UpdateUserTraffic(user.ID, user.TrafficUsed, user.TrafficCounter)
+}
+
+// SuspendForQuota takes a user who used up the plan's traffic out of Xray
+// the way ExpireUser does. Paying again or adding traffic restores them.
+func (s *UserService) SuspendForQuota(user *models.User) error {
+	status, xrayErr := s.xrayRemove(user.ID)
+	if xrayErr != nil {
+		log.Printf("Error removing user %d over quota from Xray: %v", user.ID, xrayErr)
+	}
+	reason := fmt.Sprintf("used %d of %d bytes", user.TrafficUsed, user.TrafficLimit)
+	s.recordEvent(user.ID, models.SystemActor, models.EventQuotaSuspended, reason, xrayResult(status, xrayErr))
+
+	return s.db.UpdateUserStatus(user.ID, models.UserStatusExpired)
+}
+
+// RenewUser returns an expired user to Xray.
+func (s *UserService) RenewUser(userID int64, actor models.Actor, reason string) error {
+	user, err := s.db.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.Status != models.UserStatusExpired {
+		return fmt.Errorf("user %d is not expired", userID)
+	}
+
+	status, xrayErr := s.xrayAdd(user)
+	s.recordEvent(userID, actor, models.EventRenewed, reason, xrayResult(status, xrayErr))
+	if xrayErr != nil {
+		return fmt.Errorf("failed to restore user in Xray: %v", xrayErr)
+	}
+
+	return s.db.UpdateUserStatus(userID, models.UserStatusActive)
+}
+
+// EndPlan drops the time-limited access of a user who stays entitled
+// otherwise, e.g. through the channel.
+func (s *UserService) EndPlan(userID int64, reason string) error {
+	if err := s.db.ClearUserPlan(userID); err != nil {
+		return err
+	}
+	s.syncLimits(userID)
+
+	s.recordEvent(userID, models.SystemActor, models.EventPlanEnded, reason, "")
+	return nil
+}
+
+// AdjustExpiry moves the user's access end by delta. Extending starts from
+// now when the access already ended; shortening needs an access end to
+// shorten. An expired user whose access runs again is restored in Xray,
+// unless their traffic quota is used up, and a trial user's trial is
+// converted; taking access away is left to the caller.
+func (s *UserService) AdjustExpiry(userID int64, actor models.Actor, delta time.Duration) (time.Time, error) {
+	user, err := s.db.GetUser(userID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if user == nil {
+		return time.Time{}, fmt.Errorf("user %d is not provisioned", userID)
+	}
+
+	now := time.Now()
+	var expiresAt time.Time
+	switch {
+	case delta < 0 && user.ExpiresAt == nil:
+		return time.Time{}, fmt.Errorf("user %d has no expiry date", userID)
+	case delta < 0 || user.HasPlanAccess(now):
+		expiresAt = user.ExpiresAt.Add(delta)
+	default:
+		expiresAt = now.Add(delta)
+	}
+
+	if err := s.db.UpdateUserExpiry(userID, expiresAt); err != nil {
+		return time.Time{}, err
+	}
+	s.recordEvent(userID, actor, models.EventExpiryChanged, "until "+expiresAt.Format(time.DateTime), "")
+	s.syncLimits(userID)
+
+	if user.IsTrial && expiresAt.After(now) {
+		if err := s.db.ConvertTrial(userID); err != nil {
+			log.Printf("Error converting trial of user %d: %v", userID, err)
+		}
+	}
+
+	if user.Status == models.UserStatusExpired && expiresAt.After(now) && !user.QuotaExhausted() {
+		if err := s.RenewUser(userID, actor, "access extended"); err != nil {
+			return expiresAt, err
+		}
+	}
+
+	return expiresAt, nil
+}
+
+func (s *UserService) SetExpiryReminder(userID int64, days int) error {
+	return s.db.UpdateUserExpiryReminder(userID, days)
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+	"xray-telegram-bot/importer"
+	"xray-telegram-bot/models"
+	"xray-telegram-bot/xray"
+)
+
+// ImportEntry is a client of an import and what becomes of it.
+type ImportEntry struct {
+	Client *importer.Client
+	UserID int64
+	// Skip tells why the client is not imported, empty if it is.
+	Skip string
+}
+
+// PlanImport maps clients to Telegram users by the mapping first, then by
+// the Telegram ID the panel knows, then by emails that are the bot's own or
+// a bare Telegram ID. Unmapped clients and clients that clash with existing
+// users or with each other are skipped. Nothing is changed.
+func (s *UserService) PlanImport(clients []*importer.Client, mapping importer.Mapping) ([]*ImportEntry, error) {
+	userIDs := make(map[int64]string)
+	uuids := make(map[string]string)
+
+	entries := make([]*ImportEntry, 0, len(clients))
+	for _, client := range clients {
+		entry := &ImportEntry{Client: client, UserID: importUserID(client, mapping)}
+		entries = append(entries, entry)
+
+		if entry.UserID == 0 {
+			entry.Skip = "unmapped"
+			continue
+		}
+		if other, ok := userIDs[entry.UserID]; ok {
+			entry.Skip = fmt.Sprintf("user %d already taken by %s", entry.UserID, other)
+			continue
+		}
+		if other, ok := uuids[client.UUID]; ok {
+			entry.Skip = fmt.Sprintf("UUID already taken by %s", other)
+			continue
+		}
+
+		user, err := s.db.GetUser(entry.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil {
+			entry.Skip = fmt.Sprintf("user %d exists", entry.UserID)
+			continue
+		}
+		owner, err := s.db.GetUserByUUID(client.UUID)
+		if err != nil {
+			return nil, err
+		}
+		if owner != nil {
+			entry.Skip = fmt.Sprintf("UUID belongs to user %d", owner.ID)
+			continue
+		}
+
+		userIDs[entry.UserID] = client.Key()
+		uuids[client.UUID] = client.Key()
+	}
+	return entries, nil
+}
+
+func importUserID(client *importer.Client, mapping importer.Mapping) int64 {
+	if userID, ok := mapping.Lookup(client); ok {
+		return userID
+	}
+	if client.TelegramID != 0 {
+		return client.TelegramID
+	}
+	if userID, ok := userIDFromEmail(client.Email); ok {
+		return userID
+	}
+	if userID, err := strconv.ParseInt(client.Email, 10, 64); err == nil && userID > 0 {
+		return userID
+	}
+	return 0
+}
+
+// ImportUser creates the user of a planned entry with the client's UUID, so
+// that existing links keep working. The user is placed on server, or by the
+// placement policy when server is nil. When the client lives in that
+// server's own config, its old entry is replaced by the bot's. The status
+// is empty for users created without access.
+func (s *UserService) ImportUser(entry *ImportEntry, server *models.Server, replace bool) (xray.Status, error) {
+	client := entry.Client
+	user := &models.User{
+		ID:           entry.UserID,
+		UUID:         client.UUID,
+		CreatedAt:    time.Now(),
+		Status:       models.UserStatusActive,
+		IPLimit:      client.IPLimit,
+		ExpiresAt:    client.ExpiresAt,
+		TrafficLimit: client.TrafficLimit,
+	}
+	switch {
+	case !client.Enabled:
+		user.Status = models.UserStatusSuspended
+	case user.ExpiresAt != nil && !user.HasPlanAccess(user.CreatedAt):
+		user.Status = models.UserStatusExpired
+	}
+
+	if server != nil {
+		user.ServerID = server.ID
+		if err := s.db.AddUserServer(user.ID, server.ID); err != nil {
+			return "", err
+		}
+	} else if err := s.placeUser(user); err != nil {
+		return "", err
+	}
+
+	// Xray drops a UUID together with the email it was added with, so the
+	// old entry goes first. It is put back if the bot's entry fails.
+	removed := false
+	if replace && server != nil && client.Email != userEmail(user.ID) {
+		if _, err := s.servers.Backend(server).RemoveUser(client.Email); err != nil {
+			log.Printf("Error removing client %s from Xray: %v", client.Email, err)
+		} else {
+			removed = client.Enabled
+		}
+	}
+	restore := func() {
+		if !removed {
+			return
+		}
+		if _, err := s.servers.Backend(server).AddUser(user, client.Email); err != nil {
+			log.Printf("Error restoring client %s in Xray: %v", client.Email, err)
+		}
+	}
+
+	var status xray.Status
+	var xrayErr error
+	if user.Status == models.UserStatusActive {
+		status, xrayErr = s.xrayAdd(user)
+	}
+	reason := client.Source + ", " + client.Key()
+	if xrayErr != nil {
+		s.recordEvent(user.ID, models.SystemActor, models.EventImported, reason, xrayResult(status, xrayErr))
+		if err := s.db.RemoveUserServer(user.ID, user.ServerID); err != nil {
+			log.Printf("Error removing placement of user %d: %v", user.ID, err)
+		}
+		restore()
+		return status, fmt.Errorf("failed to add user to Xray: %v", xrayErr)
+	}
+
+	if err := s.db.CreateUser(user); err != nil {
+		if _, removeErr := s.xrayRemove(user.ID); removeErr != nil {
+			log.Printf("Error cleaning up user after database insert failure: %v", removeErr)
+		}
+		if removeErr := s.db.RemoveUserServer(user.ID, user.ServerID); removeErr != nil {
+			log.Printf("Error removing placement of user %d: %v", user.ID, removeErr)
+		}
+		restore()
+		return status, err
+	}
+
+	result := ""
+	if user.Status == models.UserStatusActive {
+		result = xrayResult(status, nil)
+	}
+	s.recordEvent(user.ID, models.SystemActor, models.EventImported, reason, result)
+	return status, nil
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+	"xray-telegram-bot/models"
+)
+
+// Migration reports what MigrateUsers did. Moved are the active users who
+// need a new config; users without access are moved silently.
+type Migration struct {
+	From, To   *models.Server
+	Moved      []int64
+	Silent     int
+	Skipped    int
+	DrainUntil time.Time
+}
+
+// MigrateUsers moves up to limit users, or all when limit is zero, whose
+// location is from to the server to. Active users are added on to first
+// and stay on from until the drain period ends, so that their old config
+// keeps working until they fetch a new one. Users the server to does not
+// allow or has no room for are skipped.
+func (s *UserService) MigrateUsers(from, to *models.Server, limit int, drain time.Duration, actor models.Actor) (*Migration, error) {
+	if from.ID == to.ID {
+		return nil, fmt.Errorf("cannot migrate %s to itself", from.Name)
+	}
+
+	userIDs, err := s.db.GetServerUserIDs(from.ID)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := s.db.CountServerUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	migration := &Migration{From: from, To: to, DrainUntil: time.Now().Add(drain)}
+	reason := "migrated from " + from.Name
+	routingChanged := false
+
+	for _, userID := range userIDs {
+		if limit > 0 && len(migration.Moved)+migration.Silent == limit {
+			break
+		}
+
+		user, err := s.db.GetUser(userID)
+		if err != nil {
+			return migration, err
+		}
+		if user == nil || !s.servers.Allowed(user, to) || to.Full(counts[to.ID]) {
+			migration.Skipped++
+			continue
+		}
+
+		if user.Status != models.UserStatusActive {
+			if err := s.db.MoveUserServer(userID, from.ID, to.ID, nil); err != nil {
+				return migration, err
+			}
+			s.recordEvent(userID, actor, models.EventLocationChanged, reason, "")
+			migration.Silent++
+			counts[to.ID]++
+			continue
+		}
+
+		// Users still draining from to, e.g. after an earlier migration
+		// the other way, are on it already.
+		placed, err := s.db.GetUserServers(userID)
+		if err != nil {
+			return migration, err
+		}
+		if slices.Contains(placed, to.ID) {
+			s.recordEvent(userID, actor, models.EventLocationChanged, reason, "")
+		} else {
+			status, xrayErr := s.servers.Backend(to).AddUser(user, userEmail(userID))
+			s.recordEvent(userID, actor, models.EventLocationChanged, reason, xrayResult(status, xrayErr))
+			if xrayErr != nil {
+				log.Printf("Error adding user %d to %s: %v", userID, to.Name, xrayErr)
+				migration.Skipped++
+				continue
+			}
+		}
+
+		drainUntil := &migration.DrainUntil
+		if drain <= 0 {
+			drainUntil = nil
+			if _, err := s.servers.Backend(from).RemoveUser(userEmail(userID)); err != nil {
+				log.Printf("Error removing user %d from %s: %v", userID, from.Name, err)
+			}
+		}
+		if err := s.db.MoveUserServer(userID, from.ID, to.ID, drainUntil); err != nil {
+			return migration, err
+		}
+
+		migration.Moved = append(migration.Moved, userID)
+		counts[to.ID]++
+		if len(s.RoutingProfile(user).Rules) > 0 {
+			routingChanged = true
+		}
+	}
+
+	if routingChanged {
+		if err := s.SyncRoutingProfiles(); err != nil {
+			log.Printf("Error applying routing profiles after migration: %v", err)
+		}
+	}
+
+	return migration, nil
+}
+
+// StartDrainChecker removes migrated users from the servers they left once
+// the drain period is over.
+func (s *UserService) StartDrainChecker() {
+	go func() {
+		for {
+			s.finishDrains()
+			time.Sleep(5 * time.Minute)
+		}
+	}()
+}
+
+func (s *UserService) finishDrains() {
+	placements, err := s.db.GetDrainingPlacements()
+	if err != nil {
+		log.Printf("Error querying draining placements: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, placement := range placements {
+		if placement.DrainUntil.After(now) {
+			continue
+		}
+
+		server, err := s.db.GetServer(placement.ServerID)
+		if err != nil {
+			log.Printf("Error loading server %d: %v", placement.ServerID, err)
+			continue
+		}
+
+		// A failed removal is retried while the server is in service; a
+		// disabled server may be gone already.
+		if server != nil {
+			_, err := s.servers.Backend(server).RemoveUser(userEmail(placement.UserID))
+			if err != nil && server.Enabled {
+				log.Printf("Error removing user %d from %s after drain: %v", placement.UserID, server.Name, err)
+				continue
+			}
+		}
+
+		if err := s.db.RemoveUserServer(placement.UserID, placement.ServerID); err != nil {
+			log.Printf("Error removing placement of user %d on server %d: %v", placement.UserID, placement.ServerID, err)
+		}
+	}
+}
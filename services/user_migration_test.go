@@ -0,0 +1,208 @@
+package services
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/database"
+	"xray-telegram-bot/models"
+)
+
+// newMigrationTest creates n active users on the local server and a remote
+// server to migrate them to. Calls to the fake xray binary are logged to
+// the returned file.
+func newMigrationTest(t *testing.T, cfg *config.Config, n int) (*database.Database, *UserService, *models.Server, *models.Server, string) {
+	db := newTestDB(t)
+	users := newTestUserService(t, db, cfg)
+	calls := fakeXray(t)
+
+	for userID := int64(1); userID <= int64(n); userID++ {
+		if _, _, err := users.GetOrCreateVlessConfig(userID, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	from, err := users.servers.ServerByName(cfg.ServerName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := &models.Server{Name: "remote", Domain: "remote.example.com", Port: 443, APIAddress: "203.0.113.1:10085", InboundTag: "vless-in"}
+	if err := users.servers.AddServer(to); err != nil {
+		t.Fatal(err)
+	}
+	xrayCalls(t, calls)
+	return db, users, from, to, calls
+}
+
+var xrayCallPattern = regexp.MustCompile(`inbounduser (add|remove) --server=(\S+) .*user_(\d+)@myserver`)
+
+// xrayUserCalls returns the users added or removed on the server at
+// address, in the order of the calls.
+func xrayUserCalls(log, action, address string) []string {
+	var userIDs []string
+	for _, match := range xrayCallPattern.FindAllStringSubmatch(log, -1) {
+		if match[1] == action && match[2] == address {
+			userIDs = append(userIDs, match[3])
+		}
+	}
+	return userIDs
+}
+
+func TestMigrateUsers(t *testing.T) {
+	cfg := testConfig(t)
+	db, users, from, to, calls := newMigrationTest(t, cfg, 3)
+	actor := models.AdminActor(1)
+
+	// Migrated users stay on from until the drain period ends.
+	migration, err := users.MigrateUsers(from, to, 2, time.Hour, actor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(migration.Moved, []int64{1, 2}) || migration.Silent != 0 {
+		t.Fatalf("limit not applied: %+v", migration)
+	}
+	log := xrayCalls(t, calls)
+	if added := xrayUserCalls(log, "add", to.APIAddress); !slices.Equal(added, []string{"1", "2"}) {
+		t.Fatalf("added %v to the target server", added)
+	}
+	if removed := xrayUserCalls(log, "remove", cfg.XrayAPIAddress); len(removed) != 0 {
+		t.Fatalf("removed %v before the drain ended", removed)
+	}
+	draining, err := db.GetDrainingServers(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !draining[from.ID] || draining[to.ID] {
+		t.Fatalf("draining servers of user 1: %v", draining)
+	}
+	if user, _ := db.GetUser(3); user.ServerID != from.ID {
+		t.Fatal("user beyond the limit was migrated")
+	}
+
+	// Without a drain period users are removed from from right away.
+	migration, err = users.MigrateUsers(from, to, 0, 0, actor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(migration.Moved, []int64{3}) {
+		t.Fatalf("moved %v", migration.Moved)
+	}
+	log = xrayCalls(t, calls)
+	if removed := xrayUserCalls(log, "remove", cfg.XrayAPIAddress); !slices.Equal(removed, []string{"3"}) {
+		t.Fatalf("removed %v from the old server", removed)
+	}
+	if placed, _ := db.GetUserServers(3); !slices.Equal(placed, []int64{to.ID}) {
+		t.Fatalf("user 3 placed on %v", placed)
+	}
+}
+
+func TestMigrateUsersSkips(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Plans = []config.Plan{{Name: "basic", Days: 30, Servers: []string{cfg.ServerName}}}
+	db, users, from, to, calls := newMigrationTest(t, cfg, 3)
+
+	// User 1 is on a plan limited to the local server, the target server
+	// has room for one more user.
+	now := time.Now()
+	payment := &models.Payment{UserID: 1, Provider: "stars", Plan: "basic", Days: 30, ChargeID: "charge", PaidUntil: now.AddDate(0, 0, 30), CreatedAt: now}
+	if _, err := db.ApplyPayment(payment, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.servers.SetCapacity(to, 1); err != nil {
+		t.Fatal(err)
+	}
+	to.Capacity = 1
+
+	migration, err := users.MigrateUsers(from, to, 0, time.Hour, models.AdminActor(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(migration.Moved, []int64{2}) || migration.Skipped != 2 {
+		t.Fatalf("migration: %+v", migration)
+	}
+	if added := xrayUserCalls(xrayCalls(t, calls), "add", to.APIAddress); !slices.Equal(added, []string{"2"}) {
+		t.Fatalf("added %v to the target server", added)
+	}
+	for _, userID := range []int64{1, 3} {
+		if placed, _ := db.GetUserServers(userID); !slices.Equal(placed, []int64{from.ID}) {
+			t.Fatalf("skipped user %d placed on %v", userID, placed)
+		}
+	}
+}
+
+func TestMigrateUsersAlreadyOnTarget(t *testing.T) {
+	cfg := testConfig(t)
+	db, users, from, to, calls := newMigrationTest(t, cfg, 1)
+	actor := models.AdminActor(1)
+
+	if _, err := users.MigrateUsers(from, to, 0, time.Hour, actor); err != nil {
+		t.Fatal(err)
+	}
+	xrayCalls(t, calls)
+
+	// Migrating back while user 1 still drains from the local server.
+	migration, err := users.MigrateUsers(to, from, 0, time.Hour, actor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(migration.Moved, []int64{1}) {
+		t.Fatalf("moved %v", migration.Moved)
+	}
+	if added := xrayUserCalls(xrayCalls(t, calls), "add", cfg.XrayAPIAddress); len(added) != 0 {
+		t.Fatalf("added %v again", added)
+	}
+	draining, err := db.GetDrainingServers(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if draining[from.ID] || !draining[to.ID] {
+		t.Fatalf("draining servers of user 1: %v", draining)
+	}
+}
+
+func TestFinishDrains(t *testing.T) {
+	cfg := testConfig(t)
+	db, users, from, _, calls := newMigrationTest(t, cfg, 2)
+
+	// An agent that cannot be reached.
+	unreachable := httptest.NewServer(nil)
+	unreachable.Close()
+	agentServer := &models.Server{Name: "agent", Domain: "agent.example.com", Port: 443, AgentURL: unreachable.URL, AgentSecret: "secret"}
+	if err := users.servers.AddServer(agentServer); err != nil {
+		t.Fatal(err)
+	}
+
+	// User 1 drained from the local server, user 2 from the agent's.
+	drained := time.Now().Add(-time.Minute)
+	if err := db.AddUserServer(2, agentServer.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.MoveUserServer(1, from.ID, agentServer.ID, &drained); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.MoveUserServer(2, agentServer.ID, from.ID, &drained); err != nil {
+		t.Fatal(err)
+	}
+
+	users.finishDrains()
+	if removed := xrayUserCalls(xrayCalls(t, calls), "remove", cfg.XrayAPIAddress); !slices.Equal(removed, []string{"1"}) {
+		t.Fatalf("removed %v from the local server", removed)
+	}
+	if placed, _ := db.GetUserServers(1); !slices.Equal(placed, []int64{agentServer.ID}) {
+		t.Fatalf("user 1 placed on %v", placed)
+	}
+	if placed, _ := db.GetUserServers(2); !slices.Contains(placed, agentServer.ID) {
+		t.Fatal("placement dropped although the removal failed on an enabled server")
+	}
+
+	// Once the server is disabled the placement is given up.
+	if err := users.servers.SetEnabled(agentServer, false); err != nil {
+		t.Fatal(err)
+	}
+	users.finishDrains()
+	if placed, _ := db.GetUserServers(2); !slices.Equal(placed, []int64{from.ID}) {
+		t.Fatalf("user 2 placed on %v", placed)
+	}
+}
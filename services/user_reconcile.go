@@ -0,0 +1,101 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"xray-telegram-bot/models"
+)
+
+// Reconcile corrects drift between the database and the servers whose
+// backends can list their users. Active users missing on a server they are
+// placed on are added, and bot users a server has without an active
+// placement there are removed. Every correction is recorded as a
+// reconciled event.
+func (s *UserService) Reconcile() error {
+	servers, err := s.servers.Servers()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, server := range servers {
+		if err := s.reconcileServer(server); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", server.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *UserService) reconcileServer(server *models.Server) error {
+	backend := s.servers.Backend(server)
+	lister, ok := backend.(UserLister)
+	if !ok {
+		return nil
+	}
+	emails, err := lister.UserEmails()
+	if err != nil {
+		return err
+	}
+	present := make(map[string]bool, len(emails))
+	for _, email := range emails {
+		present[email] = true
+	}
+
+	users, err := s.db.GetAllUsers()
+	if err != nil {
+		return err
+	}
+	placements, err := s.db.GetAllUserServers()
+	if err != nil {
+		return err
+	}
+
+	expected := make(map[int64]bool)
+	for _, user := range users {
+		if user.Status != models.UserStatusActive || !slices.Contains(placements[user.ID], server.ID) {
+			continue
+		}
+		expected[user.ID] = true
+
+		email := userEmail(user.ID)
+		if present[email] {
+			continue
+		}
+		// The user may have lost access since the users were loaded.
+		if user, err = s.db.GetUser(user.ID); err != nil || user == nil || user.Status != models.UserStatusActive {
+			continue
+		}
+		status, xrayErr := backend.AddUser(user, email)
+		s.recordEvent(user.ID, models.SystemActor, models.EventReconciled, "missing on "+server.Name, xrayResult(status, xrayErr))
+		if xrayErr != nil {
+			log.Printf("Error restoring user %d on %s: %v", user.ID, server.Name, xrayErr)
+		} else {
+			log.Printf("User %d was missing on %s, added back", user.ID, server.Name)
+		}
+	}
+
+	for _, email := range emails {
+		userID, ok := userIDFromEmail(email)
+		if !ok || expected[userID] {
+			continue
+		}
+		// Users being created are placed before they are added to Xray
+		// and get their row afterwards; they are left alone.
+		if placed, err := s.db.GetUserServers(userID); err != nil || slices.Contains(placed, server.ID) {
+			if user, err := s.db.GetUser(userID); err != nil || user == nil || user.Status == models.UserStatusActive {
+				continue
+			}
+		}
+
+		status, xrayErr := backend.RemoveUser(email)
+		s.recordEvent(userID, models.SystemActor, models.EventReconciled, "not entitled on "+server.Name, xrayResult(status, xrayErr))
+		if xrayErr != nil {
+			log.Printf("Error removing user %d from %s: %v", userID, server.Name, xrayErr)
+		} else {
+			log.Printf("User %d was on %s without access, removed", userID, server.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"xray-telegram-bot/models"
+)
+
+// fakeListingXray puts an xray binary on PATH that lists the users written
+// to the returned file and logs the users it adds and removes to the
+// second one.
+func fakeListingXray(t *testing.T) (string, string) {
+	dir := t.TempDir()
+	users, calls := filepath.Join(dir, "users"), filepath.Join(dir, "calls")
+	script := "#!/bin/sh\n" +
+		"case \"$2 $3\" in\n" +
+		"'inbounduser add'|'inbounduser remove') echo \"$3 $6\" >> " + calls + " ;;\n" +
+		"inbounduser*) cat " + users + " ;;\n" +
+		"esac\nexit 0\n"
+	if err := os.WriteFile(filepath.Join(dir, "xray"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return users, calls
+}
+
+func TestReconcile(t *testing.T) {
+	cfg := testConfig(t)
+	db := newTestDB(t)
+	s := newTestUserService(t, db, cfg)
+	for _, userID := range []int64{1, 2} {
+		if _, _, err := s.GetOrCreateVlessConfig(userID, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.ExpireUser(2, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Xray lost user 1, kept expired user 2 and has user 3, who was
+	// never provisioned, next to a client of the operator's.
+	users, calls := fakeListingXray(t)
+	listing := `{"users":[{"email":"user_2@myserver"},{"email":"user_3@myserver"},{"email":"operator"}]}`
+	if err := os.WriteFile(users, []byte(listing), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := string(data)
+	if !strings.Contains(log, `add -user={"email":"user_1@myserver"`) || !strings.Contains(log, "remove -email=user_2@myserver") ||
+		!strings.Contains(log, "remove -email=user_3@myserver") || strings.Contains(log, "operator") || strings.Count(log, "\n") != 3 {
+		t.Fatalf("unexpected corrections:\n%s", log)
+	}
+	for _, userID := range []int64{1, 2, 3} {
+		events, err := s.GetUserEvents(userID, 1)
+		if err != nil || len(events) != 1 || events[0].Action != models.EventReconciled || events[0].XrayResult != "ok" {
+			t.Fatalf("no reconciled event for user %d: %+v, %v", userID, events, err)
+		}
+	}
+
+	// Once Xray matches the database nothing changes.
+	listing = `{"users":[{"email":"user_1@myserver"},{"email":"operator"}]}`
+	if err := os.WriteFile(users, []byte(listing), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(calls)
+	if err := s.Reconcile(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(calls); !os.IsNotExist(err) {
+		t.Fatal("matching Xray corrected")
+	}
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/models"
+	"xray-telegram-bot/xray"
+)
+
+// profileChangeInterval is how often a user may change their routing
+// profile.
+const profileChangeInterval = 10 * time.Minute
+
+// ErrProfileChangeTooSoon is returned when a user changes their routing
+// profile again within profileChangeInterval.
+var ErrProfileChangeTooSoon = errors.New("routing profile changed too recently")
+
+// RoutingProfile returns the profile the user is on, falling back to the
+// default profile.
+func (s *UserService) RoutingProfile(user *models.User) config.RoutingProfile {
+	if profile, ok := s.config.RoutingProfile(user.RoutingProfile); ok {
+		return profile
+	}
+	profile, _ := s.config.RoutingProfile(s.config.DefaultRoutingProfile)
+	return profile
+}
+
+// SetRoutingProfile moves the user onto the profile. A change may cost an
+// Xray restart on every server, so users may change their profile once per
+// profileChangeInterval.
+func (s *UserService) SetRoutingProfile(userID int64, name string) error {
+	if _, ok := s.config.RoutingProfile(name); !ok {
+		return fmt.Errorf("unknown routing profile %q", name)
+	}
+
+	user, err := s.db.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return fmt.Errorf("user %d is not provisioned", userID)
+	}
+	if s.RoutingProfile(user).Name == name {
+		return nil
+	}
+
+	s.profileMu.Lock()
+	now := time.Now()
+	if last, ok := s.profileChanges[userID]; ok && now.Sub(last) < profileChangeInterval {
+		s.profileMu.Unlock()
+		return ErrProfileChangeTooSoon
+	}
+	s.profileChanges[userID] = now
+	s.profileMu.Unlock()
+
+	if err := s.db.UpdateUserRoutingProfile(userID, name); err != nil {
+		return err
+	}
+
+	syncErr := s.SyncRoutingProfiles()
+	s.recordEvent(userID, models.UserActor(userID), models.EventProfileChanged, name, xrayResult(xray.Applied, syncErr))
+	return syncErr
+}
+
+// SyncRoutingProfiles pushes the profile of every active user to Xray on
+// the servers they are placed on. Routing rules live in the Xray config, so
+// servers whose config the bot cannot edit are skipped.
+func (s *UserService) SyncRoutingProfiles() error {
+	users, err := s.db.GetAllUsers()
+	if err != nil {
+		return err
+	}
+	placements, err := s.db.GetAllUserServers()
+	if err != nil {
+		return err
+	}
+	servers, err := s.servers.Servers()
+	if err != nil {
+		return err
+	}
+
+	// emails maps server IDs to profile names to user emails.
+	emails := make(map[int64]map[string][]string)
+	for _, user := range users {
+		if user.Status != models.UserStatusActive {
+			continue
+		}
+		profile := s.RoutingProfile(user)
+		for _, serverID := range placements[user.ID] {
+			if emails[serverID] == nil {
+				emails[serverID] = make(map[string][]string)
+			}
+			emails[serverID][profile.Name] = append(emails[serverID][profile.Name], userEmail(user.ID))
+		}
+	}
+
+	var errs []error
+	for _, server := range servers {
+		if !server.HasConfig() {
+			continue
+		}
+		if err := s.servers.Client(server).SyncRoutingProfiles(s.config.RoutingProfiles, emails[server.ID]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", server.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,278 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"xray-telegram-bot/models"
+	"xray-telegram-bot/xray"
+)
+
+// placeUser puts a user without placements on the location with room the
+// placement policy picks and makes it their location. Unreachable servers
+// are only picked when no other server has room. WireGuard servers are
+// left to users who pick them with /location, as a VLESS client cannot use
+// them.
+func (s *UserService) placeUser(user *models.User) error {
+	locations, err := s.servers.Locations(user)
+	if err != nil {
+		return err
+	}
+
+	var candidates, unreachable []ServerLoad
+	for _, location := range locations {
+		switch {
+		case location.Server.Full(location.Users):
+		case location.Server.Backend == models.BackendWireGuard:
+		case location.Server.Reachable():
+			candidates = append(candidates, location)
+		default:
+			unreachable = append(unreachable, location)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = unreachable
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("no server has room for user %d", user.ID)
+	}
+
+	server := s.placement.Pick(candidates)
+	user.ServerID = server.ID
+	return s.db.AddUserServer(user.ID, server.ID)
+}
+
+// userServers returns the servers the user is placed on.
+func (s *UserService) userServers(userID int64) ([]*models.Server, error) {
+	serverIDs, err := s.db.GetUserServers(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	servers := make([]*models.Server, 0, len(serverIDs))
+	for _, serverID := range serverIDs {
+		server, err := s.db.GetServer(serverID)
+		if err != nil {
+			return nil, err
+		}
+		if server != nil {
+			servers = append(servers, server)
+		}
+	}
+	return servers, nil
+}
+
+// xrayAdd adds the user with user.UUID to the backend of every server they
+// are placed on. The status is pending when any server queued the change
+// for its config file.
+func (s *UserService) xrayAdd(user *models.User) (xray.Status, error) {
+	userID := user.ID
+	servers, err := s.userServers(userID)
+	if err != nil {
+		return "", err
+	}
+	if len(servers) == 0 {
+		return "", fmt.Errorf("user %d is not placed on any server", userID)
+	}
+
+	status := xray.Applied
+	var errs []error
+	for _, server := range servers {
+		serverStatus, err := s.servers.Backend(server).AddUser(user, userEmail(userID))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", server.Name, err))
+		} else if serverStatus == xray.Pending {
+			status = xray.Pending
+		}
+	}
+	return status, errors.Join(errs...)
+}
+
+// xrayRemove removes the user from the backend of every server they are
+// placed on.
+// The placements are kept, so that xrayAdd restores the user.
+func (s *UserService) xrayRemove(userID int64) (xray.Status, error) {
+	servers, err := s.userServers(userID)
+	if err != nil {
+		return "", err
+	}
+
+	status := xray.Applied
+	var errs []error
+	for _, server := range servers {
+		serverStatus, err := s.servers.Backend(server).RemoveUser(userEmail(userID))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", server.Name, err))
+		} else if serverStatus == xray.Pending {
+			status = xray.Pending
+		}
+	}
+	return status, errors.Join(errs...)
+}
+
+// syncLimits pushes the user's expiry and limits to the servers they are
+// placed on, for backends that enforce them themselves. Failures are only
+// logged, the bot enforces them as well.
+func (s *UserService) syncLimits(userID int64) {
+	user, err := s.db.GetUser(userID)
+	if err != nil || user == nil || user.Status != models.UserStatusActive {
+		return
+	}
+	servers, err := s.userServers(userID)
+	if err != nil {
+		log.Printf("Error querying servers of user %d: %v", userID, err)
+		return
+	}
+
+	for _, server := range servers {
+		if err := s.servers.Backend(server).UpdateUser(user, userEmail(userID)); err != nil {
+			log.Printf("Error updating limits of user %d on %s: %v", userID, server.Name, err)
+		}
+	}
+}
+
+// Traffic returns the traffic of the user summed over the servers they are
+// placed on, as counted there.
+func (s *UserService) Traffic(userID int64) (int64, error) {
+	servers, err := s.userServers(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	var errs []error
+	for _, server := range servers {
+		traffic, err := s.servers.Backend(server).UserTraffic(userEmail(userID))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", server.Name, err))
+			continue
+		}
+		total += traffic
+	}
+	return total, errors.Join(errs...)
+}
+
+// ConfigLink returns the link of the user's current config.
+func (s *UserService) ConfigLink(user *models.User) string {
+	return s.configLink(user, user.UUID, fmt.Sprintf("user_%d", user.ID))
+}
+
+// configLink returns the link the user gets for their location: the
+// subscription URL or else the first link of a backend that hands out its
+// own, or a VLESS link with userUUID, falling back to the configured server.
+func (s *UserService) configLink(user *models.User, userUUID, name string) string {
+	server, err := s.db.GetServer(user.ServerID)
+	if err != nil {
+		log.Printf("Error loading server %d: %v", user.ServerID, err)
+	}
+	if server == nil {
+		return xray.VlessURL(s.config.ServerDomain, s.config.ServerPort, userUUID, name)
+	}
+
+	if provider, ok := s.servers.Backend(server).(LinkProvider); ok {
+		link, err := provider.SubscriptionURL(userEmail(user.ID))
+		if err == nil && link == "" {
+			var links []string
+			if links, err = provider.Links(userEmail(user.ID)); err == nil && len(links) > 0 {
+				link = links[0]
+			}
+		}
+		if err == nil && link != "" {
+			return link
+		}
+		log.Printf("Error getting subscription URL of user %d from %s: %v", user.ID, server.Name, err)
+	}
+	return xray.VlessURL(server.Domain, server.Port, userUUID, name)
+}
+
+// ConfigFile returns the config file of the user's location when its
+// backend hands out files instead of links, and an empty name otherwise.
+func (s *UserService) ConfigFile(user *models.User) (string, []byte, error) {
+	server, err := s.db.GetServer(user.ServerID)
+	if err != nil || server == nil {
+		return "", nil, err
+	}
+	provider, ok := s.servers.Backend(server).(ConfigFileProvider)
+	if !ok {
+		return "", nil, nil
+	}
+	return provider.ConfigFile(user, userEmail(user.ID))
+}
+
+// Location returns the server the user picked.
+func (s *UserService) Location(user *models.User) (*models.Server, error) {
+	return s.db.GetServer(user.ServerID)
+}
+
+// SetLocation moves the user to the server: they are added there first and
+// removed from their other servers afterwards, keeping their UUID.
+func (s *UserService) SetLocation(userID, serverID int64) (*models.Server, error) {
+	user, err := s.db.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user %d is not provisioned", userID)
+	}
+	if user.Status != models.UserStatusActive {
+		return nil, fmt.Errorf("user %d is %s", userID, user.Status)
+	}
+
+	server, err := s.db.GetServer(serverID)
+	if err != nil {
+		return nil, err
+	}
+	if server == nil || !server.Enabled || !s.servers.Allowed(user, server) {
+		return nil, fmt.Errorf("server %d is not available to user %d", serverID, userID)
+	}
+	if user.ServerID == server.ID {
+		return server, nil
+	}
+
+	counts, err := s.db.CountServerUsers()
+	if err != nil {
+		return nil, err
+	}
+	if server.Full(counts[server.ID]) {
+		return nil, fmt.Errorf("server %s is full", server.Name)
+	}
+
+	previous, err := s.userServers(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	email := userEmail(userID)
+	status, xrayErr := s.servers.Backend(server).AddUser(user, email)
+	s.recordEvent(userID, models.UserActor(userID), models.EventLocationChanged, server.Name, xrayResult(status, xrayErr))
+	if xrayErr != nil {
+		return nil, fmt.Errorf("failed to add user to %s: %v", server.Name, xrayErr)
+	}
+
+	if err := s.db.AddUserServer(userID, server.ID); err != nil {
+		return nil, err
+	}
+	if err := s.db.UpdateUserServer(userID, server.ID); err != nil {
+		return nil, err
+	}
+
+	for _, old := range previous {
+		if old.ID == server.ID {
+			continue
+		}
+		if _, err := s.servers.Backend(old).RemoveUser(email); err != nil {
+			log.Printf("Error removing user %d from %s: %v", userID, old.Name, err)
+		}
+		if err := s.db.RemoveUserServer(userID, old.ID); err != nil {
+			log.Printf("Error removing placement of user %d on %s: %v", userID, old.Name, err)
+		}
+	}
+
+	if len(s.RoutingProfile(user).Rules) > 0 {
+		if err := s.SyncRoutingProfiles(); err != nil {
+			log.Printf("Error applying routing profile for user %d: %v", userID, err)
+		}
+	}
+
+	return server, nil
+}
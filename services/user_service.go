@@ -3,7 +3,9 @@ package services
 import (
 	"fmt"
 	"log"
+	"sync"
 	"time"
+	"xray-telegram-bot/config"
 	"xray-telegram-bot/database"
 	"xray-telegram-bot/models"
 	"xray-telegram-bot/xray"
@@ -11,16 +13,47 @@ import (
 	"github.com/google/uuid"
 )
 
+// UserService provisions users on the servers they are placed on, through
+// the backends of servers.
 type UserService struct {
-	db         *database.Database
-	xrayClient *xray.Client
+	db        *database.Database
+	servers   *ServerService
+	placement PlacementPolicy
+	config    *config.Config
+
+	// profileChanges holds when users last changed their routing profile.
+	profileMu      sync.Mutex
+	profileChanges map[int64]time.Time
 }
 
-func NewUserService(db *database.Database, xrayClient *xray.Client) *UserService {
+func NewUserService(db *database.Database, servers *ServerService, cfg *config.Config) *UserService {
+	placement, err := NewPlacementPolicy(cfg.PlacementPolicy)
+	if err != nil {
+		log.Printf("Warning: %v, placing new users by active users", err)
+		placement, _ = NewPlacementPolicy("users")
+	}
+
 	return &UserService{
-		db:         db,
-		xrayClient: xrayClient,
+		db:             db,
+		servers:        servers,
+		placement:      placement,
+		config:         cfg,
+		profileChanges: make(map[int64]time.Time),
+	}
+}
+
+// userEmail is the Xray client email identifying a Telegram user.
+func userEmail(userID int64) string {
+	return fmt.Sprintf("user_%d@myserver", userID)
+}
+
+// userIDFromEmail reverses userEmail.
+func userIDFromEmail(email string) (int64, bool) {
+	var userID int64
+	if _, err := fmt.Sscanf(email, "user_%d@", &userID); err != nil {
+		return 0, false
 	}
+	return userID, true
 }
 
 func (s *UserService) GetOrCreateVlessConfig(userID int64, username string) (string, string, error) {
@@ -30,47 +63,138 @@ func (s *UserService) GetOrCreateVlessConfig(userID int64, username string) (str
 	}
 
 	if user != nil {
-		vlessURL := s.xrayClient.GenerateVlessURL(user.UUID, fmt.Sprintf("user_%d", userID))
-		return user.UUID, vlessURL, nil
+		if user.Status == models.UserStatusSuspended {
+			return "", "", ErrUserSuspended
+		}
+		// Callers only ask for configs of entitled users, so an expired
+		// user got entitled again, e.g. by joining the channel.
+		if user.Status == models.UserStatusExpired {
+			if err := s.RenewUser(userID, models.UserActor(userID), "entitled again"); err != nil {
+				return "", "", err
+			}
+		}
+		return user.UUID, s.ConfigLink(user), nil
 	}
 
-	// Create new user
+	return s.createUser(&models.User{ID: userID, Username: username}, "")
+}
+
+// createUser provisions a new user in Xray and the database. Fields of
+// newUser other than ID, Username and the flags are filled in here.
+func (s *UserService) createUser(newUser *models.User, reason string) (string, string, error) {
+	userID := newUser.ID
 	userUUID := uuid.New().String()
-	email := fmt.Sprintf("user_%d@myserver", userID)
+	email := userEmail(userID)
 
-	if err := s.xrayClient.AddUser(userUUID, email); err != nil {
-		return "", "", fmt.Errorf("failed to add user to Xray: %v", err)
+	if err := s.placeUser(newUser); err != nil {
+		return "", "", err
 	}
 
-	newUser := &models.User{
-		ID:        userID,
-		Username:  username,
-		UUID:      userUUID,
-		CreatedAt: time.Now(),
+	newUser.UUID = userUUID
+	status, err := s.xrayAdd(newUser)
+	if err != nil {
+		s.recordEvent(userID, models.UserActor(userID), models.EventCreated, reason, xrayResult(status, err))
+		if removeErr := s.db.RemoveUserServer(userID, newUser.ServerID); removeErr != nil {
+			log.Printf("Error removing placement of user %d: %v", userID, removeErr)
+		}
+		return "", "", fmt.Errorf("failed to add user to Xray: %v", err)
 	}
 
+	newUser.CreatedAt = time.Now()
+
 	if err := s.db.CreateUser(newUser); err != nil {
 		// Cleanup on database error
-		if removeErr := s.xrayClient.RemoveUser(email); removeErr != nil {
+		if _, removeErr := s.xrayRemove(userID); removeErr != nil {
 			log.Printf("Error cleaning up user after database insert failure: %v", removeErr)
 		}
+		if removeErr := s.db.RemoveUserServer(userID, newUser.ServerID); removeErr != nil {
+			log.Printf("Error removing placement of user %d: %v", userID, removeErr)
+		}
 		return "", "", err
 	}
 
-	vlessURL := s.xrayClient.GenerateVlessURL(userUUID, email)
+	s.recordEvent(userID, models.UserActor(userID), models.EventCreated, reason, xrayResult(status, nil))
+
+	if len(s.RoutingProfile(newUser).Rules) > 0 {
+		if err := s.SyncRoutingProfiles(); err != nil {
+			log.Printf("Error applying routing profile for user %d: %v", userID, err)
+		}
+	}
+
+	vlessURL := s.configLink(newUser, userUUID, email)
 	return userUUID, vlessURL, nil
 }
 
-func (s *UserService) RemoveUser(userID int64) error {
-	email := fmt.Sprintf("user_%d@myserver", userID)
+// RemoveUser revokes the user's access. The actor and reason end up in the
+// events table when the user was provisioned. Suspended users are out of
+// Xray already and keep their row, so that leaving and rejoining the
+// channel does not lift the suspension.
+func (s *UserService) RemoveUser(userID int64, actor models.Actor, reason string) error {
+	user, err := s.db.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if user != nil && user.Status == models.UserStatusSuspended {
+		return nil
+	}
+
+	status, xrayErr := s.xrayRemove(userID)
+	if xrayErr != nil {
+		log.Printf("Error removing user %d from Xray: %v", userID, xrayErr)
+	}
+
+	if err := s.db.DeleteUser(userID); err != nil {
+		return err
+	}
+
+	if user != nil {
+		s.recordEvent(userID, actor, models.EventRevoked, reason, xrayResult(status, xrayErr))
+	}
+	return nil
+}
 
-	if err := s.xrayClient.RemoveUser(email); err != nil {
-		log.Printf("Error removing user %d from Xray: %v", userID, err)
+// recordEvent appends to the audit log. Failures are logged only, so that
+// auditing never blocks provisioning. result is xrayResult of the Xray call,
+// or empty when Xray was not involved.
+func (s *UserService) recordEvent(userID int64, actor models.Actor, action, reason, result string) {
+	event := &models.Event{
+		UserID:     userID,
+		ActorType:  actor.Type,
+		ActorID:    actor.ID,
+		Action:     action,
+		Reason:     reason,
+		XrayResult: result,
+		CreatedAt:  time.Now(),
 	}
 
-	return s.db.DeleteUser(userID)
+	if err := s.db.CreateEvent(event); err != nil {
+		log.Printf("Error recording %s event for user %d: %v", action, userID, err)
+	}
+}
+
+// xrayResult describes the outcome of an Xray call for the events table.
+func xrayResult(status xray.Status, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	if status == xray.Pending {
+		return string(xray.Pending)
+	}
+	return "ok"
+}
+
+func (s *UserService) GetUserEvents(userID int64, limit int) ([]*models.Event, error) {
+	return s.db.GetUserEvents(userID, limit)
+}
+
+func (s *UserService) GetUser(userID int64) (*models.User, error) {
+	return s.db.GetUser(userID)
 }
 
 func (s *UserService) GetAllUsers() ([]*models.User, error) {
 	return s.db.GetAllUsers()
 }
+
+func (s *UserService) UpdateLanguage(userID int64, languageCode string) error {
+	return s.db.UpdateUserLanguage(userID, languageCode)
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"xray-telegram-bot/database"
+	"xray-telegram-bot/models"
+	"xray-telegram-bot/wireguard"
+	"xray-telegram-bot/xray"
+)
+
+// wireguardBackend provisions users as peers of a WireGuard interface on
+// the bot's host. Keys and addresses are kept in the database, so that the
+// user's config stays the same until their UUID is rotated. WireGuard
+// knows no expiry or limits, the bot enforces them.
+type wireguardBackend struct {
+	db     *database.Database
+	server *models.Server
+	iface  *wireguard.Interface
+	subnet netip.Prefix
+	dns    []string
+}
+
+// newWireGuardBackend sets up the backend of a WireGuard server. Its
+// BackendURL is the interface's config file with the subnet addresses are
+// allocated from and optionally the DNS servers for clients, e.g.
+// /etc/wireguard/wg0.conf?subnet=10.8.0.0/24&dns=1.1.1.1,8.8.8.8.
+// Clients connect to the server's domain and port.
+func newWireGuardBackend(db *database.Database, server *models.Server) (*wireguardBackend, error) {
+	parsed, err := url.Parse(server.BackendURL)
+	if err != nil || parsed.Path == "" {
+		return nil, fmt.Errorf("invalid WireGuard config %q", server.BackendURL)
+	}
+
+	query := parsed.Query()
+	subnet, err := netip.ParsePrefix(query.Get("subnet"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid WireGuard subnet %q", query.Get("subnet"))
+	}
+
+	var dns []string
+	for _, address := range strings.Split(query.Get("dns"), ",") {
+		if address = strings.TrimSpace(address); address == "" {
+			continue
+		}
+		if _, err := netip.ParseAddr(address); err != nil {
+			return nil, fmt.Errorf("invalid DNS server %q", address)
+		}
+		dns = append(dns, address)
+	}
+
+	return &wireguardBackend{
+		db:     db,
+		server: server,
+		iface:  wireguard.NewInterface(parsed.Path),
+		subnet: subnet,
+		dns:    dns,
+	}, nil
+}
+
+// AddUser adds the user's peer to the interface. A user without a peer on
+// the server gets keys and an address; a user whose UUID changed gets new
+// keys, so that their old config stops working.
+func (b *wireguardBackend) AddUser(user *models.User, email string) (xray.Status, error) {
+	peer, err := b.peer(user)
+	if err != nil {
+		return "", err
+	}
+
+	address, err := netip.ParseAddr(peer.Address)
+	if err != nil {
+		return "", fmt.Errorf("invalid address of user %d: %v", user.ID, err)
+	}
+	if err := b.iface.AddPeer(email, peer.PublicKey, address); err != nil {
+		return "", err
+	}
+	return xray.Applied, nil
+}
+
+func (b *wireguardBackend) peer(user *models.User) (*models.WireGuardPeer, error) {
+	peer, err := b.db.GetWireGuardPeer(b.server.ID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if peer != nil && peer.UUID == user.UUID {
+		return peer, nil
+	}
+
+	privateKey, publicKey, err := wireguard.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if peer != nil {
+		peer.UUID, peer.PrivateKey, peer.PublicKey = user.UUID, privateKey, publicKey
+		return peer, b.db.UpdateWireGuardPeerKeys(b.server.ID, user.ID, user.UUID, privateKey, publicKey)
+	}
+
+	reserved, err := b.iface.UsedAddresses()
+	if err != nil {
+		return nil, err
+	}
+	peer = &models.WireGuardPeer{
+		ServerID:   b.server.ID,
+		UserID:     user.ID,
+		UUID:       user.UUID,
+		PrivateKey: privateKey,
+		PublicKey:  publicKey,
+		CreatedAt:  time.Now(),
+	}
+	if err := b.db.CreateWireGuardPeer(peer, b.subnet, reserved); err != nil {
+		return nil, err
+	}
+	return peer, nil
+}
+
+// RemoveUser removes the user's peer from the interface. Their address
+// stays allocated, so that they get the same one back.
+func (b *wireguardBackend) RemoveUser(email string) (xray.Status, error) {
+	if err := b.iface.RemovePeer(email); err != nil {
+		return "", err
+	}
+	return xray.Applied, nil
+}
+
+func (b *wireguardBackend) UpdateUser(*models.User, string) error {
+	return nil
+}
+
+// UserTraffic returns the traffic of the user's peer since the interface
+// came up.
+func (b *wireguardBackend) UserTraffic(email string) (int64, error) {
+	return b.iface.Transfer(email)
+}
+
+func (b *wireguardBackend) TestAPI() error {
+	return b.iface.Check()
+}
+
+// ConfigFile returns the .conf file of the user's peer, named after the
+// server.
+func (b *wireguardBackend) ConfigFile(user *models.User, email string) (string, []byte, error) {
+	peer, err := b.db.GetWireGuardPeer(b.server.ID, user.ID)
+	if err != nil {
+		return "", nil, err
+	}
+	if peer == nil {
+		return "", nil, fmt.Errorf("user %d has no peer on %s", user.ID, b.server.Name)
+	}
+
+	address, err := netip.ParseAddr(peer.Address)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid address of user %d: %v", user.ID, err)
+	}
+	serverKey, err := b.iface.PublicKey()
+	if err != nil {
+		return "", nil, err
+	}
+
+	config := wireguard.ClientConfig{
+		PrivateKey:      peer.PrivateKey,
+		Address:         address,
+		DNS:             b.dns,
+		ServerPublicKey: serverKey,
+		Endpoint:        net.JoinHostPort(b.server.Domain, strconv.Itoa(b.server.Port)),
+	}
+	return wireguard.TunnelName(b.server.Name) + ".conf", config.Render(), nil
+}
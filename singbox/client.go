@@ -0,0 +1,216 @@
+// Package singbox provisions users in the config file of a sing-box
+// server, on its hysteria2, tuic and vless inbounds, and builds their share
+// links.
+package singbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+	"xray-telegram-bot/configfile"
+	"xray-telegram-bot/models"
+	"xray-telegram-bot/xray"
+)
+
+// Client manages the users of a sing-box config file on the bot's host.
+// Every change is checked with sing-box check before it replaces the file,
+// and sing-box is reloaded afterwards; when the reload fails, the old file
+// is put back.
+type Client struct {
+	configPath string
+	host       string
+	label      string
+	reload     []string
+
+	mu sync.Mutex
+}
+
+// NewClient returns a client for the config file. Links point at host and
+// are named after label; reload is the command that makes sing-box apply
+// its config file.
+func NewClient(configPath, host, label string, reload []string) (*Client, error) {
+	if configPath == "" {
+		return nil, fmt.Errorf("no sing-box config path")
+	}
+	if len(reload) == 0 {
+		return nil, fmt.Errorf("no sing-box reload command")
+	}
+	return &Client{configPath: configPath, host: host, label: label, reload: reload}, nil
+}
+
+// validateConfig has sing-box load the config without starting.
+var validateConfig = configfile.Command("sing-box", "check", "-c")
+
+// TestAPI checks that the config file is there and that sing-box accepts
+// it.
+func (c *Client) TestAPI() error {
+	inbounds, err := c.inbounds()
+	if err != nil {
+		return err
+	}
+	managedInbounds := 0
+	for _, in := range inbounds {
+		if managed(in.Type) {
+			managedInbounds++
+		}
+	}
+	if managedInbounds == 0 {
+		return fmt.Errorf("%s has no hysteria2, tuic or vless inbound", c.configPath)
+	}
+	return validateConfig(c.configPath)
+}
+
+// AddUser gives the user access on every managed inbound, replacing
+// earlier entries of the email.
+func (c *Client) AddUser(user *models.User, email string) (xray.Status, error) {
+	err := c.update(func(in *inbound) []json.RawMessage {
+		users := withoutUser(in.Users, email)
+		entry, err := json.Marshal(newUser(in, user.UUID, email))
+		if err != nil {
+			return users
+		}
+		return append(users, entry)
+	})
+	if err != nil {
+		return "", err
+	}
+	return xray.Applied, nil
+}
+
+// RemoveUser removes the user from every inbound. A user that is not there
+// counts as removed.
+func (c *Client) RemoveUser(email string) (xray.Status, error) {
+	err := c.update(func(in *inbound) []json.RawMessage {
+		return withoutUser(in.Users, email)
+	})
+	if err != nil {
+		return "", err
+	}
+	return xray.Applied, nil
+}
+
+// UpdateUser does nothing: sing-box knows no expiry or limits, the bot
+// enforces them.
+func (c *Client) UpdateUser(*models.User, string) error {
+	return nil
+}
+
+// UserTraffic returns zero. sing-box only counts traffic per user when
+// built with its V2Ray API, which release builds are not.
+func (c *Client) UserTraffic(string) (int64, error) {
+	return 0, nil
+}
+
+// SubscriptionURL returns no URL, sing-box serves no subscriptions; the
+// bot's subscription carries the links.
+func (c *Client) SubscriptionURL(string) (string, error) {
+	return "", nil
+}
+
+// Links returns a share link per managed inbound the user is on, in the
+// order of the config.
+func (c *Client) Links(email string) ([]string, error) {
+	inbounds, err := c.inbounds()
+	if err != nil {
+		return nil, err
+	}
+
+	var links []string
+	for _, in := range inbounds {
+		if !managed(in.Type) {
+			continue
+		}
+		for _, raw := range in.Users {
+			var u user
+			if json.Unmarshal(raw, &u) != nil || u.Name != email {
+				continue
+			}
+			link, err := shareLink(&in.inbound, u, c.host, c.label)
+			if err != nil {
+				log.Printf("Error building %s link of %s: %v", in.Type, email, err)
+				continue
+			}
+			links = append(links, link)
+		}
+	}
+	return links, nil
+}
+
+// update edits the users in the config file, replaces it once sing-box
+// accepts the result and reloads sing-box.
+func (c *Client) update(edit func(in *inbound) []json.RawMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	snapshot, err := configfile.Take(c.configPath)
+	if err != nil {
+		return err
+	}
+	data, err := editUsers(snapshot.Data(), edit)
+	if err != nil {
+		return fmt.Errorf("failed to edit %s: %v", c.configPath, err)
+	}
+	if string(data) == string(snapshot.Data()) {
+		return nil
+	}
+
+	if err := snapshot.Replace(data, validateConfig); err != nil {
+		return err
+	}
+	if err := c.reloadSingBox(); err != nil {
+		if restoreErr := snapshot.Restore(); restoreErr != nil {
+			log.Printf("Error restoring %s: %v", c.configPath, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *Client) reloadSingBox() error {
+	output, err := exec.Command(c.reload[0], c.reload[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v, output: %s", strings.Join(c.reload, " "), err, string(output))
+	}
+	return nil
+}
+
+func (c *Client) inbounds() ([]parsedInbound, error) {
+	snapshot, err := configfile.Take(c.configPath)
+	if err != nil {
+		return nil, err
+	}
+	return parseInbounds(snapshot.Data())
+}
+
+// newUser returns the entry of a user for the inbound. The user's UUID
+// doubles as their password. VLESS users get the Vision flow on plain TCP,
+// the only transport it works on.
+func newUser(in *inbound, userUUID, email string) user {
+	switch in.Type {
+	case "hysteria2":
+		return user{Name: email, Password: userUUID}
+	case "tuic":
+		return user{Name: email, UUID: userUUID, Password: userUUID}
+	default:
+		entry := user{Name: email, UUID: userUUID}
+		if in.Transport == nil {
+			entry.Flow = "xtls-rprx-vision"
+		}
+		return entry
+	}
+}
+
+func withoutUser(users []json.RawMessage, email string) []json.RawMessage {
+	kept := make([]json.RawMessage, 0, len(users))
+	for _, raw := range users {
+		var u user
+		if json.Unmarshal(raw, &u) == nil && u.Name == email {
+			continue
+		}
+		kept = append(kept, raw)
+	}
+	return kept
+}
@@ -0,0 +1,236 @@
+package singbox
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// inbound is the part of a sing-box inbound the bot reads.
+type inbound struct {
+	Type              string            `json:"type"`
+	Tag               string            `json:"tag"`
+	ListenPort        int               `json:"listen_port"`
+	Users             []json.RawMessage `json:"users"`
+	CongestionControl string            `json:"congestion_control"`
+	Obfs              *struct {
+		Type     string `json:"type"`
+		Password string `json:"password"`
+	} `json:"obfs"`
+	TLS *struct {
+		Enabled    bool     `json:"enabled"`
+		ServerName string   `json:"server_name"`
+		ALPN       []string `json:"alpn"`
+		Reality    *struct {
+			Enabled    bool     `json:"enabled"`
+			PrivateKey string   `json:"private_key"`
+			ShortID    []string `json:"short_id"`
+		} `json:"reality"`
+	} `json:"tls"`
+	Transport *struct {
+		Type string `json:"type"`
+	} `json:"transport"`
+}
+
+// user is a user of a hysteria2, tuic or vless inbound. Hysteria2 users
+// have a password, TUIC users a UUID and a password, VLESS users a UUID and
+// a flow.
+type user struct {
+	Name     string `json:"name"`
+	UUID     string `json:"uuid,omitempty"`
+	Password string `json:"password,omitempty"`
+	Flow     string `json:"flow,omitempty"`
+}
+
+// managed reports whether the bot manages the users of inbounds of the
+// type.
+func managed(inboundType string) bool {
+	switch inboundType {
+	case "hysteria2", "tuic", "vless":
+		return true
+	}
+	return false
+}
+
+// parsedInbound is an inbound with where its users array is in the config
+// file: from start to end, or, when there is none, where one can be
+// inserted after the last field, at start and end both.
+type parsedInbound struct {
+	inbound
+	start, end int64
+	hasUsers   bool
+	empty      bool
+	// indent is the indentation of the fields of the inbound.
+	indent string
+}
+
+// parseInbounds reads the inbounds of the config and where their users
+// arrays are, so that editUsers can replace just those.
+func parseInbounds(data []byte) ([]parsedInbound, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+
+	var inbounds []parsedInbound
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if key != "inbounds" {
+			if err := skipValue(dec); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if err := expectDelim(dec, '['); err != nil {
+			return nil, err
+		}
+		for dec.More() {
+			parsed, err := parseInbound(data, dec)
+			if err != nil {
+				return nil, err
+			}
+			inbounds = append(inbounds, parsed)
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return nil, err
+		}
+	}
+	return inbounds, nil
+}
+
+func parseInbound(data []byte, dec *json.Decoder) (parsedInbound, error) {
+	var parsed parsedInbound
+	objectStart := valueStart(data, dec.InputOffset())
+	if err := expectDelim(dec, '{'); err != nil {
+		return parsed, err
+	}
+
+	parsed.empty = true
+	var lastEnd int64
+	for dec.More() {
+		parsed.empty = false
+		parsed.indent = lineIndent(data, valueStart(data, dec.InputOffset()))
+		key, err := dec.Token()
+		if err != nil {
+			return parsed, err
+		}
+		if key != "users" {
+			if err := skipValue(dec); err != nil {
+				return parsed, err
+			}
+			lastEnd = dec.InputOffset()
+			continue
+		}
+		parsed.start = valueStart(data, dec.InputOffset())
+		if err := skipValue(dec); err != nil {
+			return parsed, err
+		}
+		parsed.end = dec.InputOffset()
+		lastEnd = parsed.end
+		parsed.hasUsers = true
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return parsed, err
+	}
+	objectEnd := dec.InputOffset()
+
+	if parsed.empty {
+		lastEnd = objectEnd - 1
+	}
+	if !parsed.hasUsers {
+		parsed.start, parsed.end = lastEnd, lastEnd
+	}
+	if err := json.Unmarshal(data[objectStart:objectEnd], &parsed.inbound); err != nil {
+		return parsed, err
+	}
+	return parsed, nil
+}
+
+// valueStart returns where the value after offset starts, past the
+// separators the decoder has not consumed yet.
+func valueStart(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) && strings.ContainsRune(" \t\r\n:,", rune(data[offset])) {
+		offset++
+	}
+	return offset
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("invalid config: expected %v, got %v", delim, token)
+	}
+	return nil
+}
+
+func skipValue(dec *json.Decoder) error {
+	var raw json.RawMessage
+	return dec.Decode(&raw)
+}
+
+// editUsers gives edit the users of every managed inbound and writes the
+// users it returns back into the config. Only the users arrays change;
+// every other byte of the config stays as it was.
+func editUsers(data []byte, edit func(in *inbound) []json.RawMessage) ([]byte, error) {
+	inbounds, err := parseInbounds(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Later inbounds first, so that earlier offsets stay valid.
+	result := append([]byte(nil), data...)
+	for i := len(inbounds) - 1; i >= 0; i-- {
+		parsed := inbounds[i]
+		if !managed(parsed.Type) {
+			continue
+		}
+
+		indent := parsed.indent
+		if parsed.hasUsers {
+			indent = lineIndent(data, parsed.start)
+		}
+		replacement := formatUsers(edit(&parsed.inbound), indent)
+		if !parsed.hasUsers {
+			separator := ","
+			if parsed.empty {
+				separator = ""
+			}
+			replacement = fmt.Sprintf("%s\n%s\"users\": %s", separator, indent, replacement)
+		}
+
+		var b bytes.Buffer
+		b.Write(result[:parsed.start])
+		b.WriteString(replacement)
+		b.Write(result[parsed.end:])
+		result = b.Bytes()
+	}
+	return result, nil
+}
+
+// formatUsers renders the users array one user per line. Users already in
+// the config keep their formatting, new ones are written compactly.
+func formatUsers(users []json.RawMessage, indent string) string {
+	if len(users) == 0 {
+		return "[]"
+	}
+	lines := make([]string, len(users))
+	for i, raw := range users {
+		lines[i] = indent + "  " + string(raw)
+	}
+	return "[\n" + strings.Join(lines, ",\n") + "\n" + indent + "]"
+}
+
+// lineIndent returns the indentation of the line offset is on.
+func lineIndent(data []byte, offset int64) string {
+	lineStart := bytes.LastIndexByte(data[:offset], '\n') + 1
+	line := data[lineStart:offset]
+	return string(line[:len(line)-len(bytes.TrimLeft(line, " \t"))])
+}
@@ -0,0 +1,104 @@
+package singbox
+
+import (
+	"crypto/ecdh"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// shareLink builds the link clients import for the user on the inbound,
+// named after the server label and the protocol.
+func shareLink(in *inbound, u user, host, label string) (string, error) {
+	link := url.URL{
+		Host:     net.JoinHostPort(host, strconv.Itoa(in.ListenPort)),
+		Fragment: label + " " + in.Type,
+	}
+	query := url.Values{}
+	if in.TLS != nil && in.TLS.Enabled && in.TLS.ServerName != "" {
+		query.Set("sni", in.TLS.ServerName)
+	}
+
+	switch in.Type {
+	case "hysteria2":
+		link.Scheme = "hysteria2"
+		link.User = url.User(u.Password)
+		link.Path = "/"
+		if in.Obfs != nil && in.Obfs.Type != "" {
+			query.Set("obfs", in.Obfs.Type)
+			query.Set("obfs-password", in.Obfs.Password)
+		}
+
+	case "tuic":
+		link.Scheme = "tuic"
+		link.User = url.UserPassword(u.UUID, u.Password)
+		if in.CongestionControl != "" {
+			query.Set("congestion_control", in.CongestionControl)
+		}
+		if in.TLS != nil && len(in.TLS.ALPN) > 0 {
+			query.Set("alpn", strings.Join(in.TLS.ALPN, ","))
+		}
+
+	case "vless":
+		link.Scheme = "vless"
+		link.User = url.User(u.UUID)
+		query.Set("encryption", "none")
+		query.Set("type", "tcp")
+		if in.Transport != nil {
+			query.Set("type", in.Transport.Type)
+		}
+		if u.Flow != "" {
+			query.Set("flow", u.Flow)
+		}
+		if err := setSecurity(query, in); err != nil {
+			return "", err
+		}
+
+	default:
+		return "", fmt.Errorf("no links for %s inbounds", in.Type)
+	}
+
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
+
+// setSecurity sets the TLS or REALITY parameters of a VLESS link. REALITY
+// clients need the public key, derived from the inbound's private key.
+func setSecurity(query url.Values, in *inbound) error {
+	switch {
+	case in.TLS == nil || !in.TLS.Enabled:
+		query.Set("security", "none")
+	case in.TLS.Reality != nil && in.TLS.Reality.Enabled:
+		publicKey, err := realityPublicKey(in.TLS.Reality.PrivateKey)
+		if err != nil {
+			return err
+		}
+		query.Set("security", "reality")
+		query.Set("pbk", publicKey)
+		query.Set("fp", "chrome")
+		if len(in.TLS.Reality.ShortID) > 0 {
+			query.Set("sid", in.TLS.Reality.ShortID[0])
+		}
+	default:
+		query.Set("security", "tls")
+	}
+	return nil
+}
+
+// realityPublicKey derives the public key of a REALITY private key, both
+// base64url encoded without padding as sing-box generate reality-keypair
+// prints them.
+func realityPublicKey(privateKey string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid REALITY private key: %v", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid REALITY private key: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
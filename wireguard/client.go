@@ -0,0 +1,60 @@
+package wireguard
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// persistentKeepalive keeps NAT mappings of clients open, so that the
+// tunnel survives idle periods on mobile networks.
+const persistentKeepalive = 25
+
+// ClientConfig is the config a user imports into their WireGuard app. All
+// traffic is routed through the tunnel.
+type ClientConfig struct {
+	PrivateKey      string
+	Address         netip.Addr
+	DNS             []string
+	ServerPublicKey string
+	Endpoint        string
+}
+
+// Render returns the config in the .conf format of the WireGuard apps.
+func (c ClientConfig) Render() []byte {
+	var b strings.Builder
+	b.WriteString("[Interface]\n")
+	fmt.Fprintf(&b, "PrivateKey = %s\n", c.PrivateKey)
+	fmt.Fprintf(&b, "Address = %s\n", netip.PrefixFrom(c.Address, c.Address.BitLen()))
+	if len(c.DNS) > 0 {
+		fmt.Fprintf(&b, "DNS = %s\n", strings.Join(c.DNS, ", "))
+	}
+	b.WriteString("\n[Peer]\n")
+	fmt.Fprintf(&b, "PublicKey = %s\n", c.ServerPublicKey)
+	b.WriteString("AllowedIPs = 0.0.0.0/0, ::/0\n")
+	fmt.Fprintf(&b, "Endpoint = %s\n", c.Endpoint)
+	fmt.Fprintf(&b, "PersistentKeepalive = %d\n", persistentKeepalive)
+	return []byte(b.String())
+}
+
+// TunnelName turns name into a name the WireGuard apps accept for a
+// tunnel, which they take from the file name: at most 15 letters, digits
+// and _=+.- characters.
+func TunnelName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', strings.ContainsRune("_=+.-", r):
+			b.WriteRune(r)
+		case r == ' ':
+			b.WriteByte('-')
+		}
+		if b.Len() == 15 {
+			break
+		}
+	}
+	if b.Len() == 0 {
+		return "wireguard"
+	}
+	return b.String()
+}
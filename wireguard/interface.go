@@ -0,0 +1,317 @@
+package wireguard
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"xray-telegram-bot/configfile"
+)
+
+// Interface is a WireGuard interface brought up by wg-quick from its config
+// file. Peers are added to and removed from the file, which keeps them
+// across restarts, and applied to the running interface with wg syncconf.
+// Peers the bot manages carry their email as a comment; other peers and
+// the rest of the file are left as they are.
+type Interface struct {
+	name       string
+	configPath string
+
+	mu sync.Mutex
+}
+
+// NewInterface returns the interface configured by the file, named after
+// it like wg-quick does, e.g. wg0 for /etc/wireguard/wg0.conf.
+func NewInterface(configPath string) *Interface {
+	return &Interface{
+		name:       strings.TrimSuffix(filepath.Base(configPath), ".conf"),
+		configPath: configPath,
+	}
+}
+
+// Check reports whether the interface is up.
+func (i *Interface) Check() error {
+	output, err := exec.Command("wg", "show", i.name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("wg show %s failed: %v, output: %s", i.name, err, string(output))
+	}
+	return nil
+}
+
+// PublicKey returns the public key of the interface, derived from the
+// private key in its config file.
+func (i *Interface) PublicKey() (string, error) {
+	sections, err := i.read()
+	if err != nil {
+		return "", err
+	}
+	for _, section := range sections {
+		if section.is("Interface") {
+			if private := section.value("PrivateKey"); private != "" {
+				return PublicKey(private)
+			}
+		}
+	}
+	return "", fmt.Errorf("%s has no private key", i.configPath)
+}
+
+// UsedAddresses returns the addresses of the interface itself and of the
+// single-address peers in its config file, including peers added by hand,
+// which new peers may not be given.
+func (i *Interface) UsedAddresses() ([]netip.Addr, error) {
+	sections, err := i.read()
+	if err != nil {
+		return nil, err
+	}
+
+	var addresses []netip.Addr
+	for _, section := range sections {
+		var key string
+		switch {
+		case section.is("Interface"):
+			key = "Address"
+		case section.is("Peer"):
+			key = "AllowedIPs"
+		default:
+			continue
+		}
+
+		for _, field := range strings.Split(section.value(key), ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			prefix, err := netip.ParsePrefix(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q in %s: %v", field, i.configPath, err)
+			}
+			if key == "Address" || prefix.IsSingleIP() {
+				addresses = append(addresses, prefix.Addr())
+			}
+		}
+	}
+	return addresses, nil
+}
+
+// AddPeer adds a peer for email with the public key and address, replacing
+// an earlier peer of the email, and applies the file.
+func (i *Interface) AddPeer(email, publicKey string, address netip.Addr) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	sections, err := i.read()
+	if err != nil {
+		return err
+	}
+
+	sections = removePeers(sections, func(peer section) bool {
+		return peer.email() == email || peer.value("PublicKey") == publicKey
+	})
+	if last := len(sections) - 1; last >= 0 {
+		sections[last] = sections[last].withBlankLine()
+	}
+	sections = append(sections, section{lines: []string{
+		"[Peer]",
+		"# " + email,
+		"PublicKey = " + publicKey,
+		"AllowedIPs = " + netip.PrefixFrom(address, address.BitLen()).String(),
+	}})
+
+	if err := i.write(sections); err != nil {
+		return err
+	}
+	return i.sync()
+}
+
+// RemovePeer removes the peer of email and applies the file. A peer that is
+// not there counts as removed.
+func (i *Interface) RemovePeer(email string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	sections, err := i.read()
+	if err != nil {
+		return err
+	}
+
+	kept := removePeers(sections, func(peer section) bool {
+		return peer.email() == email
+	})
+	if len(kept) == len(sections) {
+		return nil
+	}
+
+	if err := i.write(kept); err != nil {
+		return err
+	}
+	return i.sync()
+}
+
+// Transfer returns the bytes the interface received from and sent to the
+// peer of email since it came up.
+func (i *Interface) Transfer(email string) (int64, error) {
+	sections, err := i.read()
+	if err != nil {
+		return 0, err
+	}
+
+	var publicKey string
+	for _, section := range sections {
+		if section.is("Peer") && section.email() == email {
+			publicKey = section.value("PublicKey")
+		}
+	}
+	if publicKey == "" {
+		return 0, nil
+	}
+
+	output, err := exec.Command("wg", "show", i.name, "transfer").Output()
+	if err != nil {
+		return 0, fmt.Errorf("wg show %s transfer failed: %v", i.name, err)
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != publicKey {
+			continue
+		}
+		received, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		sent, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		return received + sent, nil
+	}
+	return 0, nil
+}
+
+// sync applies the config file to the running interface without
+// disturbing the sessions of other peers. wg syncconf does not understand
+// the wg-quick fields, which wg-quick strip leaves out.
+func (i *Interface) sync() error {
+	stripped, err := exec.Command("wg-quick", "strip", i.configPath).Output()
+	if err != nil {
+		return fmt.Errorf("wg-quick strip %s failed: %v", i.configPath, err)
+	}
+
+	file, err := os.CreateTemp("", "wireguard-*.conf")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(stripped); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	output, err := exec.Command("wg", "syncconf", i.name, file.Name()).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("wg syncconf %s failed: %v, output: %s", i.name, err, string(output))
+	}
+	return nil
+}
+
+func (i *Interface) read() ([]section, error) {
+	data, err := os.ReadFile(i.configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", i.configPath, err)
+	}
+	return parseSections(string(data)), nil
+}
+
+// write replaces the config file, which holds the private key and keeps
+// its permissions.
+func (i *Interface) write(sections []section) error {
+	var b strings.Builder
+	for _, section := range sections {
+		for _, line := range section.lines {
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+	}
+	return configfile.Write(i.configPath, []byte(b.String()), 0600, nil)
+}
+
+// section is a part of the config file starting with its [Name] header,
+// kept line by line. The lines before the first header form a section
+// without one.
+type section struct {
+	lines []string
+}
+
+func parseSections(data string) []section {
+	var sections []section
+	current := section{}
+	for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "[") && len(current.lines) > 0 {
+			sections = append(sections, current)
+			current = section{}
+		}
+		current.lines = append(current.lines, line)
+	}
+	if len(current.lines) > 0 {
+		sections = append(sections, current)
+	}
+	return sections
+}
+
+func (s section) is(name string) bool {
+	return len(s.lines) > 0 && strings.EqualFold(strings.TrimSpace(s.lines[0]), "["+name+"]")
+}
+
+// value returns the value of the key, the last one if it is repeated.
+func (s section) value(key string) string {
+	var value string
+	for _, line := range s.lines {
+		name, v, ok := strings.Cut(line, "=")
+		if ok && strings.EqualFold(strings.TrimSpace(name), key) {
+			value = strings.TrimSpace(v)
+		}
+	}
+	return value
+}
+
+// email returns the email of a peer the bot manages, the comment on the
+// line after its header.
+func (s section) email() string {
+	if len(s.lines) < 2 {
+		return ""
+	}
+	comment := strings.TrimSpace(s.lines[1])
+	if !strings.HasPrefix(comment, "#") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(comment, "#"))
+}
+
+// withBlankLine makes the section end with an empty line, so that a
+// section appended after it stands apart.
+func (s section) withBlankLine() section {
+	if len(s.lines) > 0 && strings.TrimSpace(s.lines[len(s.lines)-1]) == "" {
+		return s
+	}
+	lines := append(append([]string(nil), s.lines...), "")
+	return section{lines: lines}
+}
+
+func removePeers(sections []section, match func(section) bool) []section {
+	kept := make([]section, 0, len(sections))
+	for _, section := range sections {
+		if section.is("Peer") && match(section) {
+			continue
+		}
+		kept = append(kept, section)
+	}
+	return kept
+}
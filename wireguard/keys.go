@@ -0,0 +1,34 @@
+// Package wireguard manages the peers of a WireGuard interface through its
+// wg-quick config file and renders the configs users import.
+package wireguard
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+)
+
+// GenerateKey returns a new Curve25519 private key and its public key,
+// base64 encoded the way wg expects them.
+func GenerateKey() (private, public string, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(key.Bytes()),
+		base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// PublicKey derives the public key of a base64 encoded private key.
+func PublicKey(private string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(private)
+	if err != nil {
+		return "", fmt.Errorf("invalid private key: %v", err)
+	}
+	key, err := ecdh.X25519().NewPrivateKey(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid private key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
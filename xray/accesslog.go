@@ -0,0 +1,138 @@
+package xray
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// AccessRecord is one accepted connection from the Xray access log.
+type AccessRecord struct {
+	Time        time.Time
+	SourceIP    string
+	Destination string
+	Email       string
+}
+
+// accessLinePattern matches lines such as
+//
+//	2024/05/01 12:00:00.123456 from 1.2.3.4:5678 accepted tcp:example.com:443 [vless_tls >> direct] email: user_1@myserver
+var accessLinePattern = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2})(?:\.\d+)? (?:from )?(\S+) accepted (\S+) .*email: (\S+)`)
+
+func ParseAccessLine(line string) (AccessRecord, bool) {
+	match := accessLinePattern.FindStringSubmatch(line)
+	if match == nil {
+		return AccessRecord{}, false
+	}
+
+	timestamp, err := time.ParseInLocation("2006/01/02 15:04:05", match[1], time.Local)
+	if err != nil {
+		timestamp = time.Now()
+	}
+
+	source := strings.TrimPrefix(match[2], "tcp:")
+	source = strings.TrimPrefix(source, "udp:")
+	if host, _, err := net.SplitHostPort(source); err == nil {
+		source = host
+	}
+
+	destination := match[3]
+	if i := strings.Index(destination, ":"); i != -1 {
+		destination = destination[i+1:]
+	}
+	if host, _, err := net.SplitHostPort(destination); err == nil {
+		destination = host
+	}
+
+	return AccessRecord{
+		Time:        timestamp,
+		SourceIP:    source,
+		Destination: destination,
+		Email:       match[4],
+	}, true
+}
+
+// AccessLogPath returns the log.access path from the Xray config.
+func (c *Client) AccessLogPath() (string, error) {
+	if c.remote != nil {
+		return "", fmt.Errorf("access log of a remote server cannot be followed")
+	}
+
+	config, err := c.readXrayConfig()
+	if err != nil {
+		return "", err
+	}
+
+	logConfig, _ := config.Log.(map[string]interface{})
+	path, _ := logConfig["access"].(string)
+	if path == "" || path == "none" {
+		return "", fmt.Errorf("access log is not enabled in %s", c.config.ConfigPath)
+	}
+
+	return path, nil
+}
+
+// FollowAccessLog tails the access log from its current end, surviving
+// truncation and rotation, and calls handle for every parsed record until
+// ctx is cancelled.
+func FollowAccessLog(ctx context.Context, path string, handle func(AccessRecord)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(file)
+
+	var partial string
+	for {
+		line, err := reader.ReadString('\n')
+		offset += int64(len(line))
+
+		if err == nil {
+			if record, ok := ParseAccessLine(partial + strings.TrimRight(line, "\r\n")); ok {
+				handle(record)
+			}
+			partial = ""
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+		partial += line
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Second):
+		}
+
+		current, statErr := os.Stat(path)
+		opened, openedErr := file.Stat()
+		if statErr != nil || openedErr != nil {
+			continue
+		}
+
+		if !os.SameFile(current, opened) || current.Size() < offset {
+			reopened, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			file.Close()
+			file = reopened
+			reader.Reset(file)
+			offset = 0
+			partial = ""
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package xray
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Certificate is a TLS certificate served by an inbound, as PEM. Source is
+// the certificate file, or "inline" for certificates in the config itself.
+type Certificate struct {
+	InboundTag string `json:"inbound_tag"`
+	Source     string `json:"source"`
+	PEM        []byte `json:"pem"`
+}
+
+// tlsInbound is the part of an inbound that holds its certificates.
+type tlsInbound struct {
+	Tag            string `json:"tag"`
+	StreamSettings struct {
+		TLSSettings struct {
+			Certificates []struct {
+				CertificateFile string          `json:"certificateFile"`
+				Certificate     json.RawMessage `json:"certificate"`
+				Usage           string          `json:"usage"`
+			} `json:"certificates"`
+		} `json:"tlsSettings"`
+	} `json:"streamSettings"`
+}
+
+// Certificates returns the certificates the inbounds of the Xray config
+// serve to clients. CA certificates used to issue or verify are left out,
+// and so are private keys.
+func (c *Client) Certificates() ([]Certificate, error) {
+	if c.remote != nil {
+		return c.remote.Certificates()
+	}
+
+	config, err := c.readXrayConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var certificates []Certificate
+	for _, raw := range config.Inbounds {
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return nil, err
+		}
+		var inbound tlsInbound
+		if err := json.Unmarshal(data, &inbound); err != nil {
+			continue
+		}
+
+		for _, entry := range inbound.StreamSettings.TLSSettings.Certificates {
+			if entry.Usage != "" && entry.Usage != "encipherment" {
+				continue
+			}
+
+			certificate := Certificate{InboundTag: inbound.Tag}
+			switch {
+			case entry.CertificateFile != "":
+				certificate.Source = entry.CertificateFile
+				if certificate.PEM, err = os.ReadFile(entry.CertificateFile); err != nil {
+					return nil, fmt.Errorf("failed to read certificate of inbound %s: %v", inbound.Tag, err)
+				}
+			case len(entry.Certificate) > 0:
+				certificate.Source = "inline"
+				if certificate.PEM, err = inlinePEM(entry.Certificate); err != nil {
+					return nil, fmt.Errorf("failed to parse certificate of inbound %s: %v", inbound.Tag, err)
+				}
+			default:
+				continue
+			}
+			certificates = append(certificates, certificate)
+		}
+	}
+	return certificates, nil
+}
+
+// inlinePEM joins an inline certificate, which Xray takes as a list of
+// lines or as a single string.
+func inlinePEM(raw json.RawMessage) ([]byte, error) {
+	var lines []string
+	if err := json.Unmarshal(raw, &lines); err == nil {
+		return []byte(strings.Join(lines, "\n")), nil
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return nil, err
+	}
+	return []byte(text), nil
+}
@@ -7,18 +7,39 @@ import (
 	"os"
 	"os/exec"
 	"xray-telegram-bot/config"
+	"xray-telegram-bot/configfile"
 	"xray-telegram-bot/models"
 )
 
 type Client struct {
-	config *config.Config
+	config    *config.Config
+	restarter Restarter
+	// fallback queues config file changes; remote is set instead for
+	// clients managing Xray on another host.
+	fallback *fallbackQueue
+	remote   Remote
 }
 
 func NewClient(cfg *config.Config) *Client {
-	return &Client{config: cfg}
+	restarter, err := NewRestarter(cfg)
+	if err != nil {
+		log.Printf("Warning: %v, restarting Xray through systemd", err)
+		restarter = commandRestarter{"systemctl", "restart", "xray"}
+	}
+	return newLocalClient(cfg, restarter)
+}
+
+func newLocalClient(cfg *config.Config, restarter Restarter) *Client {
+	c := &Client{config: cfg, restarter: restarter}
+	c.fallback = newFallbackQueue(cfg.FallbackDebounce, cfg.RestartBudget, c.flush)
+	return c
 }
 
 func (c *Client) TestAPI() error {
+	if c.remote != nil {
+		return c.remote.Health()
+	}
+
 	cmd := exec.Command("xray", "api", "inbounduser",
 		"--server="+c.config.XrayAPIAddress,
 		"-tag="+c.config.XrayTag)
@@ -27,41 +48,73 @@ func (c *Client) TestAPI() error {
 	if err != nil {
 		return fmt.Errorf("API test failed: %v, output: %s", err, string(output))
 	}
-
-	log.Printf("API test successful: %s", string(output))
 	return nil
 }
 
-func (c *Client) AddUser(userUUID, email string) error {
+// AddUser adds the user through the API, or queues the change for the
+// config file when the API is unavailable.
+func (c *Client) AddUser(userUUID, email string) (Status, error) {
+	if c.remote != nil {
+		return c.remote.AddUser(userUUID, email)
+	}
+
 	if err := c.addUserToXrayAPI(userUUID, email); err != nil {
-		log.Printf("API method failed: %v, trying config file method", err)
+		log.Printf("API method failed: %v, queueing config file change", err)
+		c.queueChange(email, configChange{add: true, uuid: userUUID})
+		return Pending, nil
+	}
 
-		if err := c.addUserToConfig(userUUID, email); err != nil {
-			return fmt.Errorf("both API and config methods failed: %v", err)
-		}
+	c.dropChange(email)
+	return Applied, nil
+}
 
-		if err := c.restartXray(); err != nil {
-			log.Printf("Warning: failed to restart Xray: %v", err)
-		}
+// RemoveUser removes the user through the API, or queues the change for
+// the config file when the API is unavailable.
+func (c *Client) RemoveUser(email string) (Status, error) {
+	if c.remote != nil {
+		return c.remote.RemoveUser(email)
 	}
 
-	return nil
+	if err := c.removeUserFromXrayAPI(email); err != nil {
+		log.Printf("API method failed: %v, queueing config file change", err)
+		c.queueChange(email, configChange{})
+		return Pending, nil
+	}
+
+	c.dropChange(email)
+	return Applied, nil
 }
 
-func (c *Client) RemoveUser(email string) error {
-	if err := c.removeUserFromXrayAPI(email); err != nil {
-		log.Printf("API method failed: %v, trying config file method", err)
+// UserEmails returns the emails of the users of the client's inbound in the
+// running Xray.
+func (c *Client) UserEmails() ([]string, error) {
+	if c.remote != nil {
+		return c.remote.UserEmails()
+	}
 
-		if err := c.removeUserFromConfig(email); err != nil {
-			return fmt.Errorf("both API and config methods failed: %v", err)
-		}
+	cmd := exec.Command("xray", "api", "inbounduser",
+		"--server="+c.config.XrayAPIAddress,
+		"-tag="+c.config.XrayTag)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users via CLI: %v, output: %s", err, string(output))
+	}
 
-		if err := c.restartXray(); err != nil {
-			log.Printf("Warning: failed to restart Xray: %v", err)
-		}
+	var response struct {
+		Users []struct {
+			Email string `json:"email"`
+		} `json:"users"`
+	}
+	if err := json.Unmarshal(output, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse users: %v", err)
 	}
 
-	return nil
+	emails := make([]string, 0, len(response.Users))
+	for _, user := range response.Users {
+		emails = append(emails, user.Email)
+	}
+	return emails, nil
 }
 
 func (c *Client) addUserToXrayAPI(userUUID, email string) error {
@@ -105,107 +158,6 @@ func (c *Client) removeUserFromXrayAPI(email string) error {
 	return nil
 }
 
-func (c *Client) addUserToConfig(userUUID, email string) error {
-	config, err := c.readXrayConfig()
-	if err != nil {
-		return fmt.Errorf("failed to read config: %v", err)
-	}
-
-	for i, inbound := range config.Inbounds {
-		inboundMap, ok := inbound.(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		tag, exists := inboundMap["tag"]
-		if !exists || tag != c.config.XrayTag {
-			continue
-		}
-
-		settings, ok := inboundMap["settings"].(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		clients, ok := settings["clients"].([]interface{})
-		if !ok {
-			clients = []interface{}{}
-		}
-
-		newClient := map[string]interface{}{
-			"email": email,
-			"id":    userUUID,
-			"flow":  "xtls-rprx-vision",
-		}
-
-		clients = append(clients, newClient)
-		settings["clients"] = clients
-		config.Inbounds[i] = inboundMap
-
-		if err := c.writeXrayConfig(config); err != nil {
-			return fmt.Errorf("failed to write config: %v", err)
-		}
-
-		log.Printf("User %s added to config file", email)
-		return nil
-	}
-
-	return fmt.Errorf("inbound with tag %s not found", c.config.XrayTag)
-}
-
-func (c *Client) removeUserFromConfig(email string) error {
-	config, err := c.readXrayConfig()
-	if err != nil {
-		return fmt.Errorf("failed to read config: %v", err)
-	}
-
-	for i, inbound := range config.Inbounds {
-		inboundMap, ok := inbound.(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		tag, exists := inboundMap["tag"]
-		if !exists || tag != c.config.XrayTag {
-			continue
-		}
-
-		settings, ok := inboundMap["settings"].(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		clients, ok := settings["clients"].([]interface{})
-		if !ok {
-			continue
-		}
-
-		var newClients []interface{}
-		for _, client := range clients {
-			clientMap, ok := client.(map[string]interface{})
-			if !ok {
-				continue
-			}
-
-			if clientEmail, exists := clientMap["email"]; !exists || clientEmail != email {
-				newClients = append(newClients, client)
-			}
-		}
-
-		settings["clients"] = newClients
-		config.Inbounds[i] = inboundMap
-
-		if err := c.writeXrayConfig(config); err != nil {
-			return fmt.Errorf("failed to write config: %v", err)
-		}
-
-		log.Printf("User %s removed from config file", email)
-		return nil
-	}
-
-	return fmt.Errorf("inbound with tag %s not found", c.config.XrayTag)
-}
-
 func (c *Client) readXrayConfig() (*models.XrayConfig, error) {
 	data, err := os.ReadFile(c.config.ConfigPath)
 	if err != nil {
@@ -221,27 +173,26 @@ func (c *Client) readXrayConfig() (*models.XrayConfig, error) {
 	return &config, nil
 }
 
+// writeXrayConfig replaces the config file once Xray accepts the new
+// config, so that a broken edit never reaches a restart.
 func (c *Client) writeXrayConfig(config *models.XrayConfig) error {
 	data, err := json.MarshalIndent(config, "", "  ")
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(c.config.ConfigPath, data, 0644)
+	return configfile.Write(c.config.ConfigPath, data, 0644, validateConfig)
 }
 
-func (c *Client) restartXray() error {
-	cmd := exec.Command("systemctl", "restart", "xray")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to restart xray: %v, output: %s", err, string(output))
-	}
-
-	log.Println("Xray restarted successfully")
-	return nil
-}
+// validateConfig has Xray load the config without starting.
+var validateConfig = configfile.Command("xray", "run", "-test", "-config")
 
+// InitAPI is a no-op for remote clients; the agent initialises its Xray.
 func (c *Client) InitAPI() error {
+	if c.remote != nil {
+		return nil
+	}
+
 	config, err := c.readXrayConfig()
 	if err != nil {
 		return err
@@ -258,6 +209,7 @@ func (c *Client) InitAPI() error {
 		"services": []string{
 			"HandlerService",
 			"StatsService",
+			"RoutingService",
 		},
 	}
 
@@ -307,7 +259,27 @@ func (c *Client) InitAPI() error {
 	return nil
 }
 
-func (c *Client) GenerateVlessURL(userUUID, name string) string {
+// VlessURL returns the VLESS link of a client of the bot's inbounds on the
+// server at domain and port.
+func VlessURL(domain string, port int, userUUID, name string) string {
 	return fmt.Sprintf("vless://%s@%s:%d?security=tls&type=tcp&flow=xtls-rprx-vision&encryption=none#%s",
-		userUUID, c.config.ServerDomain, c.config.ServerPort, name)
+		userUUID, domain, port, name)
+}
+
+// ForServer returns a client for another server of the registry, sharing
+// everything but the server's own settings. Servers without a config path
+// have no config-file fallback. The bot cannot restart another server's
+// Xray, so config file changes are applied only once it restarts otherwise.
+func (c *Client) ForServer(server *models.Server) *Client {
+	cfg := *c.config
+	cfg.XrayAPIAddress = server.APIAddress
+	cfg.XrayTag = server.InboundTag
+	cfg.ServerDomain = server.Domain
+	cfg.ServerPort = server.Port
+	cfg.ConfigPath = server.ConfigPath
+
+	if c.remote != nil {
+		return &Client{config: &cfg, remote: c.remote}
+	}
+	return newLocalClient(&cfg, noRestarter{server: server.Name})
 }
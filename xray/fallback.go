@@ -0,0 +1,329 @@
+package xray
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status tells whether a change reached the running Xray.
+type Status string
+
+const (
+	// Applied changes are live.
+	Applied Status = "applied"
+	// Pending changes wait for the next config file batch and restart.
+	Pending Status = "pending"
+)
+
+// restartWindow is the period the restart budget applies to.
+const restartWindow = time.Hour
+
+// retryDelay is how long changes that failed to apply wait before the next
+// attempt, at least the debounce window.
+const retryDelay = time.Minute
+
+// configChange is a queued config file change of one client email.
+type configChange struct {
+	add  bool
+	uuid string
+}
+
+// fallbackQueue collects the config file changes made when the API is
+// unavailable and applies them in one write and one restart per debounce
+// window, within the restart budget.
+type fallbackQueue struct {
+	debounce time.Duration
+	budget   int
+	flush    func()
+
+	mu      sync.Mutex
+	changes map[string]configChange
+	// flushing holds the changes of the batch being applied that no later
+	// change superseded, to be queued again when the batch fails.
+	flushing    map[string]configChange
+	restart     bool
+	timer       *time.Timer
+	restarts    []time.Time
+	lastApplied time.Time
+	lastError   error
+
+	// flushMu serialises batches; configMu guards the config file against
+	// concurrent rewrites.
+	flushMu  sync.Mutex
+	configMu sync.Mutex
+}
+
+func newFallbackQueue(debounce time.Duration, budget int, flush func()) *fallbackQueue {
+	return &fallbackQueue{
+		debounce: debounce,
+		budget:   budget,
+		flush:    flush,
+		changes:  make(map[string]configChange),
+	}
+}
+
+// FallbackStatus reports the config file fallback of a client.
+type FallbackStatus struct {
+	Pending        int
+	RestartPending bool
+	Restarts       int
+	Budget         int
+	LastApplied    time.Time
+	LastError      string
+}
+
+// FallbackStatus returns the state of the config file fallback. ok is false
+// for remote clients, whose agent keeps its own.
+func (c *Client) FallbackStatus() (status FallbackStatus, ok bool) {
+	if c.fallback == nil {
+		return FallbackStatus{}, false
+	}
+
+	q := c.fallback
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pruneRestarts(time.Now())
+	status = FallbackStatus{
+		Pending:        len(q.changes),
+		RestartPending: q.restart,
+		Restarts:       len(q.restarts),
+		Budget:         q.budget,
+		LastApplied:    q.lastApplied,
+	}
+	if q.lastError != nil {
+		status.LastError = q.lastError.Error()
+	}
+	return status, true
+}
+
+// queueChange queues a config file change, replacing an earlier one of the
+// same email.
+func (c *Client) queueChange(email string, change configChange) {
+	q := c.fallback
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.changes[email] = change
+	delete(q.flushing, email)
+	q.schedule(q.debounce)
+}
+
+// dropChange forgets a queued change that a later API call superseded.
+func (c *Client) dropChange(email string) {
+	q := c.fallback
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	delete(q.changes, email)
+	delete(q.flushing, email)
+}
+
+// requestRestart asks for a restart with the next batch, for config file
+// changes written outside the queue.
+func (c *Client) requestRestart() {
+	q := c.fallback
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.restart = true
+	q.schedule(q.debounce)
+}
+
+// schedule flushes the queue after delay unless a flush is scheduled
+// already. It must be called with mu held.
+func (q *fallbackQueue) schedule(delay time.Duration) {
+	if q.timer == nil {
+		q.timer = time.AfterFunc(delay, q.flush)
+	}
+}
+
+// requeue queues the changes of a failed batch again and retries them
+// later. Changes made since the batch started win. It must be called with
+// mu held.
+func (q *fallbackQueue) requeue() {
+	if len(q.flushing) == 0 {
+		return
+	}
+	for email, change := range q.flushing {
+		q.changes[email] = change
+	}
+	q.schedule(max(q.debounce, retryDelay))
+}
+
+// pruneRestarts forgets restarts outside the budget window. It must be
+// called with mu held.
+func (q *fallbackQueue) pruneRestarts(now time.Time) {
+	for len(q.restarts) > 0 && now.Sub(q.restarts[0]) >= restartWindow {
+		q.restarts = q.restarts[1:]
+	}
+}
+
+// Flush applies the queued config file changes right away, for processes
+// that exit before the debounce window ends. It returns the error of the
+// batch, if any.
+func (c *Client) Flush() error {
+	if c.fallback == nil {
+		return nil
+	}
+
+	q := c.fallback
+	q.mu.Lock()
+	if q.timer != nil {
+		q.timer.Stop()
+	}
+	q.mu.Unlock()
+
+	c.flush()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.restart {
+		return fmt.Errorf("config file changed but Xray was not restarted, the restart budget of %d per hour is used up", q.budget)
+	}
+	return q.lastError
+}
+
+// flush writes the queued changes in one go and restarts Xray once, unless
+// the restart budget is used up, in which case the restart is retried when
+// the oldest restart leaves the window.
+func (c *Client) flush() {
+	q := c.fallback
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+
+	q.mu.Lock()
+	changes := q.changes
+	q.changes = make(map[string]configChange)
+	q.flushing = make(map[string]configChange, len(changes))
+	for email, change := range changes {
+		q.flushing[email] = change
+	}
+	q.timer = nil
+	q.mu.Unlock()
+
+	if len(changes) > 0 {
+		err := c.applyConfigChanges(changes)
+
+		q.mu.Lock()
+		q.lastError = err
+		if err == nil {
+			q.restart = true
+		} else {
+			q.requeue()
+		}
+		q.flushing = nil
+		q.mu.Unlock()
+
+		if err != nil {
+			log.Printf("Error applying %d queued config changes: %v", len(changes), err)
+			return
+		}
+		log.Printf("Applied %d queued config changes", len(changes))
+	}
+
+	now := time.Now()
+	q.mu.Lock()
+	if !q.restart {
+		q.mu.Unlock()
+		return
+	}
+	q.pruneRestarts(now)
+	if q.budget > 0 && len(q.restarts) >= q.budget {
+		wait := restartWindow - now.Sub(q.restarts[0])
+		log.Printf("Warning: Xray restart budget of %d per hour used up, restarting in %s", q.budget, wait.Round(time.Second))
+		q.schedule(wait)
+		q.mu.Unlock()
+		return
+	}
+	q.restart = false
+	q.restarts = append(q.restarts, now)
+	q.mu.Unlock()
+
+	err := c.restartXray()
+
+	q.mu.Lock()
+	q.lastError = err
+	if err == nil {
+		q.lastApplied = time.Now()
+	}
+	q.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Warning: failed to restart Xray: %v", err)
+	}
+}
+
+// applyConfigChanges adds and removes clients of the client's inbound in
+// the config file with a single write.
+func (c *Client) applyConfigChanges(changes map[string]configChange) error {
+	c.fallback.configMu.Lock()
+	defer c.fallback.configMu.Unlock()
+
+	config, err := c.readXrayConfig()
+	if err != nil {
+		return fmt.Errorf("failed to read config: %v", err)
+	}
+
+	for _, inbound := range config.Inbounds {
+		inboundMap, ok := inbound.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		tag, exists := inboundMap["tag"]
+		if !exists || tag != c.config.XrayTag {
+			continue
+		}
+
+		settings, ok := inboundMap["settings"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		clients, _ := settings["clients"].([]interface{})
+
+		// Every changed email is dropped first, so that adds replace an
+		// existing entry instead of duplicating it.
+		var newClients []interface{}
+		for _, client := range clients {
+			clientMap, ok := client.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			email, _ := clientMap["email"].(string)
+			if _, changed := changes[email]; !changed {
+				newClients = append(newClients, client)
+			}
+		}
+
+		emails := make([]string, 0, len(changes))
+		for email := range changes {
+			emails = append(emails, email)
+		}
+		sort.Strings(emails)
+
+		for _, email := range emails {
+			if change := changes[email]; change.add {
+				newClients = append(newClients, map[string]interface{}{
+					"email": email,
+					"id":    change.uuid,
+					"flow":  "xtls-rprx-vision",
+				})
+			}
+		}
+
+		settings["clients"] = newClients
+
+		if err := c.writeXrayConfig(config); err != nil {
+			return fmt.Errorf("failed to write config: %v", err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("inbound with tag %s not found", c.config.XrayTag)
+}
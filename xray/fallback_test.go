@@ -0,0 +1,83 @@
+package xray
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"xray-telegram-bot/config"
+)
+
+type countingRestarter struct {
+	restarts int
+}
+
+func (r *countingRestarter) Restart() error {
+	r.restarts++
+	return nil
+}
+
+// newFallbackClient returns a client whose API is down, so that every
+// change goes to the config file at path.
+func newFallbackClient(t *testing.T, path string) (*Client, *countingRestarter) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\ncase \"$3\" in add|remove) exit 1 ;; esac\nexit 0\n"
+	if err := os.WriteFile(filepath.Join(dir, "xray"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	restarter := &countingRestarter{}
+	client := newLocalClient(&config.Config{XrayTag: "vless-in", ConfigPath: path, FallbackDebounce: time.Hour}, restarter)
+	return client, restarter
+}
+
+func TestFallbackRequeuesFailedBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	client, restarter := newFallbackClient(t, path)
+
+	for _, email := range []string{"user_1@myserver", "user_2@myserver"} {
+		if status, err := client.AddUser("11111111-1111-1111-1111-111111111111", email); err != nil || status != Pending {
+			t.Fatalf("AddUser returned %q, %v", status, err)
+		}
+	}
+	if _, err := client.RemoveUser("user_3@myserver"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The config file is missing, so the batch fails and stays queued.
+	if err := client.Flush(); err == nil {
+		t.Fatal("batch without a config file applied")
+	}
+	if status, _ := client.FallbackStatus(); status.Pending != 3 {
+		t.Fatalf("%d changes queued after a failed batch, want 3", status.Pending)
+	}
+
+	// A later change of the same email replaces the failed one.
+	if _, err := client.RemoveUser("user_2@myserver"); err != nil {
+		t.Fatal(err)
+	}
+
+	config := `{"inbounds":[{"tag":"vless-in","settings":{"clients":[
+		{"email":"user_2@myserver","id":"22222222-2222-2222-2222-222222222222"},
+		{"email":"user_3@myserver","id":"33333333-3333-3333-3333-333333333333"}]}}]}`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := string(data)
+	if !strings.Contains(written, "user_1@myserver") || strings.Contains(written, "user_2@myserver") || strings.Contains(written, "user_3@myserver") {
+		t.Fatalf("unexpected config after retry:\n%s", written)
+	}
+	if status, _ := client.FallbackStatus(); status.Pending != 0 || restarter.restarts != 1 {
+		t.Fatalf("%d changes queued and %d restarts after the retry, want 0 and 1", status.Pending, restarter.restarts)
+	}
+}
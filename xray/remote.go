@@ -0,0 +1,46 @@
+package xray
+
+import (
+	"os"
+	"xray-telegram-bot/config"
+)
+
+// Remote carries out the operations of a Client on another host, e.g.
+// through the node agent.
+type Remote interface {
+	Health() error
+	AddUser(userUUID, email string) (Status, error)
+	RemoveUser(email string) (Status, error)
+	UserEmails() ([]string, error)
+	OnlineIPs(email string) ([]string, error)
+	UserTraffic(email string) (int64, error)
+	InboundTraffic() (int64, error)
+	SyncRoutingProfiles(profiles []config.RoutingProfile, emails map[string][]string) error
+	ConfigSnapshot() ([]byte, error)
+	Certificates() ([]Certificate, error)
+	Restart() error
+}
+
+// WithRemote returns a copy of the client that manages Xray through remote
+// instead of the local CLI, config file and systemctl. Links are still
+// generated from the client's own server settings.
+func (c *Client) WithRemote(remote Remote) *Client {
+	cfg := *c.config
+	return &Client{config: &cfg, remote: remote}
+}
+
+// ConfigSnapshot returns the Xray config as it is on disk.
+func (c *Client) ConfigSnapshot() ([]byte, error) {
+	if c.remote != nil {
+		return c.remote.ConfigSnapshot()
+	}
+	return os.ReadFile(c.config.ConfigPath)
+}
+
+// Restart restarts Xray.
+func (c *Client) Restart() error {
+	if c.remote != nil {
+		return c.remote.Restart()
+	}
+	return c.restartXray()
+}
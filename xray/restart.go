@@ -0,0 +1,281 @@
+package xray
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"xray-telegram-bot/config"
+)
+
+const (
+	// livenessTimeout is how long a restart may take until the API answers.
+	livenessTimeout = 30 * time.Second
+	// stopTimeout is how long a supervised Xray gets to exit on SIGTERM.
+	stopTimeout = 10 * time.Second
+)
+
+// Restarter restarts or reloads Xray so that it applies its config file.
+type Restarter interface {
+	Restart() error
+}
+
+// NewRestarter returns the strategy configured in XrayRestart:
+// "systemd[:unit]", "openrc[:service]", "docker:<container>",
+// "sighup:<pid file>", "sigterm:<pid file>" or "process".
+func NewRestarter(cfg *config.Config) (Restarter, error) {
+	kind, arg, _ := strings.Cut(cfg.XrayRestart, ":")
+	switch kind {
+	case "", "systemd":
+		return commandRestarter{"systemctl", "restart", orDefault(arg, "xray")}, nil
+	case "openrc":
+		return commandRestarter{"rc-service", orDefault(arg, "xray"), "restart"}, nil
+	case "docker":
+		if arg == "" {
+			return nil, fmt.Errorf("docker restart needs a container")
+		}
+		return newDockerRestarter(cfg.DockerSocket, arg), nil
+	case "sighup", "sigterm":
+		if arg == "" {
+			return nil, fmt.Errorf("%s restart needs a pid file", kind)
+		}
+		signal := syscall.SIGHUP
+		if kind == "sigterm" {
+			signal = syscall.SIGTERM
+		}
+		return signalRestarter{pidFile: arg, signal: signal}, nil
+	case "process":
+		return &processRestarter{configPath: cfg.ConfigPath}, nil
+	default:
+		return nil, fmt.Errorf("unknown restart strategy %q", cfg.XrayRestart)
+	}
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// noRestarter refuses to restart the Xray of a server the bot does not run
+// on; the agent restarts the Xray of its node.
+type noRestarter struct {
+	server string
+}
+
+func (r noRestarter) Restart() error {
+	return fmt.Errorf("Xray of server %s cannot be restarted without an agent", r.server)
+}
+
+// commandRestarter runs a service manager command such as systemctl.
+type commandRestarter []string
+
+func (c commandRestarter) Restart() error {
+	output, err := exec.Command(c[0], c[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v, output: %s", strings.Join(c, " "), err, string(output))
+	}
+	return nil
+}
+
+// dockerRestarter restarts the Xray container through the Docker Engine API
+// on its unix socket.
+type dockerRestarter struct {
+	container string
+	http      *http.Client
+}
+
+func newDockerRestarter(socket, container string) *dockerRestarter {
+	return &dockerRestarter{
+		container: container,
+		http: &http.Client{
+			Timeout: livenessTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+func (d *dockerRestarter) Restart() error {
+	// The host is ignored, requests go to the socket.
+	endpoint := fmt.Sprintf("http://docker/containers/%s/restart?t=%d", url.PathEscape(d.container), int(stopTimeout.Seconds()))
+	resp, err := d.http.Post(endpoint, "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("docker restart %s: %v", d.container, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("docker restart %s: status %d: %s", d.container, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// signalRestarter signals the Xray process named in a pid file. SIGTERM
+// relies on a supervisor to start Xray again.
+type signalRestarter struct {
+	pidFile string
+	signal  syscall.Signal
+}
+
+func (s signalRestarter) Restart() error {
+	data, err := os.ReadFile(s.pidFile)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid pid file %s: %v", s.pidFile, err)
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	if err := process.Signal(s.signal); err != nil {
+		return fmt.Errorf("failed to signal xray (pid %d): %v", pid, err)
+	}
+	return nil
+}
+
+// processRestarter runs Xray as a child process of the bot and starts it
+// again whenever it exits on its own.
+type processRestarter struct {
+	configPath string
+
+	mu   sync.Mutex
+	cmd  *exec.Cmd
+	done chan struct{}
+}
+
+// Start starts Xray.
+func (p *processRestarter) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cmd != nil {
+		return nil
+	}
+	return p.start()
+}
+
+// Restart stops Xray, killing it when it does not exit in time, and starts
+// it again.
+func (p *processRestarter) Restart() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if cmd, done := p.cmd, p.done; cmd != nil {
+		// Clearing cmd first tells supervise the exit was requested.
+		p.cmd = nil
+		cmd.Process.Signal(syscall.SIGTERM)
+		select {
+		case <-done:
+		case <-time.After(stopTimeout):
+			cmd.Process.Kill()
+			<-done
+		}
+	}
+	return p.start()
+}
+
+// start must be called with mu held.
+func (p *processRestarter) start() error {
+	cmd := exec.Command("xray", "run", "-config", p.configPath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start xray: %v", err)
+	}
+
+	done := make(chan struct{})
+	p.cmd, p.done = cmd, done
+	log.Printf("Started Xray (pid %d)", cmd.Process.Pid)
+
+	go p.supervise(cmd, done)
+	return nil
+}
+
+func (p *processRestarter) supervise(cmd *exec.Cmd, done chan struct{}) {
+	err := cmd.Wait()
+	close(done)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cmd != cmd {
+		return
+	}
+	p.cmd = nil
+	log.Printf("Xray exited unexpectedly: %v", err)
+	go p.startLater()
+}
+
+// startLater starts Xray again after a pause, until it succeeds or a
+// restart got there first.
+func (p *processRestarter) startLater() {
+	for {
+		time.Sleep(5 * time.Second)
+
+		p.mu.Lock()
+		if p.cmd != nil {
+			p.mu.Unlock()
+			return
+		}
+		err := p.start()
+		p.mu.Unlock()
+
+		if err == nil {
+			return
+		}
+		log.Printf("Error starting Xray: %v", err)
+	}
+}
+
+// Supervise starts Xray when the bot runs it as a child process and does
+// nothing otherwise.
+func (c *Client) Supervise() error {
+	if process, ok := c.restarter.(*processRestarter); ok {
+		return process.Start()
+	}
+	return nil
+}
+
+// restartXray restarts Xray with the configured strategy and waits until
+// its API answers again.
+func (c *Client) restartXray() error {
+	if err := c.restarter.Restart(); err != nil {
+		return fmt.Errorf("failed to restart xray: %v", err)
+	}
+
+	deadline := time.Now().Add(livenessTimeout)
+	for {
+		err := c.TestAPI()
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("xray restarted but its API did not answer within %s: %v", livenessTimeout, err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	log.Println("Xray restarted successfully")
+	return nil
+}
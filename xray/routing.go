@@ -0,0 +1,228 @@
+package xray
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"xray-telegram-bot/config"
+	"xray-telegram-bot/configfile"
+)
+
+// profileRuleTag prefixes the ruleTag of every routing rule the bot manages.
+// Rules without it belong to the operator and are never touched.
+const profileRuleTag = "profile:"
+
+// SyncRoutingProfiles rewrites the bot-managed routing rules so that each
+// profile's rules apply to the given user emails. The rules are persisted in
+// the config file and then pushed to the running Xray through the
+// RoutingService API, with a batched restart as the fallback. Only the
+// routing and outbounds sections of the file are touched.
+func (c *Client) SyncRoutingProfiles(profiles []config.RoutingProfile, emails map[string][]string) error {
+	if c.remote != nil {
+		return c.remote.SyncRoutingProfiles(profiles, emails)
+	}
+
+	c.fallback.configMu.Lock()
+	defer c.fallback.configMu.Unlock()
+
+	data, err := os.ReadFile(c.config.ConfigPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %v", err)
+	}
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("failed to read config: %v", err)
+	}
+	if config == nil {
+		config = make(map[string]json.RawMessage)
+	}
+
+	routing := make(map[string]json.RawMessage)
+	var rules, outbounds []json.RawMessage
+	if err := unmarshalSection(config["routing"], &routing); err != nil {
+		return fmt.Errorf("failed to read routing: %v", err)
+	}
+	if err := unmarshalSection(routing["rules"], &rules); err != nil {
+		return fmt.Errorf("failed to read routing rules: %v", err)
+	}
+	if err := unmarshalSection(config["outbounds"], &outbounds); err != nil {
+		return fmt.Errorf("failed to read outbounds: %v", err)
+	}
+
+	newRules := profileRules(profiles, emails)
+	allRules := newRules
+	for _, rule := range rules {
+		var tagged struct {
+			RuleTag string `json:"ruleTag"`
+		}
+		if json.Unmarshal(rule, &tagged) == nil && strings.HasPrefix(tagged.RuleTag, profileRuleTag) {
+			continue
+		}
+		allRules = append(allRules, rule)
+	}
+
+	added := missingOutbounds(outbounds, newRules)
+	for _, outbound := range added {
+		outbounds = append(outbounds, marshalRaw(outbound))
+	}
+
+	routing["rules"] = marshalRaw(allRules)
+	config["routing"] = marshalRaw(routing)
+	if len(added) > 0 {
+		config["outbounds"] = marshalRaw(outbounds)
+	}
+
+	data, err = json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to write config: %v", err)
+	}
+	if err := configfile.Write(c.config.ConfigPath, data, 0644, validateConfig); err != nil {
+		return fmt.Errorf("failed to write config: %v", err)
+	}
+
+	// Rules must not point at outbounds the running Xray does not have yet.
+	if len(added) > 0 {
+		if err := c.pushOutbounds(added); err != nil {
+			log.Printf("API method failed: %v, queueing a restart", err)
+			c.requestRestart()
+			return nil
+		}
+	}
+	if err := c.pushRoutingRules(routing); err != nil {
+		log.Printf("API method failed: %v, queueing a restart", err)
+		c.requestRestart()
+	}
+
+	return nil
+}
+
+// unmarshalSection decodes a section of the config file, leaving v as is
+// when the section is missing.
+func unmarshalSection(raw json.RawMessage, v interface{}) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// marshalRaw encodes values built from decoded JSON, which always encode.
+func marshalRaw(v interface{}) json.RawMessage {
+	data, _ := json.Marshal(v)
+	return data
+}
+
+func profileRules(profiles []config.RoutingProfile, emails map[string][]string) []interface{} {
+	var rules []interface{}
+	for _, profile := range profiles {
+		users := emails[profile.Name]
+		if len(users) == 0 {
+			continue
+		}
+		sort.Strings(users)
+
+		for i, template := range profile.Rules {
+			rule := make(map[string]interface{}, len(template)+3)
+			for key, value := range template {
+				rule[key] = value
+			}
+			rule["type"] = "field"
+			rule["user"] = users
+			rule["ruleTag"] = fmt.Sprintf("%s%s:%d", profileRuleTag, profile.Name, i)
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// builtinOutbounds are added on demand when a profile routes to them and the
+// operator has not defined an outbound with that tag.
+var builtinOutbounds = map[string]string{
+	"direct": "freedom",
+	"block":  "blackhole",
+}
+
+// missingOutbounds returns the built-in outbounds the rules route to that
+// are not among outbounds.
+func missingOutbounds(outbounds []json.RawMessage, rules []interface{}) []map[string]interface{} {
+	existing := make(map[string]bool)
+	for _, outbound := range outbounds {
+		var tagged struct {
+			Tag string `json:"tag"`
+		}
+		if json.Unmarshal(outbound, &tagged) == nil && tagged.Tag != "" {
+			existing[tagged.Tag] = true
+		}
+	}
+
+	var missing []map[string]interface{}
+	for _, rule := range rules {
+		tag, _ := rule.(map[string]interface{})["outboundTag"].(string)
+		protocol, builtin := builtinOutbounds[tag]
+		if !builtin || existing[tag] {
+			continue
+		}
+
+		missing = append(missing, map[string]interface{}{
+			"protocol": protocol,
+			"tag":      tag,
+		})
+		existing[tag] = true
+	}
+	return missing
+}
+
+// pushOutbounds adds outbounds to the running Xray through the
+// HandlerService API.
+func (c *Client) pushOutbounds(outbounds []map[string]interface{}) error {
+	output, err := c.runAPIWithFile("ado", map[string]interface{}{"outbounds": outbounds})
+	if err != nil {
+		return fmt.Errorf("failed to add outbounds via CLI: %v, output: %s", err, output)
+	}
+
+	log.Printf("Outbounds added: %s", output)
+	return nil
+}
+
+// pushRoutingRules replaces the rules of the running Xray with the given
+// routing section.
+func (c *Client) pushRoutingRules(routing map[string]json.RawMessage) error {
+	output, err := c.runAPIWithFile("adrules", map[string]interface{}{"routing": routing})
+	if err != nil {
+		return fmt.Errorf("failed to push routing rules via CLI: %v, output: %s", err, output)
+	}
+
+	log.Printf("Routing rules updated: %s", output)
+	return nil
+}
+
+// runAPIWithFile runs an xray api command that reads its input from a
+// config file, writing body to a temporary one.
+func (c *Client) runAPIWithFile(command string, body interface{}) (string, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.CreateTemp("", "xray-"+command+"-*.json")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	output, err := exec.Command("xray", "api", command,
+		"--server="+c.config.XrayAPIAddress,
+		file.Name()).CombinedOutput()
+	return string(output), err
+}
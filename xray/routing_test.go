@@ -0,0 +1,129 @@
+package xray
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"xray-telegram-bot/config"
+)
+
+// fakeRoutingXray puts an xray binary on PATH that logs its API commands to
+// the returned file and fails the ones listed in failing.
+func fakeRoutingXray(t *testing.T, failing string) string {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\n[ \"$1\" = api ] && echo \"$2\" >> " + calls + "\n" +
+		"case \"$2\" in " + failing + ") exit 1 ;; esac\nexit 0\n"
+	if err := os.WriteFile(filepath.Join(dir, "xray"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return calls
+}
+
+const routingTestConfig = `{
+  "log": {"loglevel": "warning"},
+  "stats": {},
+  "policy": {"levels": {"0": {"statsUserUplink": true, "statsUserDownlink": true}}},
+  "dns": {"servers": ["1.1.1.1"]},
+  "inbounds": [{"tag": "vless-in", "port": 443}],
+  "outbounds": [{"tag": "out", "protocol": "freedom"}],
+  "routing": {
+    "domainStrategy": "IPIfNonMatch",
+    "rules": [
+      {"type": "field", "user": ["user_9@myserver"], "outboundTag": "direct", "ruleTag": "profile:old:0"},
+      {"type": "field", "inboundTag": ["api"], "outboundTag": "api"}
+    ]
+  }
+}`
+
+var blockAds = []config.RoutingProfile{{
+	Name:  "noads",
+	Rules: []map[string]interface{}{{"domain": []interface{}{"geosite:category-ads-all"}, "outboundTag": "block"}},
+}}
+
+func TestSyncRoutingProfiles(t *testing.T) {
+	calls := fakeRoutingXray(t, "none")
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(routingTestConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	restarter := &countingRestarter{}
+	client := newLocalClient(&config.Config{ConfigPath: path, FallbackDebounce: time.Hour}, restarter)
+
+	if err := client.SyncRoutingProfiles(blockAds, map[string][]string{"noads": {"user_1@myserver"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written struct {
+		Stats     json.RawMessage
+		Policy    json.RawMessage
+		DNS       json.RawMessage
+		Outbounds []map[string]interface{}
+		Routing   struct {
+			DomainStrategy string
+			Rules          []map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatal(err)
+	}
+	if string(written.Stats) != "{}" || !strings.Contains(string(written.Policy), "statsUserUplink") || !strings.Contains(string(written.DNS), "1.1.1.1") {
+		t.Fatalf("top-level sections lost:\n%s", data)
+	}
+	if written.Routing.DomainStrategy != "IPIfNonMatch" || len(written.Routing.Rules) != 2 ||
+		written.Routing.Rules[0]["ruleTag"] != "profile:noads:0" || written.Routing.Rules[1]["outboundTag"] != "api" {
+		t.Fatalf("unexpected routing:\n%s", data)
+	}
+	if len(written.Outbounds) != 2 || written.Outbounds[1]["tag"] != "block" || written.Outbounds[1]["protocol"] != "blackhole" {
+		t.Fatalf("unexpected outbounds:\n%s", data)
+	}
+
+	// The outbound reaches the running Xray before the rules pointing at it.
+	log, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(log) != "ado\nadrules\n" {
+		t.Fatalf("unexpected API calls:\n%s", log)
+	}
+
+	// Outbounds are added only once.
+	os.Remove(calls)
+	if err := client.SyncRoutingProfiles(blockAds, map[string][]string{"noads": {"user_1@myserver", "user_2@myserver"}}); err != nil {
+		t.Fatal(err)
+	}
+	if log, _ := os.ReadFile(calls); string(log) != "adrules\n" {
+		t.Fatalf("unexpected API calls:\n%s", log)
+	}
+	if err := client.Flush(); err != nil || restarter.restarts != 0 {
+		t.Fatalf("%d restarts, %v", restarter.restarts, err)
+	}
+}
+
+func TestSyncRoutingProfilesRestartsWithoutOutboundAPI(t *testing.T) {
+	calls := fakeRoutingXray(t, "ado")
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(routingTestConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	restarter := &countingRestarter{}
+	client := newLocalClient(&config.Config{ConfigPath: path, FallbackDebounce: time.Hour}, restarter)
+
+	if err := client.SyncRoutingProfiles(blockAds, map[string][]string{"noads": {"user_1@myserver"}}); err != nil {
+		t.Fatal(err)
+	}
+	if log, _ := os.ReadFile(calls); string(log) != "ado\n" {
+		t.Fatalf("rules pushed without their outbound:\n%s", log)
+	}
+	if err := client.Flush(); err != nil || restarter.restarts != 1 {
+		t.Fatalf("%d restarts, %v, want 1", restarter.restarts, err)
+	}
+}
@@ -0,0 +1,89 @@
+package xray
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+)
+
+// OnlineIPs asks the StatsService which IPs the user is connected from right
+// now. It needs "statsUserOnline" enabled in the Xray policy.
+func (c *Client) OnlineIPs(email string) ([]string, error) {
+	if c.remote != nil {
+		return c.remote.OnlineIPs(email)
+	}
+
+	cmd := exec.Command("xray", "api", "statsonlineiplist",
+		"--server="+c.config.XrayAPIAddress,
+		"-email="+email)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get online IPs via CLI: %v, output: %s", err, string(output))
+	}
+
+	var response struct {
+		IPs map[string]int64 `json:"ips"`
+	}
+	if err := json.Unmarshal(output, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse online IPs: %v", err)
+	}
+
+	ips := make([]string, 0, len(response.IPs))
+	for ip := range response.IPs {
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// UserTraffic returns the bytes the user sent and received since Xray
+// started. It needs "statsUserUplink" and "statsUserDownlink" in the policy.
+func (c *Client) UserTraffic(email string) (int64, error) {
+	if c.remote != nil {
+		return c.remote.UserTraffic(email)
+	}
+	return c.queryTraffic("user>>>" + email + ">>>traffic")
+}
+
+// InboundTraffic returns the bytes that passed the client's inbound since
+// Xray started. It needs "statsInboundUplink" and "statsInboundDownlink" in
+// the system policy.
+func (c *Client) InboundTraffic() (int64, error) {
+	if c.remote != nil {
+		return c.remote.InboundTraffic()
+	}
+	return c.queryTraffic("inbound>>>" + c.config.XrayTag + ">>>traffic")
+}
+
+// queryTraffic sums the uplink and downlink counters matching pattern.
+func (c *Client) queryTraffic(pattern string) (int64, error) {
+	cmd := exec.Command("xray", "api", "statsquery",
+		"--server="+c.config.XrayAPIAddress,
+		"-pattern="+pattern)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("failed to query traffic via CLI: %v, output: %s", err, string(output))
+	}
+
+	var response struct {
+		Stat []struct {
+			Name string `json:"name"`
+			// Value is an int64, which protobuf JSON may encode as a string.
+			Value json.Number `json:"value"`
+		} `json:"stat"`
+	}
+	if err := json.Unmarshal(output, &response); err != nil {
+		return 0, fmt.Errorf("failed to parse traffic stats: %v", err)
+	}
+
+	var total int64
+	for _, stat := range response.Stat {
+		value, err := stat.Value.Int64()
+		if err != nil {
+			continue
+		}
+		total += value
+	}
+	return total, nil
+}
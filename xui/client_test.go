@@ -0,0 +1,246 @@
+package xui
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"xray-telegram-bot/models"
+)
+
+// stubPanel serves the part of the 3x-ui API the client uses, for one
+// inbound, behind the panel's session cookie.
+type stubPanel struct {
+	mu       sync.Mutex
+	sessions map[string]bool
+	logins   int
+	clients  []panelClient
+	traffic  map[string]clientTraffic
+}
+
+func newStubPanel(t *testing.T) (*stubPanel, *httptest.Server) {
+	panel := &stubPanel{sessions: make(map[string]bool), traffic: make(map[string]clientTraffic)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login", panel.login)
+	mux.HandleFunc("GET /panel/api/inbounds/list", panel.session(panel.list))
+	mux.HandleFunc("GET /panel/api/inbounds/get/{id}", panel.session(panel.get))
+	mux.HandleFunc("POST /panel/api/inbounds/addClient", panel.session(panel.addClient))
+	mux.HandleFunc("POST /panel/api/inbounds/updateClient/{uuid}", panel.session(panel.updateClient))
+	mux.HandleFunc("POST /panel/api/inbounds/{id}/delClient/{uuid}", panel.session(panel.delClient))
+	mux.HandleFunc("GET /panel/api/inbounds/getClientTraffics/{email}", panel.session(panel.clientTraffics))
+
+	server := httptest.NewServer(http.StripPrefix("/xui", mux))
+	t.Cleanup(server.Close)
+	return panel, server
+}
+
+func (p *stubPanel) login(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("username") != "admin" || r.FormValue("password") != "secret" {
+		reply(w, false, "wrong credentials", nil)
+		return
+	}
+
+	p.mu.Lock()
+	p.logins++
+	session := "session-" + strconv.Itoa(p.logins)
+	p.sessions[session] = true
+	p.mu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{Name: "3x-ui", Value: session, Path: "/"})
+	reply(w, true, "", nil)
+}
+
+// session answers 404 without a valid session, as the panel does.
+func (p *stubPanel) session(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("3x-ui")
+		p.mu.Lock()
+		valid := err == nil && p.sessions[cookie.Value]
+		p.mu.Unlock()
+		if !valid {
+			http.NotFound(w, r)
+			return
+		}
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		handler(w, r)
+	}
+}
+
+// expireSessions logs every client out.
+func (p *stubPanel) expireSessions() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sessions = make(map[string]bool)
+}
+
+func (p *stubPanel) inbound() inbound {
+	settings, _ := json.Marshal(map[string][]panelClient{"clients": p.clients})
+	return inbound{ID: 7, Tag: "vless-in", Settings: string(settings)}
+}
+
+func (p *stubPanel) list(w http.ResponseWriter, r *http.Request) {
+	reply(w, true, "", []inbound{{ID: 3, Tag: "other", Settings: `{"clients":[]}`}, p.inbound()})
+}
+
+func (p *stubPanel) get(w http.ResponseWriter, r *http.Request) {
+	reply(w, r.PathValue("id") == "7", "", p.inbound())
+}
+
+// readClient decodes the single client of an addClient or updateClient
+// request.
+func readClient(r *http.Request) (panelClient, bool) {
+	var req clientRequest
+	if json.NewDecoder(r.Body).Decode(&req) != nil || req.ID != 7 {
+		return panelClient{}, false
+	}
+	var settings struct {
+		Clients []panelClient `json:"clients"`
+	}
+	if json.Unmarshal([]byte(req.Settings), &settings) != nil || len(settings.Clients) != 1 {
+		return panelClient{}, false
+	}
+	return settings.Clients[0], true
+}
+
+func (p *stubPanel) addClient(w http.ResponseWriter, r *http.Request) {
+	client, ok := readClient(r)
+	if !ok {
+		reply(w, false, "invalid request", nil)
+		return
+	}
+	for _, existing := range p.clients {
+		if existing.Email == client.Email {
+			reply(w, false, "duplicate email", nil)
+			return
+		}
+	}
+	p.clients = append(p.clients, client)
+	reply(w, true, "", nil)
+}
+
+func (p *stubPanel) updateClient(w http.ResponseWriter, r *http.Request) {
+	client, ok := readClient(r)
+	for i := range p.clients {
+		if ok && p.clients[i].ID == r.PathValue("uuid") {
+			p.clients[i] = client
+			reply(w, true, "", nil)
+			return
+		}
+	}
+	reply(w, false, "client not found", nil)
+}
+
+func (p *stubPanel) delClient(w http.ResponseWriter, r *http.Request) {
+	for i := range p.clients {
+		if r.PathValue("id") == "7" && p.clients[i].ID == r.PathValue("uuid") {
+			p.clients = append(p.clients[:i], p.clients[i+1:]...)
+			reply(w, true, "", nil)
+			return
+		}
+	}
+	reply(w, false, "client not found", nil)
+}
+
+func (p *stubPanel) clientTraffics(w http.ResponseWriter, r *http.Request) {
+	traffic, ok := p.traffic[r.PathValue("email")]
+	if !ok {
+		reply(w, true, "", nil)
+		return
+	}
+	reply(w, true, "", traffic)
+}
+
+func reply(w http.ResponseWriter, success bool, msg string, obj interface{}) {
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": success, "msg": msg, "obj": obj})
+}
+
+func TestClient(t *testing.T) {
+	panel, server := newStubPanel(t)
+	client, err := NewClient(strings.Replace(server.URL, "http://", "http://admin:secret@", 1)+"/xui/", "vless-in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.TestAPI(); err != nil {
+		t.Fatal(err)
+	}
+
+	const email = "user_42@myserver"
+	expiresAt := time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC)
+	user := &models.User{
+		ID:           42,
+		UUID:         "11111111-1111-1111-1111-111111111111",
+		IPLimit:      2,
+		TrafficLimit: 50 << 30,
+		ExpiresAt:    &expiresAt,
+	}
+	if status, err := client.AddUser(user, email); err != nil || status != "applied" {
+		t.Fatalf("AddUser returned %q, %v", status, err)
+	}
+	if len(panel.clients) != 1 {
+		t.Fatalf("panel has %d clients, want 1", len(panel.clients))
+	}
+	added := panel.clients[0]
+	if added.ID != user.UUID || added.Email != email || added.TgID != 42 || !added.Enable || added.LimitIP != 2 {
+		t.Fatalf("unexpected client %+v", added)
+	}
+	if added.ExpiryTime != 1793534400000 || added.TotalGB != 53687091200 {
+		t.Fatalf("client expires at %d with %d bytes, want milliseconds and bytes", added.ExpiryTime, added.TotalGB)
+	}
+
+	// The panel restarted and forgot the session.
+	panel.expireSessions()
+	user.ExpiresAt, user.TrafficLimit = nil, 0
+	if err := client.UpdateUser(user, email); err != nil {
+		t.Fatal(err)
+	}
+	if panel.logins != 2 {
+		t.Fatalf("logged in %d times, want 2", panel.logins)
+	}
+	if updated := panel.clients[0]; updated.ExpiryTime != 0 || updated.TotalGB != 0 {
+		t.Fatalf("limits not lifted: %+v", updated)
+	}
+
+	// Adding an email the panel has already updates the client.
+	user.UUID = "22222222-2222-2222-2222-222222222222"
+	if _, err := client.AddUser(user, email); err != nil {
+		t.Fatal(err)
+	}
+	if len(panel.clients) != 1 || panel.clients[0].ID != user.UUID {
+		t.Fatalf("UUID not rotated: %+v", panel.clients)
+	}
+
+	if traffic, err := client.UserTraffic(email); err != nil || traffic != 0 {
+		t.Fatalf("UserTraffic of an unused client returned %d, %v", traffic, err)
+	}
+	panel.traffic[email] = clientTraffic{Up: 1000, Down: 24}
+	if traffic, err := client.UserTraffic(email); err != nil || traffic != 1024 {
+		t.Fatalf("UserTraffic returned %d, %v", traffic, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if status, err := client.RemoveUser(email); err != nil || status != "applied" {
+			t.Fatalf("RemoveUser %d returned %q, %v", i+1, status, err)
+		}
+	}
+	if len(panel.clients) != 0 {
+		t.Fatalf("client not removed: %+v", panel.clients)
+	}
+}
+
+func TestClientWrongCredentials(t *testing.T) {
+	_, server := newStubPanel(t)
+	client, err := NewClient(strings.Replace(server.URL, "http://", "http://admin:wrong@", 1)+"/xui", "vless-in")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.TestAPI(); err == nil || !strings.Contains(err.Error(), "wrong credentials") {
+		t.Fatalf("TestAPI with wrong credentials returned %v", err)
+	}
+}